@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/linkcheck"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/logging"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/shortener"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+Configuration comes from three places. Each setting is taken from the first
+one that has it:
+
+ 1. COMMAND-LINE FLAGS:     -addr :9090
+ 2. ENVIRONMENT VARIABLES:  URLSHORTENER_ADDR=:9090
+ 3. A JSON FILE, named with -config or URLSHORTENER_CONFIG:  {"addr": ":9090"}
+
+and otherwise keeps its default. This is the usual order for services: the
+file holds the settings of an installation, the environment those of one
+deployment (containers are configured this way), and a flag wins over
+everything, which is handy when trying something out.
+
+All three use the same names. Every setting is defined once, as a flag (see
+newFlagSet); the environment variable is the flag name in upper case with a
+prefix, and the JSON file is an object of flag names. Values from the
+environment and the file are handed to flag.FlagSet.Set, so they are parsed
+exactly like flags: durations are written "30s", lists "a,b" (or, in JSON, as
+an array).
+
+Secrets such as the admin token are better kept out of flags, since the
+command line of a process is visible to every user of the machine.
+*/
+
+// envPrefix is prepended to flag names to get environment variable names.
+const envPrefix = "URLSHORTENER_"
+
+// legacyEnv maps settings to the environment variables they used to be read
+// from. They still work, below the URLSHORTENER_ names.
+var legacyEnv = map[string]string{
+	"admin-token": "ADMIN_TOKEN",
+}
+
+// Config holds the application's configuration values.
+type Config struct {
+	Addr    string
+	BaseURL string
+	// DataDir is where the file-backed store keeps its log and snapshots.
+	// Leave it empty to use the in-memory store, which forgets everything on restart.
+	DataDir string
+	// MemoryShards, above 0, makes that in-memory store a ShardedStore with
+	// this many shards (rounded up to a power of two) instead of a URLStore
+	// with a single lock. Only worth it on many cores; measure first.
+	MemoryShards int
+	// DBDriver and DBDSN select a SQL database instead (e.g. "sqlite3" and
+	// "links.db"). The driver must be compiled in; see sqlite.go.
+	DBDriver string
+	DBDSN    string
+	// CacheSize is how many links the database store keeps cached in
+	// memory; 0 turns the cache off. Found links are cached for CacheTTL and
+	// unknown codes for CacheNegativeTTL (0 doesn't cache them). The other
+	// stores serve every lookup from memory already and are never cached.
+	CacheSize        int
+	CacheTTL         time.Duration
+	CacheNegativeTTL time.Duration
+	// ReapInterval is how often expired links are purged from the store.
+	ReapInterval time.Duration
+	// CodeStrategy selects how short codes are generated: "random",
+	// "sequential", "hash" or "hashids". CodeLength is the minimum code length,
+	// and HashidsSalt the secret used by the "hashids" strategy.
+	CodeStrategy string
+	CodeLength   int
+	HashidsSalt  string
+	// ClickBuffer is how many click events may wait for the analytics worker
+	// before new ones are dropped.
+	ClickBuffer int
+	// AdminToken is a bearer token that may manage every link.
+	// When empty, admin access is disabled.
+	AdminToken string
+	// KeysFile is where API keys (hashed) are stored. AllowAnonymous lets
+	// clients without an API key create links.
+	KeysFile       string
+	AllowAnonymous bool
+	// CreateLimit applies to the API (creating and managing links) and
+	// RedirectLimit to redirects, per API key or client IP. A Burst of 0
+	// turns a limit off.
+	CreateLimit   ratelimit.Limit
+	RedirectLimit ratelimit.Limit
+	// PasswordLimit throttles password attempts on each protected link.
+	PasswordLimit ratelimit.Limit
+	// CookieSecret signs the cookies that unlock protected links. When empty,
+	// a random secret is used, so unlocked links lock again after a restart.
+	CookieSecret string
+	// TrustedProxies lists the IPs or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header may be used to find the client IP.
+	TrustedProxies []string
+	// StripTrackingParams removes tracking parameters such as utm_source and
+	// fbclid from URLs before they are shortened.
+	StripTrackingParams bool
+	// BlocklistFile and AllowlistFile are domain list files for the
+	// destination policy; empty means no such list. AllowPrivateDestinations
+	// permits links to private and loopback addresses, and
+	// ResolveDestinations looks up host names to catch names that point at them.
+	BlocklistFile            string
+	AllowlistFile            string
+	AllowPrivateDestinations bool
+	ResolveDestinations      bool
+	// CheckInterval is how often every destination is checked for dead
+	// links; 0 turns the checks off. CheckConcurrency hosts are checked at a
+	// time, with CheckHostDelay between two requests to one host, each
+	// request taking at most CheckTimeout. A link is broken after
+	// CheckFailures failed checks in a row.
+	CheckInterval    time.Duration
+	CheckConcurrency int
+	CheckHostDelay   time.Duration
+	CheckTimeout     time.Duration
+	CheckFailures    int
+	// ReadTimeout, WriteTimeout and IdleTimeout bound how long a client may
+	// take to send a request, to receive the response, and how long an idle
+	// keep-alive connection stays open. Without them, slow or stalled clients
+	// could hold connections (and goroutines) open forever.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is how long in-flight requests get to finish on shutdown.
+	ShutdownTimeout time.Duration
+	// LogFormat is "text" or "json", and LogLevel the least severe level
+	// logged: "debug", "info", "warn" or "error".
+	LogFormat string
+	LogLevel  string
+}
+
+// defaultConfig returns the configuration the service runs with when nothing
+// else is configured.
+func defaultConfig() Config {
+	return Config{
+		Addr:         ":8080",
+		BaseURL:      "http://localhost:8080",
+		DataDir:      "data",
+		ReapInterval: time.Minute,
+		CodeStrategy: "random",
+		CodeLength:   shortener.DefaultCodeLength,
+		ClickBuffer:  4096,
+		KeysFile:     "data/apikeys.json",
+		// 30 new links per minute on average, in bursts of up to 10.
+		CreateLimit: ratelimit.PerMinute(30, 10),
+		// 20 redirects per second, in bursts of up to 50.
+		RedirectLimit: ratelimit.Limit{Rate: 20, Burst: 50},
+		// 5 password attempts per minute on each link, in bursts of up to 5.
+		PasswordLimit: ratelimit.PerMinute(5, 5),
+		// Look up destination host names, to refuse names of private addresses.
+		ResolveDestinations: true,
+		CheckInterval:       time.Hour,
+		CheckConcurrency:    linkcheck.DefaultConcurrency,
+		CheckHostDelay:      linkcheck.DefaultHostDelay,
+		CheckTimeout:        linkcheck.DefaultTimeout,
+		CheckFailures:       linkcheck.DefaultFailureThreshold,
+		CacheSize:           store.DefaultCacheOptions().Size,
+		CacheTTL:            store.DefaultCacheOptions().TTL,
+		CacheNegativeTTL:    store.DefaultCacheOptions().NegativeTTL,
+		ReadTimeout:         15 * time.Second,
+		WriteTimeout:        30 * time.Second,
+		IdleTimeout:         2 * time.Minute,
+		ShutdownTimeout:     15 * time.Second,
+		LogFormat:           logging.FormatText,
+		LogLevel:            "info",
+	}
+}
+
+// newFlagSet defines a flag for every setting, writing into cfg. The flag
+// named "config" (the JSON file) is written into configFile.
+func newFlagSet(cfg *Config, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("urlshortener", flag.ContinueOnError)
+	fs.StringVar(configFile, "config", "", "JSON file with settings; flags and environment variables override it")
+
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.BaseURL, "base-url", cfg.BaseURL, "public URL of the service, used to build short URLs")
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "directory of the file store; empty for an in-memory store")
+	fs.IntVar(&cfg.MemoryShards, "memory-shards", cfg.MemoryShards, "shards of the in-memory store; 0 for a single lock")
+	fs.StringVar(&cfg.DBDriver, "db-driver", cfg.DBDriver, "database/sql driver (sqlite3) instead of the file store")
+	fs.StringVar(&cfg.DBDSN, "db-dsn", cfg.DBDSN, "data source name for -db-driver")
+	fs.IntVar(&cfg.CacheSize, "cache-size", cfg.CacheSize, "links the database store keeps cached; 0 turns the cache off")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", cfg.CacheTTL, "how long a cached link is trusted")
+	fs.DurationVar(&cfg.CacheNegativeTTL, "cache-negative-ttl", cfg.CacheNegativeTTL, "how long an unknown code is remembered; 0 doesn't cache them")
+	fs.DurationVar(&cfg.ReapInterval, "reap-interval", cfg.ReapInterval, "how often expired links are purged")
+	fs.StringVar(&cfg.CodeStrategy, "code-strategy", cfg.CodeStrategy, "short code strategy: random, sequential, hash or hashids")
+	fs.IntVar(&cfg.CodeLength, "code-length", cfg.CodeLength, "minimum length of generated codes")
+	fs.StringVar(&cfg.HashidsSalt, "hashids-salt", cfg.HashidsSalt, "secret salt of the hashids strategy (prefer the environment)")
+	fs.IntVar(&cfg.ClickBuffer, "click-buffer", cfg.ClickBuffer, "click events that may wait for the analytics worker")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token with admin access (prefer the environment)")
+	fs.StringVar(&cfg.KeysFile, "keys-file", cfg.KeysFile, "file of hashed API keys")
+	fs.BoolVar(&cfg.AllowAnonymous, "allow-anonymous", cfg.AllowAnonymous, "let clients without an API key create links")
+	fs.Float64Var(&cfg.CreateLimit.Rate, "create-rate", cfg.CreateLimit.Rate, "API requests per second per client")
+	fs.IntVar(&cfg.CreateLimit.Burst, "create-burst", cfg.CreateLimit.Burst, "API request burst per client; 0 turns the limit off")
+	fs.Float64Var(&cfg.RedirectLimit.Rate, "redirect-rate", cfg.RedirectLimit.Rate, "redirects per second per client")
+	fs.IntVar(&cfg.RedirectLimit.Burst, "redirect-burst", cfg.RedirectLimit.Burst, "redirect burst per client; 0 turns the limit off")
+	fs.Float64Var(&cfg.PasswordLimit.Rate, "password-rate", cfg.PasswordLimit.Rate, "password attempts per second on each protected link")
+	fs.IntVar(&cfg.PasswordLimit.Burst, "password-burst", cfg.PasswordLimit.Burst, "password attempt burst on each protected link; 0 turns the limit off")
+	fs.StringVar(&cfg.CookieSecret, "cookie-secret", cfg.CookieSecret, "key that signs the cookies of unlocked links (prefer the environment)")
+	fs.Var((*listValue)(&cfg.TrustedProxies), "trusted-proxies", "comma-separated IPs or CIDR ranges of trusted reverse proxies")
+	fs.BoolVar(&cfg.StripTrackingParams, "strip-tracking-params", cfg.StripTrackingParams, "remove utm_* and fbclid parameters from URLs")
+	fs.StringVar(&cfg.BlocklistFile, "blocklist-file", cfg.BlocklistFile, "file of blocked destination domains")
+	fs.StringVar(&cfg.AllowlistFile, "allowlist-file", cfg.AllowlistFile, "file of the only destination domains allowed")
+	fs.BoolVar(&cfg.AllowPrivateDestinations, "allow-private-destinations", cfg.AllowPrivateDestinations, "allow links to private and loopback addresses")
+	fs.BoolVar(&cfg.ResolveDestinations, "resolve-destinations", cfg.ResolveDestinations, "look up destination hosts to refuse names of private addresses")
+	fs.DurationVar(&cfg.CheckInterval, "check-interval", cfg.CheckInterval, "how often destinations are checked for dead links; 0 turns checks off")
+	fs.IntVar(&cfg.CheckConcurrency, "check-concurrency", cfg.CheckConcurrency, "hosts checked at the same time")
+	fs.DurationVar(&cfg.CheckHostDelay, "check-host-delay", cfg.CheckHostDelay, "pause between two checks on the same host")
+	fs.DurationVar(&cfg.CheckTimeout, "check-timeout", cfg.CheckTimeout, "longest time one check may take")
+	fs.IntVar(&cfg.CheckFailures, "check-failures", cfg.CheckFailures, "failed checks in a row after which a link is broken")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "longest time to read a request")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "longest time to write a response")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "how long idle keep-alive connections stay open")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long in-flight requests get to finish on shutdown")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log output: text or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "least severe level logged: debug, info, warn or error")
+	return fs
+}
+
+// loadConfig builds the configuration from the defaults, the JSON file, the
+// environment and the command-line arguments, and validates it. getenv is
+// os.Getenv outside of tests.
+func loadConfig(args []string, getenv func(string) string, stderr io.Writer) (Config, error) {
+	// The flags are parsed twice. The first pass only finds the config file
+	// (and reports bad flags); the second one, after the file and the
+	// environment have been applied, lets the flags override them.
+	var configFile string
+	scratch := defaultConfig()
+	first := newFlagSet(&scratch, &configFile)
+	first.SetOutput(stderr)
+	if err := first.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if first.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected argument %q", first.Arg(0))
+	}
+	if configFile == "" {
+		configFile = getenv(envPrefix + "CONFIG")
+	}
+
+	cfg := defaultConfig()
+	fs := newFlagSet(&cfg, new(string))
+	fs.SetOutput(io.Discard)
+	if configFile != "" {
+		if err := applyFile(fs, configFile); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := applyEnv(fs, getenv); err != nil {
+		return Config{}, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// applyFile sets the flags named in a JSON config file.
+func applyFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	var settings map[string]any
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	for name, value := range settings {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case float64, bool:
+			s = fmt.Sprint(v)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			s = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("config file %s: %q must be a string, number, boolean or list", path, name)
+		}
+		if err := fs.Set(name, s); err != nil {
+			return fmt.Errorf("config file %s: %q: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv sets the flags that have an environment variable.
+func applyEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || err != nil {
+			return
+		}
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value := getenv(name)
+		if value == "" && legacyEnv[f.Name] != "" {
+			name = legacyEnv[f.Name]
+			value = getenv(name)
+		}
+		if value == "" {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// validate reports every problem with the configuration at once, so that
+// fixing a config file doesn't take one restart per mistake.
+func (cfg Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(cfg.Addr)
+	check(err == nil, "addr %q must be host:port, e.g. :8080", cfg.Addr)
+	base, err := url.Parse(cfg.BaseURL)
+	check(err == nil && (base.Scheme == "http" || base.Scheme == "https") && base.Host != "" && strings.Trim(base.Path, "/") == "",
+		"base-url %q must be an http or https URL without a path, e.g. https://sho.rt", cfg.BaseURL)
+	check(cfg.DBDriver == "" || cfg.DBDSN != "", "db-driver %q needs a db-dsn", cfg.DBDriver)
+	check(cfg.MemoryShards >= 0, "memory-shards must not be negative")
+	check(cfg.CacheSize >= 0, "cache-size must not be negative")
+	check(cfg.CacheSize == 0 || cfg.CacheTTL > 0, "cache-ttl must be positive")
+	check(cfg.CacheNegativeTTL >= 0, "cache-negative-ttl must not be negative")
+	check(cfg.ReapInterval > 0, "reap-interval must be positive")
+	check(cfg.CodeLength > 0, "code-length must be positive")
+	check(cfg.CodeStrategy != "hashids" || cfg.HashidsSalt != "", "the hashids code strategy needs a hashids-salt")
+	check(cfg.ClickBuffer > 0, "click-buffer must be positive")
+	check(cfg.KeysFile != "", "keys-file must not be empty")
+	check(cfg.CookieSecret == "" || len(cfg.CookieSecret) >= 32, "cookie-secret must be at least 32 characters long")
+	for _, l := range []struct {
+		name  string
+		limit ratelimit.Limit
+	}{{"create", cfg.CreateLimit}, {"redirect", cfg.RedirectLimit}, {"password", cfg.PasswordLimit}} {
+		check(l.limit.Burst >= 0, "%s-burst must not be negative", l.name)
+		check(l.limit.Burst == 0 || l.limit.Rate > 0, "%s-rate must be positive", l.name)
+	}
+	for _, t := range []struct {
+		name    string
+		timeout time.Duration
+	}{{"read", cfg.ReadTimeout}, {"write", cfg.WriteTimeout}, {"idle", cfg.IdleTimeout}, {"shutdown", cfg.ShutdownTimeout}} {
+		check(t.timeout > 0, "%s-timeout must be positive", t.name)
+	}
+	check(cfg.CheckInterval >= 0, "check-interval must not be negative")
+	if cfg.CheckInterval > 0 {
+		check(cfg.CheckConcurrency > 0, "check-concurrency must be positive")
+		check(cfg.CheckHostDelay > 0, "check-host-delay must be positive")
+		check(cfg.CheckTimeout > 0, "check-timeout must be positive")
+		check(cfg.CheckFailures > 0, "check-failures must be positive")
+	}
+	check(cfg.LogFormat == logging.FormatText || cfg.LogFormat == logging.FormatJSON, "log-format %q must be text or json", cfg.LogFormat)
+	_, err = logging.ParseLevel(cfg.LogLevel)
+	check(err == nil, "log-level %q must be debug, info, warn or error", cfg.LogLevel)
+	return errors.Join(errs...)
+}
+
+// listValue is a flag.Value for comma-separated lists.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
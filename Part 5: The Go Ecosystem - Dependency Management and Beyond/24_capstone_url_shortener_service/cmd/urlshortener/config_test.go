@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, env(nil), io.Discard)
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, defaultConfig()) {
+		t.Errorf("loadConfig() = %+v; want the defaults %+v", cfg, defaultConfig())
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"addr": ":7000",
+		"base-url": "https://file.example/",
+		"read-timeout": "3s",
+		"code-length": 9,
+		"allow-anonymous": true,
+		"trusted-proxies": ["10.0.0.0/8", "192.168.1.1"]
+	}`)
+	vars := map[string]string{
+		"URLSHORTENER_CONFIG":   path,
+		"URLSHORTENER_BASE_URL": "https://env.example",
+		"URLSHORTENER_ADDR":     ":8000",
+		"ADMIN_TOKEN":           "legacy",
+	}
+	cfg, err := loadConfig([]string{"-addr", ":9000"}, env(vars), io.Discard)
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"Addr (flag over env and file)", cfg.Addr, ":9000"},
+		{"BaseURL (env over file)", cfg.BaseURL, "https://env.example"},
+		{"ReadTimeout (file)", cfg.ReadTimeout, 3 * time.Second},
+		{"CodeLength (file)", cfg.CodeLength, 9},
+		{"AllowAnonymous (file)", cfg.AllowAnonymous, true},
+		{"TrustedProxies (file)", cfg.TrustedProxies, []string{"10.0.0.0/8", "192.168.1.1"}},
+		{"AdminToken (legacy env)", cfg.AdminToken, "legacy"},
+		{"WriteTimeout (default)", cfg.WriteTimeout, defaultConfig().WriteTimeout},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %v; want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// A -config flag beats URLSHORTENER_CONFIG.
+	other := writeConfigFile(t, `{"addr": ":7001"}`)
+	delete(vars, "URLSHORTENER_ADDR")
+	cfg, err = loadConfig([]string{"-config", other}, env(vars), io.Discard)
+	if err != nil || cfg.Addr != ":7001" {
+		t.Errorf("with -config: Addr = %q, %v; want %q", cfg.Addr, err, ":7001")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+		file string
+		want string
+	}{
+		{name: "bad flag", args: []string{"-nope"}, want: "flag provided but not defined"},
+		{name: "bad env value", vars: map[string]string{"URLSHORTENER_CODE_LENGTH": "long"}, want: "URLSHORTENER_CODE_LENGTH"},
+		{name: "unknown file setting", file: `{"adress": ":80"}`, want: `unknown setting "adress"`},
+		{name: "bad file value", file: `{"idle-timeout": 30}`, want: `"idle-timeout"`},
+		{name: "bad base URL", args: []string{"-base-url", "sho.rt"}, want: "base-url"},
+		{name: "bad addr", args: []string{"-addr", "8080"}, want: "addr"},
+		{name: "dsn missing", args: []string{"-db-driver", "sqlite3"}, want: "db-dsn"},
+		{name: "negative shards", args: []string{"-memory-shards", "-1"}, want: "memory-shards"},
+		{name: "zero timeout", args: []string{"-write-timeout", "0s"}, want: "write-timeout"},
+		{name: "short cookie secret", vars: map[string]string{"URLSHORTENER_COOKIE_SECRET": "hunter2"}, want: "cookie-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := tt.vars
+			if tt.file != "" {
+				vars = map[string]string{"URLSHORTENER_CONFIG": writeConfigFile(t, tt.file)}
+			}
+			_, err := loadConfig(tt.args, env(vars), io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig() error = %v; want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ClickBuffer = 0
+	cfg.CodeLength = 0
+	err := cfg.validate()
+	if err == nil || !strings.Contains(err.Error(), "click-buffer") || !strings.Contains(err.Error(), "code-length") {
+		t.Errorf("validate() = %v; want both problems reported", err)
+	}
+}
@@ -1,14 +1,34 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
 
 	// --- CORRECTED IMPORT PATHS ---
 	// These paths now reflect the full module path defined in the root go.mod file.
 	// This allows the Go toolchain to find our internal packages correctly.
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/analytics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/apikey"
 	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/handler"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/linkcheck"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/logging"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/metrics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/shortener"
 	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
 )
 
@@ -21,47 +41,373 @@ import (
 This is main.go, the entry point for our entire application.
 
 Its primary responsibility is "wiring up" the application:
-1.  CONFIGURATION: Setting up server configuration (like the address).
+1.  CONFIGURATION: Loading settings from flags, the environment and a config file (see config.go).
 2.  DEPENDENCY CREATION: Initializing all the core components (logger, data store, handlers).
 3.  ROUTING: Mapping URL paths to their corresponding handler functions.
-4.  SERVER STARTUP: Starting the HTTP server to listen for requests.
+4.  SERVER STARTUP: Starting the HTTP server and background workers.
+5.  SHUTDOWN: Stopping everything cleanly when the process is asked to exit.
 
 This separation of concerns—where `main` handles setup and other packages handle
 the logic—is a hallmark of professional Go applications.
-*/
 
-// Config holds the application's configuration values.
-type Config struct {
-	Addr    string
-	BaseURL string
-}
+The same binary also has subcommands for managing API keys (see keys.go) and
+for exporting and importing links (see transfer.go):
+
+	go run . keys create -name acme
+	go run . export -o links.jsonl
+*/
 
 func main() {
 	// --- 1. Configuration ---
-	cfg := Config{
-		Addr:    ":8080",
-		BaseURL: "http://localhost:8080",
+	// See config.go for where settings come from. The subcommands have
+	// flags of their own, so they only read the config file and the environment.
+	args := os.Args[1:]
+	subcommand := ""
+	if len(args) > 0 && (args[0] == "keys" || args[0] == "export" || args[0] == "import") {
+		subcommand, args = args[0], nil
+	}
+	cfg, err := loadConfig(args, os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	// The config was validated, so the log format and level are known to be good.
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	switch subcommand {
+	case "keys":
+		os.Exit(runKeys(cfg, os.Args[2:]))
+	case "export", "import":
+		// An export may be written to standard output, so logs go to stderr.
+		logger, _ := logging.New(os.Stderr, cfg.LogFormat, level)
+		run := runExport
+		if subcommand == "import" {
+			run = runImport
+		}
+		os.Exit(run(cfg, logger, os.Args[2:]))
 	}
 
 	// --- 2. Dependency Creation ---
-	logger := log.New(os.Stdout, "[INFO] ", log.LstdFlags)
-	urlStore := store.NewURLStore()
-	h := handler.NewHandler(logger, urlStore, cfg.BaseURL)
+	logger, _ := logging.New(os.Stdout, cfg.LogFormat, level)
+	// fatal logs an error and exits. slog has no Fatal, because exiting is
+	// the program's decision, not the logger's.
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "error", err)
+		os.Exit(1)
+	}
+	urlStore, err := openStore(cfg, logger)
+	if err != nil {
+		fatal("Failed to open store", err)
+	}
+	codes, err := newGenerator(cfg)
+	if err != nil {
+		fatal("Failed to create code generator", err)
+	}
+	keys, err := apikey.Open(cfg.KeysFile)
+	if err != nil {
+		fatal("Failed to load API keys", err)
+	}
+	proxies, err := handler.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	destinations, err := newPolicy(cfg)
+	if err != nil {
+		fatal("Failed to load destination policy", err)
+	}
+	clicks := analytics.NewTracker(cfg.ClickBuffer)
+	registry := metrics.NewRegistry()
+	registry.RegisterRuntime()
+	passwordLimiter := newLimiter(cfg.PasswordLimit)
+	createLimiter := newLimiter(cfg.CreateLimit)
+	redirectLimiter := newLimiter(cfg.RedirectLimit)
+	checker := newChecker(cfg, urlStore, registry)
+	if cached, ok := urlStore.(*store.CachedStore); ok {
+		registerCacheMetrics(registry, cached)
+	}
+	h := handler.NewHandler(logger, urlStore, codes, clicks, keys, handler.Options{
+		BaseURL:             cfg.BaseURL,
+		AdminToken:          cfg.AdminToken,
+		AllowAnonymous:      cfg.AllowAnonymous,
+		TrustedProxies:      proxies,
+		StripTrackingParams: cfg.StripTrackingParams,
+		Policy:              destinations,
+		Metrics:             registry,
+		PasswordLimiter:     passwordLimiter,
+		CreateLimiter:       createLimiter,
+		CookieSecret:        []byte(cfg.CookieSecret),
+		Checker:             checker,
+	})
+	if err := h.ResumeCodes(context.Background()); err != nil {
+		fatal("Failed to resume code generator", err)
+	}
 
 	// --- 3. Routing ---
+	// Each route is wrapped in the rate-limit middleware with its own limiter,
+	// so heavy redirect traffic never uses up a client's API allowance.
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", h.RedirectHandler)
-	mux.HandleFunc("/api/shorten", h.ShortenURLHandler)
+	mux.Handle("/", h.RateLimit(redirectLimiter, http.HandlerFunc(h.RedirectHandler)))
+	mux.Handle("/api/shorten", h.RateLimit(createLimiter, http.HandlerFunc(h.ShortenURLHandler)))
+	mux.Handle("/api/shorten/batch", h.RateLimit(createLimiter, http.HandlerFunc(h.BatchShortenHandler)))
+	mux.Handle("/api/links", h.RateLimit(createLimiter, http.HandlerFunc(h.LinksHandler)))
+	mux.Handle("/api/links/", h.RateLimit(createLimiter, http.HandlerFunc(h.LinksHandler)))
+	mux.Handle("/api/admin/export", h.RateLimit(createLimiter, http.HandlerFunc(h.ExportHandler)))
+	mux.Handle("/api/admin/import", h.RateLimit(createLimiter, http.HandlerFunc(h.ImportHandler)))
+	mux.Handle("/metrics", registry.Handler())
+	// The web UI (see handler/web.go). Submitting the form creates a link,
+	// so it counts against the same allowance as the API.
+	mux.Handle("POST /{$}", h.RateLimit(createLimiter, http.HandlerFunc(h.HomeHandler)))
+	mux.Handle("/links", h.RateLimit(redirectLimiter, http.HandlerFunc(h.WebLinksHandler)))
+	mux.Handle("/links/", h.RateLimit(redirectLimiter, http.HandlerFunc(h.WebLinksHandler)))
+	mux.Handle("/static/", h.StaticHandler())
+	// Instrument wraps the whole mux, so every route, including ones added
+	// later, is counted and timed.
+	// AccessLog goes outside of everything, so the request ID it assigns is
+	// known to every handler and the access log times the whole request.
+	routes := h.AccessLog(registry.Instrument(mux))
 
-	// --- 4. Server Startup ---
-	logger.Printf("Server starting on %s", cfg.BaseURL)
+	// --- 4. Server and Background Workers ---
+	// `ctx` is cancelled when the process receives Ctrl+C (SIGINT) or SIGTERM.
+	// Every background goroutine watches it so it knows when to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		store.RunReaper(ctx, urlStore, cfg.ReapInterval, func(purged int, err error) {
+			if err != nil {
+				logger.Error("Failed to purge expired links", "error", err)
+			} else if purged > 0 {
+				logger.Info("Purged expired links", "count", purged)
+				// The click statistics of the purged links go with them.
+				clicks.Retain(func(code string) bool {
+					_, err := urlStore.Get(code)
+					return !errors.Is(err, store.ErrNotFound)
+				})
+			}
+		})
+	}()
+	// The evictors forget clients that have been idle long enough to have a
+	// full bucket again, so the limiters' memory stays bounded.
+	for _, l := range []*ratelimit.Limiter{createLimiter, redirectLimiter, passwordLimiter} {
+		if l == nil {
+			continue
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			l.RunEvictor(ctx, time.Minute)
+		}()
+	}
+
+	if checker != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			checker.Run(ctx, cfg.CheckInterval, func(round linkcheck.Round, err error) {
+				if err != nil {
+					logger.Error("Failed to check links", "error", err)
+					return
+				}
+				logger.Info("Checked links", "destinations", round.Checked, "broken", round.Broken, "duration", round.Duration)
+			})
+		}()
+	}
+
+	if cfg.AdminToken == "" {
+		logger.Warn("No admin token is set (URLSHORTENER_ADMIN_TOKEN); admin access is disabled")
+	}
+	if !cfg.AllowAnonymous && len(keys.List()) == 0 {
+		logger.Warn("No API keys yet; create one with `go run . keys create -name <name>`")
+	}
+	logger.Info("Server starting", "addr", cfg.Addr, "base_url", cfg.BaseURL)
 	server := &http.Server{
 		Addr:    cfg.Addr,
-		Handler: mux,
+		Handler: routes,
+		// ReadHeaderTimeout is the part of ReadTimeout that matters most: a
+		// client that trickles in its headers a byte at a time (a "slowloris"
+		// attack) is cut off early, before a handler is even chosen.
+		ReadHeaderTimeout: min(cfg.ReadTimeout, 5*time.Second),
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	// ListenAndServe blocks, so we run it in its own goroutine and wait for
+	// either a server error or a shutdown signal.
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Server failed", "error", err)
+		}
+		stop()
+	case <-ctx.Done():
+		// Restore the default signal handling, so a second Ctrl+C kills the
+		// process right away if a graceful shutdown takes too long.
+		stop()
+		logger.Info("Shutting down (press Ctrl+C again to force)")
+	}
+
+	// --- 5. Shutdown ---
+	// The order matters. First, stop accepting requests and wait (up to
+	// ShutdownTimeout) for in-flight ones, so no handler is still writing to
+	// the store or recording clicks. Connections still busy after that are
+	// closed. Then wait for the background workers, let the analytics worker
+	// drain its buffered clicks, and finally close the store, which syncs
+	// everything it has written to disk.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Requests still running, closing them", "timeout", cfg.ShutdownTimeout, "error", err)
+		server.Close()
+	}
+	workers.Wait()
+	clicks.Close()
+	if err := urlStore.Close(); err != nil {
+		logger.Error("Failed to close store", "error", err)
+	}
+	logger.Info("Server stopped")
+}
+
+// newPolicy builds the destination policy from the configuration.
+func newPolicy(cfg Config) (*policy.Policy, error) {
+	opts := policy.Options{
+		BlocklistFile: cfg.BlocklistFile,
+		AllowlistFile: cfg.AllowlistFile,
+		AllowPrivate:  cfg.AllowPrivateDestinations,
+		BaseURL:       cfg.BaseURL,
+	}
+	if cfg.ResolveDestinations {
+		opts.LookupIP = func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		}
+	}
+	return policy.New(opts)
+}
+
+// newChecker creates the dead-link checker, or returns nil if checks are
+// turned off. It also registers the number of broken links as a metric.
+func newChecker(cfg Config, s store.Store, registry *metrics.Registry) *linkcheck.Checker {
+	if cfg.CheckInterval <= 0 {
+		return nil
+	}
+	checker := linkcheck.New(s, linkcheck.Options{
+		Concurrency:      cfg.CheckConcurrency,
+		HostDelay:        cfg.CheckHostDelay,
+		Timeout:          cfg.CheckTimeout,
+		FailureThreshold: cfg.CheckFailures,
+		AllowPrivate:     cfg.AllowPrivateDestinations,
+	})
+	registry.NewGaugeFunc("urlshortener_broken_links", "Links whose destination failed its last checks.", func() float64 {
+		return float64(checker.BrokenCount())
+	})
+	return checker
+}
+
+// registerCacheMetrics reports the link cache's statistics as metrics. The
+// hit ratio is hits / (hits + misses).
+func registerCacheMetrics(registry *metrics.Registry, cache *store.CachedStore) {
+	for _, m := range []struct {
+		name, help string
+		value      func(store.CacheStats) uint64
+	}{
+		{"urlshortener_cache_hits_total", "Link lookups answered from the cache.", func(s store.CacheStats) uint64 { return s.Hits }},
+		{"urlshortener_cache_negative_hits_total", "Lookups of unknown codes answered from the cache.", func(s store.CacheStats) uint64 { return s.NegativeHits }},
+		{"urlshortener_cache_misses_total", "Link lookups that went to the store.", func(s store.CacheStats) uint64 { return s.Misses }},
+		{"urlshortener_cache_shared_total", "Link lookups that waited for the same lookup by another request.", func(s store.CacheStats) uint64 { return s.Shared }},
+		{"urlshortener_cache_evictions_total", "Cached links dropped to make room for others.", func(s store.CacheStats) uint64 { return s.Evictions }},
+	} {
+		registry.NewCounterFunc(m.name, m.help, func() float64 { return float64(m.value(cache.Stats())) })
+	}
+	registry.NewGaugeFunc("urlshortener_cache_entries", "Links and unknown codes in the cache.", func() float64 {
+		return float64(cache.Stats().Entries)
+	})
+}
+
+// newLimiter creates a rate limiter, or returns nil if the limit is turned off.
+func newLimiter(limit ratelimit.Limit) *ratelimit.Limiter {
+	if limit.Burst <= 0 {
+		return nil
 	}
+	return ratelimit.New(limit)
+}
+
+// newGenerator builds the configured short-code generator. Counter-based
+// strategies are moved past the stored codes later, by Handler.ResumeCodes.
+func newGenerator(cfg Config) (shortener.Generator, error) {
+	return shortener.New(cfg.CodeStrategy, shortener.Options{
+		MinLength: cfg.CodeLength,
+		Salt:      cfg.HashidsSalt,
+	})
+}
 
-	err := server.ListenAndServe()
+// openStore picks the storage backend based on the configuration.
+func openStore(cfg Config, logger *slog.Logger) (store.Store, error) {
+	if cfg.DBDriver != "" {
+		s, err := openSQLStore(cfg, logger)
+		if err != nil || cfg.CacheSize == 0 {
+			return s, err
+		}
+		// Every lookup in the database is a round trip; the cache saves most
+		// of them for popular links (see store/cache.go).
+		return store.NewCachedStore(s, store.CacheOptions{
+			Size:        cfg.CacheSize,
+			TTL:         cfg.CacheTTL,
+			NegativeTTL: cfg.CacheNegativeTTL,
+		}), nil
+	}
+	if cfg.DataDir == "" {
+		logger.Warn("Using in-memory store; links will be lost on restart")
+		// The ShardedStore can only pay off with many cores and heavy write
+		// traffic; see BenchmarkStores before turning it on.
+		if cfg.MemoryShards > 0 {
+			logger.Info("Using sharded in-memory store", "shards", cfg.MemoryShards)
+			return store.NewShardedStore(cfg.MemoryShards), nil
+		}
+		return store.NewURLStore(), nil
+	}
+	opts := store.DefaultFileOptions()
+	opts.OnError = func(err error) {
+		logger.Error("Store background task failed", "error", err)
+	}
+	logger.Info("Using file store", "dir", cfg.DataDir)
+	return store.OpenFileStore(cfg.DataDir, opts)
+}
+
+// openSQLStore connects to the configured database and runs its migrations.
+func openSQLStore(cfg Config, logger *slog.Logger) (store.Store, error) {
+	// The name in the configuration is the database's; the driver may have
+	// registered itself with database/sql under another one.
+	var driver string
+	var dialect store.Dialect
+	switch cfg.DBDriver {
+	case "sqlite", "sqlite3":
+		driver, dialect = "sqlite3", store.DialectSQLite
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q (only sqlite3 is supported)", cfg.DBDriver)
+	}
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("database driver %q is not compiled in; build with -tags sqlite", driver)
+	}
+
+	db, err := sql.Open(driver, cfg.DBDSN)
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.OpenSQLStore(db, dialect)
 	if err != nil {
-		logger.Fatalf("Server failed to start: %v", err)
+		db.Close()
+		return nil, err
 	}
+	logger.Info("Using database store", "driver", cfg.DBDriver)
+	return s, nil
 }
@@ -0,0 +1,14 @@
+//go:build sqlite
+
+package main
+
+/*
+This file is only compiled when you build with `-tags sqlite`, e.g.:
+
+	go run -tags sqlite .
+
+It registers the SQLite driver with database/sql. The default build stays free
+of third-party dependencies (and of cgo, which this driver needs).
+*/
+
+import _ "github.com/mattn/go-sqlite3" // Registers the "sqlite3" driver.
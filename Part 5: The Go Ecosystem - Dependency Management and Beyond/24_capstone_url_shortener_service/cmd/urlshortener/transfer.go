@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/transfer"
+)
+
+/*
+The `export` and `import` subcommands copy links between the configured store
+and a file, for backups and for moving links between environments:
+
+	go run . export -o links.jsonl
+	go run . import -conflict overwrite -dry-run links.csv
+
+They open the same store the server would (data directory or database, from
+the environment or the config file), so they must not be pointed at a file
+store that a running server is using: two processes appending to one log
+would corrupt it. For a running server, use GET /api/admin/export and
+POST /api/admin/import instead. A database can be shared safely.
+*/
+
+const exportUsage = `usage:
+  urlshortener export [-format jsonl|csv] [-o file]
+`
+
+const importUsage = `usage:
+  urlshortener import [-format jsonl|csv] [-conflict skip|overwrite|fail] [-dry-run] <file or ->
+`
+
+// runExport runs the `export` subcommand and returns the process exit code.
+func runExport(cfg Config, logger *slog.Logger, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, exportUsage); fs.PrintDefaults() }
+	formatName := fs.String("format", "", "file format: jsonl or csv (default: from the -o file name, else jsonl)")
+	output := fs.String("o", "-", "file to write, or - for standard output")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	format, err := fileFormat(*formatName, *output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+
+	s, code := openTransferStore(cfg, logger)
+	if s == nil {
+		return code
+	}
+	defer s.Close()
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	n, err := transfer.Export(context.Background(), out, s, format)
+	if err == nil && out != os.Stdout {
+		// Close reports write errors that were still pending in the file.
+		err = out.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export links: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d links.\n", n)
+	return 0
+}
+
+// runImport runs the `import` subcommand and returns the process exit code.
+func runImport(cfg Config, logger *slog.Logger, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, importUsage); fs.PrintDefaults() }
+	formatName := fs.String("format", "", "file format: jsonl or csv (default: from the file name, else jsonl)")
+	conflict := fs.String("conflict", string(transfer.ConflictSkip), "what to do with codes that exist already: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "check the file and report what would change, without changing anything")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	input := fs.Arg(0)
+	format, err := fileFormat(*formatName, input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 2
+	}
+	opts := transfer.ImportOptions{DryRun: *dryRun}
+	if opts.Conflict, err = transfer.ParseConflict(*conflict); err != nil {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 2
+	}
+
+	in := io.Reader(os.Stdin)
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", input, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	s, code := openTransferStore(cfg, logger)
+	if s == nil {
+		return code
+	}
+	defer s.Close()
+
+	res, err := transfer.Import(context.Background(), s, in, format, opts)
+	switch {
+	case errors.Is(err, transfer.ErrConflict):
+		fmt.Fprintf(os.Stderr, "Nothing imported: %v: %v\n", err, res.Conflicts)
+		return 1
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Failed to import %s: %v\n", input, err)
+		return 1
+	}
+
+	verb := "Imported"
+	if res.DryRun {
+		verb = "Dry run, nothing changed. Would import"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d links: %d created, %d overwritten, %d skipped, %d unchanged.\n",
+		verb, res.Read, res.Created, res.Overwritten, res.Skipped, res.Unchanged)
+	if len(res.Conflicts) > 0 {
+		fmt.Fprintf(os.Stderr, "Codes that already existed with a different link: %v\n", res.Conflicts)
+	}
+	return 0
+}
+
+// fileFormat returns the named format or, if there is no name, the one the
+// file name's extension suggests.
+func fileFormat(name, file string) (transfer.Format, error) {
+	if name != "" {
+		return transfer.ParseFormat(name)
+	}
+	if filepath.Ext(file) == ".csv" {
+		return transfer.CSV, nil
+	}
+	return transfer.JSONL, nil
+}
+
+// openTransferStore opens the configured store for export or import. An
+// in-memory store would always be empty, so it is refused. If the store
+// can't be opened, it returns nil and the exit code.
+func openTransferStore(cfg Config, logger *slog.Logger) (store.Store, int) {
+	if cfg.DataDir == "" && cfg.DBDriver == "" {
+		fmt.Fprintln(os.Stderr, "No store configured: set URLSHORTENER_DATA_DIR or URLSHORTENER_DB_DRIVER (or use a config file).")
+		return nil, 2
+	}
+	s, err := openStore(cfg, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return nil, 1
+	}
+	return s, 0
+}
@@ -0,0 +1,373 @@
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+This is the analytics package. It records every redirect as a CLICK EVENT and
+aggregates the events into statistics per short code.
+
+Redirects are the hot path of a URL shortener, so recording a click must never
+slow one down. We use a classic producer/consumer PIPELINE:
+
+	RedirectHandler --Record()--> [ buffered channel ] --> worker goroutine --> aggregates
+
+`Record` only tries to put the event into a buffered channel. If the buffer is
+full (the worker has fallen behind), the event is DROPPED and counted, instead
+of making the user's redirect wait. A single worker goroutine owns all the
+aggregation work, so the handlers never contend for the aggregation lock.
+
+Clicks on a split link (an A/B test) carry the VARIANT the visitor was sent
+to, and Stats counts clicks and unique visitors per variant, so the variants
+can be compared with each other.
+
+Clients choose their Referer header and their user agent, so nothing they send
+may make the aggregates grow without bound. The memory of each link is capped:
+buckets are pruned after their retention period, unique visitors are counted
+in a fixed-size sketch (see visitors.go), and only the first MaxReferrers
+referring sites are counted by name. The codes themselves are bounded by the
+store: Forget drops the statistics of a deleted link, and Retain those of the
+links that expired.
+
+The statistics live in memory only, even when the links are in a durable
+store: they start from zero when the process restarts, and every instance
+behind a load balancer counts its own clicks. Clicks are events, and a
+service that must keep them would write them to a log or a time-series
+database instead.
+*/
+
+const (
+	// HourlyRetention is how long hourly buckets are kept.
+	HourlyRetention = 48 * time.Hour
+	// DailyRetention is how long daily buckets are kept.
+	DailyRetention = 90 * 24 * time.Hour
+	// TopReferrersLimit is the number of referrers reported in Stats.
+	TopReferrersLimit = 10
+	// MaxReferrers is the number of distinct referring sites counted per
+	// link. Clicks from any further site are counted as otherReferrer.
+	MaxReferrers = 100
+
+	// directReferrer is reported for clicks without a Referer header, and
+	// otherReferrer for the rest: unparseable headers and sites past MaxReferrers.
+	directReferrer = "(direct)"
+	otherReferrer  = "(other)"
+	// maxHostLength is the longest host name DNS allows.
+	maxHostLength = 253
+)
+
+// Click is a single redirect event.
+type Click struct {
+	Code      string
+	Time      time.Time
+	Referrer  string
+	UserAgent string
+	IP        string
+	// Variant is the name of the variant a click on a split link went to.
+	Variant string
+}
+
+// Bucket is the number of clicks in one hour or one day.
+type Bucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// ReferrerCount is the number of clicks that came from one referring site.
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Clicks   int    `json:"clicks"`
+}
+
+// VariantCount is the number of clicks, and of unique visitors, that one
+// variant of a split link received.
+type VariantCount struct {
+	Variant        string `json:"variant"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors int    `json:"unique_visitors"`
+}
+
+// Stats is the aggregated view of the clicks on one short code.
+type Stats struct {
+	Code           string          `json:"code"`
+	TotalClicks    int             `json:"total_clicks"`
+	UniqueVisitors int             `json:"unique_visitors"`
+	Hourly         []Bucket        `json:"hourly"`
+	Daily          []Bucket        `json:"daily"`
+	TopReferrers   []ReferrerCount `json:"top_referrers"`
+	// Variants is set for split links only, sorted by variant name.
+	Variants []VariantCount `json:"variants,omitempty"`
+}
+
+// linkStats holds the running aggregates for one code.
+type linkStats struct {
+	total     int
+	hourly    map[time.Time]int
+	daily     map[time.Time]int
+	referrers map[string]int
+	// visitors counts a 64-bit hash of each visitor's IP and user agent,
+	// which is much smaller than keeping the strings themselves.
+	visitors visitorSet
+	// variants holds the clicks and visitors of each variant of a split
+	// link. It stays nil for other links.
+	variants map[string]*variantStats
+}
+
+// variantStats holds the running aggregates for one variant.
+type variantStats struct {
+	clicks   int
+	visitors visitorSet
+}
+
+func newLinkStats() *linkStats {
+	return &linkStats{
+		hourly:    make(map[time.Time]int),
+		daily:     make(map[time.Time]int),
+		referrers: make(map[string]int),
+	}
+}
+
+// Tracker receives click events and aggregates them in the background.
+type Tracker struct {
+	events  chan Click
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+
+	mu    sync.RWMutex
+	links map[string]*linkStats
+}
+
+// NewTracker creates a Tracker whose pipeline buffers up to bufferSize events,
+// and starts its worker goroutine. Call Close to stop it.
+func NewTracker(bufferSize int) *Tracker {
+	t := &Tracker{
+		events: make(chan Click, bufferSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		links:  make(map[string]*linkStats),
+	}
+	go t.run()
+	return t
+}
+
+// Record queues a click without blocking. It returns false if the event was
+// dropped because the buffer is full or the tracker is closed.
+func (t *Tracker) Record(c Click) bool {
+	select {
+	case <-t.stop:
+		return false
+	default:
+	}
+	select {
+	case t.events <- c:
+		return true
+	default:
+		t.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns how many clicks were discarded because the buffer was full.
+func (t *Tracker) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// Close stops accepting clicks, processes those still buffered, and waits
+// for the worker goroutine to exit.
+func (t *Tracker) Close() {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+// run is the worker goroutine: the only consumer of the events channel.
+func (t *Tracker) run() {
+	defer close(t.done)
+	for {
+		select {
+		case c := <-t.events:
+			t.add(c)
+		case <-t.stop:
+			// Drain whatever is still buffered so no recorded click is lost.
+			for {
+				select {
+				case c := <-t.events:
+					t.add(c)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// add folds one click into the aggregates.
+func (t *Tracker) add(c Click) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ls, ok := t.links[c.Code]
+	if !ok {
+		ls = newLinkStats()
+		t.links[c.Code] = ls
+	}
+
+	visitor := visitorID(c.IP, c.UserAgent)
+	ls.total++
+	ref := referrerHost(c.Referrer)
+	if _, counted := ls.referrers[ref]; !counted && len(ls.referrers) >= MaxReferrers {
+		ref = otherReferrer
+	}
+	ls.referrers[ref]++
+	ls.visitors.add(visitor)
+	if c.Variant != "" {
+		if ls.variants == nil {
+			ls.variants = make(map[string]*variantStats)
+		}
+		vs, ok := ls.variants[c.Variant]
+		if !ok {
+			vs = &variantStats{}
+			ls.variants[c.Variant] = vs
+		}
+		vs.clicks++
+		vs.visitors.add(visitor)
+	}
+
+	hour := c.Time.UTC().Truncate(time.Hour)
+	if _, exists := ls.hourly[hour]; !exists {
+		// A new bucket only appears once an hour, so that's a cheap moment
+		// to drop the buckets that have fallen out of the retention window.
+		prune(ls.hourly, c.Time.Add(-HourlyRetention))
+	}
+	ls.hourly[hour]++
+
+	day := startOfDay(c.Time)
+	if _, exists := ls.daily[day]; !exists {
+		prune(ls.daily, c.Time.Add(-DailyRetention))
+	}
+	ls.daily[day]++
+}
+
+// Stats returns the aggregated statistics for a code. Codes without any
+// clicks get zero totals and empty lists.
+func (t *Tracker) Stats(code string) Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := Stats{
+		Code:         code,
+		Hourly:       []Bucket{},
+		Daily:        []Bucket{},
+		TopReferrers: []ReferrerCount{},
+	}
+	ls, ok := t.links[code]
+	if !ok {
+		return stats
+	}
+
+	stats.TotalClicks = ls.total
+	stats.UniqueVisitors = ls.visitors.count()
+	stats.Hourly = sortedBuckets(ls.hourly)
+	stats.Daily = sortedBuckets(ls.daily)
+
+	for ref, n := range ls.referrers {
+		stats.TopReferrers = append(stats.TopReferrers, ReferrerCount{Referrer: ref, Clicks: n})
+	}
+	sort.Slice(stats.TopReferrers, func(i, j int) bool {
+		a, b := stats.TopReferrers[i], stats.TopReferrers[j]
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.Referrer < b.Referrer
+	})
+	if len(stats.TopReferrers) > TopReferrersLimit {
+		stats.TopReferrers = stats.TopReferrers[:TopReferrersLimit]
+	}
+
+	for name, vs := range ls.variants {
+		stats.Variants = append(stats.Variants, VariantCount{Variant: name, Clicks: vs.clicks, UniqueVisitors: vs.visitors.count()})
+	}
+	sort.Slice(stats.Variants, func(i, j int) bool { return stats.Variants[i].Variant < stats.Variants[j].Variant })
+	return stats
+}
+
+// Forget drops the statistics of a code, once its link is deleted. A click
+// still waiting in the pipeline may bring a few of them back; Retain cleans
+// those up later.
+func (t *Tracker) Forget(code string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.links, code)
+}
+
+// Retain drops the statistics of every code for which keep returns false,
+// and reports how many it dropped. keep is called without holding the
+// tracker's lock, so it may look the code up in a database without holding
+// up the worker.
+func (t *Tracker) Retain(keep func(code string) bool) int {
+	t.mu.RLock()
+	codes := make([]string, 0, len(t.links))
+	for code := range t.links {
+		codes = append(codes, code)
+	}
+	t.mu.RUnlock()
+
+	n := 0
+	for _, code := range codes {
+		if !keep(code) {
+			t.Forget(code)
+			n++
+		}
+	}
+	return n
+}
+
+// referrerHost reduces a Referer header to the site it came from, so that
+// every page of the same site is counted together. Headers that name no
+// plausible site count as otherReferrer, so that clients can't make up keys.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" || len(u.Host) > maxHostLength {
+		return otherReferrer
+	}
+	return u.Host
+}
+
+// visitorID approximates a unique visitor by hashing their IP and user agent.
+func visitorID(ip, userAgent string) uint64 {
+	sum := sha256.Sum256([]byte(ip + "|" + userAgent))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// prune deletes every bucket that started before the cutoff.
+func prune(buckets map[time.Time]int, cutoff time.Time) {
+	for start := range buckets {
+		if start.Before(cutoff) {
+			delete(buckets, start)
+		}
+	}
+}
+
+func sortedBuckets(buckets map[time.Time]int) []Bucket {
+	out := make([]Bucket, 0, len(buckets))
+	for start, n := range buckets {
+		out = append(out, Bucket{Start: start, Clicks: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// track records the clicks and waits until the worker has aggregated them.
+func track(clicks ...Click) *Tracker {
+	t := NewTracker(len(clicks) + 1)
+	for _, c := range clicks {
+		t.Record(c)
+	}
+	t.Close()
+	return t
+}
+
+func TestStats(t *testing.T) {
+	now := time.Date(2026, 5, 1, 10, 30, 0, 0, time.UTC)
+	tr := track(
+		Click{Code: "abc", Time: now, Referrer: "https://news.example/item?id=1", IP: "192.0.2.1", UserAgent: "a"},
+		Click{Code: "abc", Time: now, Referrer: "https://news.example/item?id=2", IP: "192.0.2.1", UserAgent: "a"},
+		Click{Code: "abc", Time: now.Add(-time.Hour), IP: "192.0.2.2", UserAgent: "a"},
+		Click{Code: "xyz", Time: now, IP: "192.0.2.3"},
+	)
+
+	s := tr.Stats("abc")
+	if s.TotalClicks != 3 || s.UniqueVisitors != 2 {
+		t.Errorf("Stats() = %d clicks, %d visitors; want 3 and 2", s.TotalClicks, s.UniqueVisitors)
+	}
+	if len(s.Hourly) != 2 || s.Hourly[1].Clicks != 2 || !s.Hourly[1].Start.Equal(now.Truncate(time.Hour)) {
+		t.Errorf("Hourly = %+v; want 1 click in the hour before 10:00 and 2 at 10:00", s.Hourly)
+	}
+	if len(s.Daily) != 1 || s.Daily[0].Clicks != 3 {
+		t.Errorf("Daily = %+v; want 3 clicks on one day", s.Daily)
+	}
+	want := []ReferrerCount{{"news.example", 2}, {directReferrer, 1}}
+	if fmt.Sprint(s.TopReferrers) != fmt.Sprint(want) {
+		t.Errorf("TopReferrers = %v; want %v", s.TopReferrers, want)
+	}
+	if s := tr.Stats("none"); s.TotalClicks != 0 || s.Hourly == nil || s.TopReferrers == nil {
+		t.Errorf("Stats() of a code without clicks = %+v; want zeros and empty lists", s)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	now := time.Now()
+	tr := track(
+		Click{Code: "ab", Time: now, IP: "192.0.2.1", Variant: "B"},
+		Click{Code: "ab", Time: now, IP: "192.0.2.1", Variant: "A"},
+		Click{Code: "ab", Time: now, IP: "192.0.2.1", Variant: "A"},
+		Click{Code: "ab", Time: now, IP: "192.0.2.2", Variant: "A"},
+	)
+	want := []VariantCount{{"A", 3, 2}, {"B", 1, 1}}
+	if got := tr.Stats("ab").Variants; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Variants = %v; want %v", got, want)
+	}
+}
+
+// TestReferrersAreBounded checks that clients can't grow a link's referrer
+// counts without limit, by sending a new Referer with every click.
+func TestReferrersAreBounded(t *testing.T) {
+	var clicks []Click
+	for i := range 3 * MaxReferrers {
+		clicks = append(clicks, Click{Code: "abc", Time: time.Now(), Referrer: fmt.Sprintf("https://site%d.example/", i)})
+	}
+	clicks = append(clicks,
+		Click{Code: "abc", Time: time.Now(), Referrer: "not a URL at all"},
+		Click{Code: "abc", Time: time.Now(), Referrer: "https://site0.example/again"},
+	)
+	tr := track(clicks...)
+
+	ls := tr.links["abc"]
+	if n := len(ls.referrers); n > MaxReferrers+1 {
+		t.Errorf("%d referrers counted; want at most %d", n, MaxReferrers+1)
+	}
+	if n := ls.referrers[otherReferrer]; n != 2*MaxReferrers+1 {
+		t.Errorf("%s = %d clicks; want %d", otherReferrer, n, 2*MaxReferrers+1)
+	}
+	if n := ls.referrers["site0.example"]; n != 2 {
+		t.Errorf("site0.example = %d clicks; want 2, as it was counted before the limit", n)
+	}
+}
+
+func TestReferrerHost(t *testing.T) {
+	for referrer, want := range map[string]string{
+		"":                               directReferrer,
+		"https://news.example/item?id=1": "news.example",
+		"http://localhost:8080/":         "localhost:8080",
+		"android-app://com.example":      "com.example",
+		"no scheme, no host":             otherReferrer,
+		"%zz":                            otherReferrer,
+		"https://" + strings.Repeat("a", 300) + ".example/": otherReferrer,
+	} {
+		if got := referrerHost(referrer); got != want {
+			t.Errorf("referrerHost(%.40q) = %q; want %q", referrer, got, want)
+		}
+	}
+}
+
+func TestVisitorSet(t *testing.T) {
+	for _, n := range []int{0, 1, maxExactVisitors, maxExactVisitors + 1, 5000, 200000} {
+		var s visitorSet
+		for i := range n {
+			id := visitorID(fmt.Sprint("192.0.2.", i), "ua")
+			s.add(id)
+			s.add(id) // repeat visits don't count
+		}
+		got := s.count()
+		if n <= maxExactVisitors {
+			if got != n {
+				t.Errorf("count() of %d visitors = %d; want it exact", n, got)
+			}
+			continue
+		}
+		if err := math.Abs(float64(got-n)) / float64(n); err > 0.05 {
+			t.Errorf("count() of %d visitors = %d, off by %.1f%%; want within 5%%", n, got, 100*err)
+		}
+		if len(s.registers) != hllRegisters || s.exact != nil {
+			t.Errorf("%d visitors: %d registers and %d exact entries; want only the sketch", n, len(s.registers), len(s.exact))
+		}
+	}
+}
+
+func TestForgetAndRetain(t *testing.T) {
+	now := time.Now()
+	tr := track(
+		Click{Code: "a", Time: now},
+		Click{Code: "b", Time: now},
+		Click{Code: "c", Time: now},
+	)
+	tr.Forget("a")
+	if n := tr.Retain(func(code string) bool { return code != "b" }); n != 1 {
+		t.Errorf("Retain() = %d; want 1", n)
+	}
+	for code, want := range map[string]int{"a": 0, "b": 0, "c": 1} {
+		if got := tr.Stats(code).TotalClicks; got != want {
+			t.Errorf("Stats(%q).TotalClicks = %d; want %d", code, got, want)
+		}
+	}
+}
+
+func TestRecordDropsWhenFull(t *testing.T) {
+	tr := NewTracker(1)
+	tr.mu.Lock() // stall the worker
+	for range 10 {
+		tr.Record(Click{Code: "abc", Time: time.Now()})
+	}
+	tr.mu.Unlock()
+	tr.Close()
+	if got := int64(tr.Stats("abc").TotalClicks) + tr.Dropped(); got != 10 {
+		t.Errorf("recorded + dropped = %d; want 10", got)
+	}
+	if tr.Dropped() == 0 {
+		t.Error("Dropped() = 0; want clicks dropped while the buffer was full")
+	}
+	if tr.Record(Click{Code: "abc"}) {
+		t.Error("Record() after Close() = true; want false")
+	}
+}
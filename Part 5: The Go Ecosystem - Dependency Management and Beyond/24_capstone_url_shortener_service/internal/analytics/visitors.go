@@ -0,0 +1,90 @@
+package analytics
+
+import (
+	"math"
+	"math/bits"
+)
+
+/*
+Counting UNIQUE visitors exactly means remembering every visitor ever seen, so
+the memory a popular link needs would grow with its audience, without limit.
+visitorSet remembers them exactly only while there are few of them. Past
+maxExactVisitors it switches to a HYPERLOGLOG sketch, which estimates the
+number of distinct values in a fixed 4 KB, however many there are.
+
+The idea behind HyperLogLog: in a stream of random 64-bit hashes, a hash that
+starts with k zero bits turns up about once every 2^k distinct values. So the
+longest run of leading zeros seen says roughly how many distinct values went
+by, and seeing the same value twice changes nothing. One such maximum is a
+very noisy guess; the sketch splits the hashes between 4096 REGISTERS by their
+first 12 bits, keeps the maximum of each, and combines them with a harmonic
+mean. The result is typically within 2% of the true count.
+*/
+
+const (
+	// maxExactVisitors is how many visitors a visitorSet counts exactly.
+	maxExactVisitors = 256
+
+	// hllPrecision is the number of hash bits that pick a register.
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+)
+
+// visitorSet counts distinct visitor hashes. The zero value is an empty set.
+type visitorSet struct {
+	exact map[uint64]struct{}
+	// registers is the HyperLogLog sketch, nil while the count is exact.
+	registers []uint8
+}
+
+// add counts a visitor, given as a uniformly distributed hash.
+func (s *visitorSet) add(visitor uint64) {
+	if s.registers != nil {
+		s.addToSketch(visitor)
+		return
+	}
+	if s.exact == nil {
+		s.exact = make(map[uint64]struct{})
+	}
+	s.exact[visitor] = struct{}{}
+	if len(s.exact) > maxExactVisitors {
+		s.registers = make([]uint8, hllRegisters)
+		for v := range s.exact {
+			s.addToSketch(v)
+		}
+		s.exact = nil
+	}
+}
+
+func (s *visitorSet) addToSketch(visitor uint64) {
+	i := visitor >> (64 - hllPrecision)
+	// The bit set below the shifted hash caps the run of zeros, so the rank
+	// of a hash whose remaining bits are all zero still fits the registers.
+	rank := uint8(bits.LeadingZeros64(visitor<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > s.registers[i] {
+		s.registers[i] = rank
+	}
+}
+
+// count returns the number of distinct visitors: exact for small sets, an
+// estimate for large ones.
+func (s *visitorSet) count() int {
+	if s.registers == nil {
+		return len(s.exact)
+	}
+	m := float64(hllRegisters)
+	sum, zeros := 0.0, 0
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// For small counts, many registers are still empty, and the fraction of
+	// empty ones ("linear counting") gives the better estimate.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
@@ -0,0 +1,276 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+This is the apikey package. It manages the API KEYS that clients use to create
+and manage their links.
+
+A key looks like this:
+
+	usk_3f9a1c0b7d2e4a68_Zq8u...   (prefix _ ID _ secret)
+
+The ID is public: it is stored as the owner of every link the key creates and
+shows up in logs. The secret is what proves the client holds the key.
+
+We never store the secret itself, only its SHA-256 HASH. If the key file
+leaks, the attacker learns nothing they can send to the server. Passwords need
+a deliberately slow hash (bcrypt, scrypt, PBKDF2) because people pick guessable
+ones, but our secrets are 32 random bytes: nobody can guess them, so a single
+fast hash is enough.
+
+Keys are kept in a small JSON file. The `keys` subcommand of cmd/urlshortener
+writes it, and a running server notices the change and reloads it, so a
+revoked key stops working within a few seconds without a restart.
+*/
+
+const (
+	// prefix marks our keys, which makes leaked keys easy to recognise
+	// (for example by secret scanners) and to tell apart from other tokens.
+	prefix = "usk"
+
+	idBytes     = 8
+	secretBytes = 32
+
+	// reloadInterval is how often Authenticate checks the key file for changes.
+	reloadInterval = 2 * time.Second
+)
+
+var (
+	// ErrInvalidKey is returned by Authenticate for malformed, unknown or revoked keys.
+	// We deliberately don't say which, so callers can't probe for valid IDs.
+	ErrInvalidKey = errors.New("apikey: invalid key")
+	// ErrNotFound is returned by Revoke when no key has the given ID.
+	ErrNotFound = errors.New("apikey: key not found")
+)
+
+// Key is the stored record of an API key. It holds a hash of the secret, never the secret.
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+// Revoked reports whether the key has been revoked.
+func (k Key) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// Keyring holds every API key and persists them to a JSON file.
+type Keyring struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]Key
+	modTime time.Time
+	checked time.Time
+}
+
+// Open loads the keyring stored at path. A missing file is an empty keyring.
+func Open(path string) (*Keyring, error) {
+	k := &Keyring{path: path, keys: make(map[string]Key)}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Create generates a new key with a human-readable name, saves it, and
+// returns the full key string. This is the only time the secret is visible:
+// it must be handed to the client now, because it can't be recovered later.
+func (k *Keyring) Create(name string, now time.Time) (string, Key, error) {
+	id, err := randomString(idBytes, hex.EncodeToString)
+	if err != nil {
+		return "", Key{}, err
+	}
+	secret, err := randomString(secretBytes, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	key := Key{ID: id, Name: name, Hash: hashSecret(secret), CreatedAt: now.UTC()}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	if err := k.save(); err != nil {
+		delete(k.keys, id)
+		return "", Key{}, err
+	}
+	return prefix + "_" + id + "_" + secret, key, nil
+}
+
+// Revoke marks a key as revoked. The record is kept so that links owned by
+// the key can still be traced back to it.
+func (k *Keyring) Revoke(id string, now time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, found := k.keys[id]
+	if !found {
+		return ErrNotFound
+	}
+	if key.Revoked() {
+		return nil
+	}
+	key.RevokedAt = now.UTC()
+	k.keys[id] = key
+	return k.save()
+}
+
+// List returns every key, oldest first.
+func (k *Keyring) List() []Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Authenticate checks a full key string and returns the matching active key.
+func (k *Keyring) Authenticate(raw string) (Key, error) {
+	k.reloadIfChanged()
+
+	parts := strings.Split(raw, "_")
+	// The secret is base64url, which may itself contain '_', so glue it back together.
+	if len(parts) < 3 || parts[0] != prefix {
+		return Key{}, ErrInvalidKey
+	}
+	id, secret := parts[1], strings.Join(parts[2:], "_")
+
+	k.mu.RLock()
+	key, found := k.keys[id]
+	k.mu.RUnlock()
+	if !found || key.Revoked() {
+		return Key{}, ErrInvalidKey
+	}
+	// Compare in constant time, so response times don't reveal how much of the hash matched.
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// reloadIfChanged re-reads the key file if another process (usually the
+// `keys` subcommand) has modified it. To keep requests cheap, the file is
+// checked at most once every reloadInterval.
+func (k *Keyring) reloadIfChanged() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if now.Sub(k.checked) < reloadInterval {
+		return
+	}
+	k.checked = now
+
+	info, err := os.Stat(k.path)
+	if err != nil || info.ModTime().Equal(k.modTime) {
+		return
+	}
+	// If the file can't be read (for example while it is being replaced),
+	// keep the keys we have and try again on the next check.
+	k.loadLocked()
+}
+
+// load reads the key file.
+func (k *Keyring) load() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.loadLocked()
+}
+
+// loadLocked reads the key file. The caller must hold the write lock.
+func (k *Keyring) loadLocked() error {
+	f, err := os.Open(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("apikey: open %s: %w", k.path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("apikey: stat %s: %w", k.path, err)
+	}
+	var list []Key
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return fmt.Errorf("apikey: decode %s: %w", k.path, err)
+	}
+
+	keys := make(map[string]Key, len(list))
+	for _, key := range list {
+		keys[key.ID] = key
+	}
+	k.keys = keys
+	k.modTime = info.ModTime()
+	return nil
+}
+
+// save writes every key to the file. Like the store's snapshots, it writes a
+// temporary file and renames it over the old one, so readers never see a
+// half-written file. The caller must hold the write lock.
+func (k *Keyring) save() error {
+	list := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o755); err != nil {
+		return fmt.Errorf("apikey: %w", err)
+	}
+	tmp := k.path + ".tmp"
+	// 0600: only the service's own user may read the key hashes.
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("apikey: write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("apikey: replace %s: %w", k.path, err)
+	}
+	if info, err := os.Stat(k.path); err == nil {
+		k.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("apikey: read random bytes: %w", err)
+	}
+	return encode(b), nil
+}
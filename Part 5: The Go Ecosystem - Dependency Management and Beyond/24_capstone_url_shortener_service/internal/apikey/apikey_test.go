@@ -0,0 +1,129 @@
+package apikey_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/apikey"
+)
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	k, err := apikey.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	raw, created, err := k.Create("acme", time.Now())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	got, err := k.Authenticate(raw)
+	if err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	if got.ID != created.ID || got.Name != "acme" {
+		t.Errorf("Authenticate() = %+v; want key %q named %q", got, created.ID, "acme")
+	}
+
+	// The file must hold the hash, never the secret.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	secret := raw[strings.LastIndex(raw, created.ID)+len(created.ID)+1:]
+	if strings.Contains(string(data), secret) {
+		t.Errorf("key file contains the plain secret")
+	}
+
+	// A fresh keyring loads the key from disk.
+	reopened, err := apikey.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if _, err := reopened.Authenticate(raw); err != nil {
+		t.Errorf("Authenticate() after reopen error: %v", err)
+	}
+}
+
+func TestAuthenticateRejectsBadKeys(t *testing.T) {
+	k, err := apikey.Open(filepath.Join(t.TempDir(), "apikeys.json"))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	raw, key, err := k.Create("acme", time.Now())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		raw  string
+	}{
+		{name: "empty", raw: ""},
+		{name: "no prefix", raw: strings.TrimPrefix(raw, "usk_")},
+		{name: "wrong secret", raw: "usk_" + key.ID + "_not-the-secret"},
+		{name: "unknown id", raw: "usk_0000000000000000_" + raw[len(raw)-10:]},
+		{name: "truncated", raw: raw[:len(raw)-1]},
+	}
+	for _, tc := range testCases {
+		if _, err := k.Authenticate(tc.raw); !errors.Is(err, apikey.ErrInvalidKey) {
+			t.Errorf("Authenticate(%s) error = %v; want ErrInvalidKey", tc.name, err)
+		}
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	server, err := apikey.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	raw, key, err := server.Create("acme", time.Now())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if _, err := server.Authenticate(raw); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+
+	// Revoke through a second keyring, as the `keys revoke` subcommand would
+	// while the server is running.
+	cli, err := apikey.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if err := cli.Revoke("nope", time.Now()); !errors.Is(err, apikey.ErrNotFound) {
+		t.Errorf("Revoke(unknown) error = %v; want ErrNotFound", err)
+	}
+	if err := cli.Revoke(key.ID, time.Now()); err != nil {
+		t.Fatalf("Revoke() error: %v", err)
+	}
+	// Make sure the file's modification time differs even on coarse filesystems.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Chtimes() error: %v", err)
+	}
+
+	// The server picks up the change on its next reload check.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := server.Authenticate(raw)
+		if errors.Is(err, apikey.ErrInvalidKey) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Authenticate() of a revoked key error = %v; want ErrInvalidKey", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	keys := cli.List()
+	if len(keys) != 1 || !keys[0].Revoked() {
+		t.Errorf("List() = %+v; want one revoked key", keys)
+	}
+}
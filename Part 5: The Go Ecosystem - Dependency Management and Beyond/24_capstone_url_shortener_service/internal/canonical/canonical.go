@@ -0,0 +1,306 @@
+package canonical
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/*
+This is the canonical package. It rewrites a URL into a single CANONICAL form,
+so that different spellings of the same address compare equal:
+
+	HTTPS://Example.com:443/a/./b/../c?b=1&a=2   ->   https://example.com/a/c?a=2&b=1
+
+The rules follow RFC 3986, section 6 ("Normalization and Comparison"):
+  - The scheme and host are case-insensitive, so they are lowercased.
+  - A port that is the default for the scheme (80 for http, 443 for https) is dropped.
+  - "." and ".." path segments are resolved, and an empty path becomes "/".
+  - Percent-encoding is normalized: characters that never need escaping
+    ("%41" is just "A") are decoded, the rest use uppercase hex ("%2f" -> "%2F"),
+    and characters that must be escaped (spaces, non-ASCII) are.
+  - International domain names (bücher.example) are converted to their ASCII
+    "punycode" form (xn--bcher-kva.example), which is what DNS actually uses.
+
+Two more rules are not strictly equivalences, but make sense for a shortener:
+  - Query parameters are sorted, since almost every server ignores their order.
+  - Tracking parameters (utm_source, fbclid, ...) can be stripped, so links
+    shared from different campaigns end up as one short link.
+*/
+
+// DefaultTrackingParams are the query parameters removed when
+// Options.StripTracking is set. Names ending in "*" are prefixes.
+var DefaultTrackingParams = []string{"utm_*", "fbclid"}
+
+// ErrNotAbsolute is returned for URLs without a scheme and host.
+var ErrNotAbsolute = errors.New("canonical: URL must be absolute, with a scheme and host")
+
+// Options configures a Canonicalizer.
+type Options struct {
+	// StripTracking removes tracking parameters from the query.
+	StripTracking bool
+	// TrackingParams lists the parameters StripTracking removes, compared
+	// case-insensitively. A trailing "*" matches any suffix.
+	// Empty means DefaultTrackingParams.
+	TrackingParams []string
+}
+
+// Canonicalizer rewrites URLs into their canonical form. It is safe for
+// concurrent use, since it never changes after New.
+type Canonicalizer struct {
+	stripTracking bool
+	exact         map[string]bool
+	prefixes      []string
+}
+
+// New creates a Canonicalizer.
+func New(opts Options) *Canonicalizer {
+	params := opts.TrackingParams
+	if len(params) == 0 {
+		params = DefaultTrackingParams
+	}
+	c := &Canonicalizer{stripTracking: opts.StripTracking, exact: make(map[string]bool)}
+	for _, p := range params {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			c.prefixes = append(c.prefixes, prefix)
+		} else {
+			c.exact[p] = true
+		}
+	}
+	return c
+}
+
+// defaultPorts maps schemes to the port they use when none is given.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+// Canonicalize returns the canonical form of an absolute URL.
+func (c *Canonicalizer) Canonicalize(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return "", ErrNotAbsolute
+	}
+	scheme := strings.ToLower(u.Scheme)
+
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[scheme] {
+		host += ":" + port
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(host)
+
+	path := removeDotSegments(normalizeEscapes(u.EscapedPath(), "/"))
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+
+	if query := c.canonicalQuery(u.RawQuery); query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+	if u.Fragment != "" {
+		b.WriteByte('#')
+		b.WriteString(normalizeEscapes(u.EscapedFragment(), "/?"))
+	}
+	return b.String(), nil
+}
+
+// canonicalHost lowercases a host name and converts international labels to punycode.
+func canonicalHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", ErrNotAbsolute
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		encoded, err := punycode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// canonicalQuery normalizes each key=value pair of a raw query, drops
+// tracking parameters, and sorts what's left. Pairs are compared in their
+// normalized form, so "a=%41" and "a=A" sort (and deduplicate) the same way.
+func (c *Canonicalizer) canonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	type pair struct{ key, value, text string }
+	var pairs []pair
+	for _, part := range strings.Split(raw, "&") {
+		if part == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(part, "=")
+		key = normalizeEscapes(key, "/?")
+		value = normalizeEscapes(value, "/?=")
+		if c.isTracking(key) {
+			continue
+		}
+		text := key
+		if hasValue {
+			text += "=" + value
+		}
+		pairs = append(pairs, pair{key, value, text})
+	}
+	// A stable sort by key keeps repeated keys (a=2&a=1) in their original
+	// order, because for some servers that order means something.
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+
+	texts := make([]string, len(pairs))
+	for i, p := range pairs {
+		texts[i] = p.text
+	}
+	return strings.Join(texts, "&")
+}
+
+// isTracking reports whether a (normalized) query key is a tracking parameter
+// that should be stripped.
+func (c *Canonicalizer) isTracking(key string) bool {
+	if !c.stripTracking {
+		return false
+	}
+	if decoded, err := url.QueryUnescape(key); err == nil {
+		key = decoded
+	}
+	key = strings.ToLower(key)
+	if c.exact[key] {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnreserved reports whether RFC 3986 allows b anywhere in a URL without
+// escaping. Escaping these characters never changes the meaning of a URL.
+func isUnreserved(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+		b == '-' || b == '.' || b == '_' || b == '~'
+}
+
+// isAllowed reports whether b may appear unescaped in a path, query or fragment:
+// the unreserved characters plus the "sub-delims" and ":" and "@".
+func isAllowed(b byte) bool {
+	return isUnreserved(b) || strings.IndexByte("!$&'()*+,;=:@", b) >= 0
+}
+
+// normalizeEscapes rewrites the percent-encoding of one URL component:
+// escapes of unreserved characters are decoded, the rest use uppercase hex,
+// and characters that aren't allowed unescaped get escaped. extra lists more
+// characters that are allowed as-is in this component (like "/" in a path).
+// Malformed escapes such as "%zz" are escaped themselves ("%25zz").
+func normalizeEscapes(s, extra string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(decoded) {
+				b.WriteByte(decoded)
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(hex[decoded>>4])
+				b.WriteByte(hex[decoded&15])
+			}
+			i += 2
+			continue
+		}
+		if isAllowed(ch) || strings.IndexByte(extra, ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[ch>>4])
+		b.WriteByte(hex[ch&15])
+	}
+	return b.String()
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+func unhex(b byte) byte {
+	switch {
+	case '0' <= b && b <= '9':
+		return b - '0'
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10
+	}
+	return b - 'A' + 10
+}
+
+// removeDotSegments resolves "." and ".." segments in a path, following the
+// algorithm in RFC 3986, section 5.2.4. ".." never climbs above the root.
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			// "/a/." means the directory "/a/", so keep the trailing slash.
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
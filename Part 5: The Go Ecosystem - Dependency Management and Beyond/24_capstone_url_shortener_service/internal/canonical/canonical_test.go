@@ -0,0 +1,91 @@
+package canonical
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"HTTPS://Example.com:443/a?b=1&a=2", "https://example.com/a?a=2&b=1"},
+		{"https://example.com/a?a=2&b=1", "https://example.com/a?a=2&b=1"},
+		{"http://example.com:80", "http://example.com/"},
+		{"http://example.com:8080/", "http://example.com:8080/"},
+		{"https://example.com:80/", "https://example.com:80/"},
+		{"https://example.com./", "https://example.com/"},
+		{"https://example.com/a/./b/../c", "https://example.com/a/c"},
+		{"https://example.com/a/b/..", "https://example.com/a/"},
+		{"https://example.com/../../a", "https://example.com/a"},
+		{"https://example.com/%7euser/%2fx%41", "https://example.com/~user/%2FxA"},
+		{"https://example.com/a b", "https://example.com/a%20b"},
+		{"https://example.com/?q=a%2bb&q=c", "https://example.com/?q=a%2Bb&q=c"},
+		{"https://example.com/?b=2&a=1&b=1", "https://example.com/?a=1&b=2&b=1"},
+		{"https://example.com/?flag&&a=1", "https://example.com/?a=1&flag"},
+		{"https://example.com/#Sec%7e1", "https://example.com/#Sec~1"},
+		{"https://user:pw@Example.com/", "https://user:pw@example.com/"},
+		{"http://[2001:DB8::1]:80/", "http://[2001:db8::1]/"},
+		{"https://Bücher.example/", "https://xn--bcher-kva.example/"},
+		{"https://münchen.de/?utm_source=x", "https://xn--mnchen-3ya.de/?utm_source=x"},
+	}
+	c := New(Options{})
+	for _, tt := range tests {
+		got, err := c.Canonicalize(tt.in)
+		if err != nil {
+			t.Errorf("Canonicalize(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Canonicalize(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+		// Canonical URLs are already canonical.
+		if again, err := c.Canonicalize(got); err != nil || again != got {
+			t.Errorf("Canonicalize(%q) = %q, %v; not stable", got, again, err)
+		}
+	}
+}
+
+func TestStripTracking(t *testing.T) {
+	in := "https://example.com/?id=7&UTM_Source=news&utm_medium=mail&fbclid=abc&gclid=def"
+
+	keep := New(Options{})
+	if got, _ := keep.Canonicalize(in); got != "https://example.com/?UTM_Source=news&fbclid=abc&gclid=def&id=7&utm_medium=mail" {
+		t.Errorf("without StripTracking: %q", got)
+	}
+
+	strip := New(Options{StripTracking: true})
+	if got, _ := strip.Canonicalize(in); got != "https://example.com/?gclid=def&id=7" {
+		t.Errorf("with default tracking params: %q", got)
+	}
+
+	custom := New(Options{StripTracking: true, TrackingParams: []string{"gclid", "id"}})
+	if got, _ := custom.Canonicalize(in); got != "https://example.com/?UTM_Source=news&fbclid=abc&utm_medium=mail" {
+		t.Errorf("with custom tracking params: %q", got)
+	}
+}
+
+func TestCanonicalizeRejectsRelative(t *testing.T) {
+	c := New(Options{})
+	for _, in := range []string{"/just/a/path", "example.com", "mailto:someone@example.com", "https://"} {
+		if got, err := c.Canonicalize(in); err == nil {
+			t.Errorf("Canonicalize(%q) = %q; want an error", in, got)
+		}
+	}
+}
+
+// The samples come from RFC 3492, section 7.1.
+func TestPunycode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"bücher", "bcher-kva"},
+		{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+		{"почемужеонинеговорятпорусски", "b1abfaaepdrnnbgefbadotcwatmq2g4l"},
+		{"3年b組金八先生", "3b-ww4c5e180e575a65lsy2b"},
+		{"パフィーdeルンバ", "de-jg4avhby1noc0d"},
+	}
+	for _, tt := range tests {
+		got, err := punycode(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("punycode(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package canonical
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+DNS only knows ASCII, so an international domain name like "bücher.example" is
+looked up as "xn--bcher-kva.example". Each non-ASCII label is encoded with
+PUNYCODE (RFC 3492) and prefixed with "xn--".
+
+Punycode writes the ASCII characters of the label first ("bcher"), then a
+dash, then a compact description of where the other characters go ("kva").
+That description is a series of numbers, one per inserted character, each
+saying how far to move from the previous insertion and which code point to
+insert there, written in a variable-length base-36 format.
+
+This is the encoding half of IDNA. Full IDNA also maps some look-alike
+characters first (Unicode normalization); we only lowercase, which covers the
+common cases.
+*/
+
+// Punycode parameters from RFC 3492, section 5.
+const (
+	pcBase        = 36
+	pcTMin        = 1
+	pcTMax        = 26
+	pcSkew        = 38
+	pcDamp        = 700
+	pcInitialBias = 72
+	pcInitialN    = 128
+)
+
+var errPunycodeOverflow = errors.New("canonical: host label too long to encode")
+
+// punycode encodes one domain label (without the "xn--" prefix).
+func punycode(label string) (string, error) {
+	input := []rune(label)
+	var out strings.Builder
+	for _, r := range input {
+		if r < 0x80 {
+			out.WriteRune(r)
+		}
+	}
+	basic := out.Len()
+	if basic > 0 {
+		out.WriteByte('-')
+	}
+
+	n, delta, bias := rune(pcInitialN), 0, pcInitialBias
+	for handled := basic; handled < len(input); {
+		// The next code point to insert is the smallest one we haven't handled yet.
+		m := rune(0x10FFFF + 1)
+		for _, r := range input {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		if int(m-n) > (1<<31-1-delta)/(handled+1) {
+			return "", errPunycodeOverflow
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range input {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			// Write delta as a variable-length integer.
+			q := delta
+			for k := pcBase; ; k += pcBase {
+				t := threshold(k, bias)
+				if q < t {
+					break
+				}
+				out.WriteByte(punycodeDigit(t + (q-t)%(pcBase-t)))
+				q = (q - t) / (pcBase - t)
+			}
+			out.WriteByte(punycodeDigit(q))
+			bias = adaptBias(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return out.String(), nil
+}
+
+func threshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return pcTMin
+	case k >= bias+pcTMax:
+		return pcTMax
+	}
+	return k - bias
+}
+
+// adaptBias is the bias adaptation function of RFC 3492, section 6.1.
+func adaptBias(delta, numPoints int, first bool) int {
+	if first {
+		delta /= pcDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > (pcBase-pcTMin)*pcTMax/2 {
+		delta /= pcBase - pcTMin
+		k += pcBase
+	}
+	return k + (pcBase-pcTMin+1)*delta/(delta+pcSkew)
+}
+
+// punycodeDigit writes 0-25 as a-z and 26-35 as 0-9.
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/logging"
+)
+
+/*
+AccessLog is the outermost middleware. For every request it:
+
+ 1. picks a REQUEST ID: the X-Request-ID header if a proxy in front of us (or
+    the client) already set a sensible one, so one ID follows the request
+    across services; otherwise a new random one.
+ 2. sends the ID back in the X-Request-ID response header, so a user reporting
+    a problem can quote it, and stores it in the request's context, so every
+    line the handlers log for this request carries it (see the logging package).
+ 3. after the request is served, writes one ACCESS LOG line with the method,
+    path, status, response size, duration and client IP.
+*/
+
+// requestIDHeader is the header that carries request IDs.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs we accept from clients.
+const maxRequestIDLength = 128
+
+// AccessLog wraps next with request IDs and access logging.
+func (h *Handler) AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
+
+		rw := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		h.logger.LogAttrs(r.Context(), slog.LevelInfo, "Request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rw.status()),
+			slog.Int64("bytes", rw.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", h.clientIP(r)),
+		)
+	})
+}
+
+// validRequestID reports whether an incoming request ID may be reused. The ID
+// ends up in our logs, so it must be short and free of anything that could
+// forge or break a log line, like newlines or quotes.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns 16 random bytes, hex-encoded.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// responseRecorder remembers the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (rw *responseRecorder) WriteHeader(code int) {
+	if rw.code == 0 {
+		rw.code = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseRecorder) Write(p []byte) (int, error) {
+	if rw.code == 0 {
+		rw.code = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// status is the code that was sent; 200 if the handler never set one.
+func (rw *responseRecorder) status() int {
+	if rw.code == 0 {
+		return http.StatusOK
+	}
+	return rw.code
+}
@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/linkcheck"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+This file holds the MANAGEMENT API: the endpoints for managing links after
+they have been created.
+
+	GET    /api/links?limit=50&cursor=...   list links, one page at a time
+	GET    /api/links?status=broken         list links whose destination is dead
+	GET    /api/links/{code}                show one link
+	PATCH  /api/links/{code}                change where a link points, or its settings
+	DELETE /api/links/{code}                delete a link
+	GET    /api/links/{code}/stats          show a link's click statistics
+
+Every request must carry an API key or the admin token (see auth.go). An API
+key only sees its own links: anyone else's look exactly like links that don't
+exist, so a customer can't even find out which codes other customers use.
+The admin sees every link, and may filter the list with `?owner=<key ID>`.
+The same goes for the click statistics: only the admin reads those of
+anonymous links.
+
+When the link checker runs (see the linkcheck package), every link also shows
+the health of its destination: the status and latency of the last check, and
+whether the link counts as broken.
+
+Listing uses CURSOR-BASED PAGINATION. Instead of "page 3", each response
+contains an opaque `next_cursor` that the client sends back to get the next
+page. Under the hood the cursor is just the last code of the page, so links
+created or deleted between two requests never make a page skip or repeat entries.
+*/
+
+const (
+	// defaultPageSize and maxPageSize bound the `limit` query parameter of GET /api/links.
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// LinkView is how the management API presents a stored link. The password
+// hash is left out; PasswordProtected says whether there is one. Health is
+// nil until the link checker has checked the link's current destination.
+type LinkView struct {
+	store.Link
+	ShortURL          string            `json:"short_url"`
+	PasswordProtected bool              `json:"password_protected,omitempty"`
+	Health            *linkcheck.Health `json:"health,omitempty"`
+}
+
+// ListLinksResponse is one page of GET /api/links.
+// NextCursor is empty on the last page.
+type ListLinksResponse struct {
+	Links      []LinkView `json:"links"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// UpdateLinkRequest is the body of PATCH /api/links/{code}.
+// Fields that are left out keep their current value.
+type UpdateLinkRequest struct {
+	URL                 string  `json:"url,omitempty"`
+	InterstitialSeconds *int    `json:"interstitial_seconds,omitempty"`
+	RedirectStatus      *int    `json:"redirect_status,omitempty"`
+	ForwardQuery        *bool   `json:"forward_query,omitempty"`
+	UTMTemplate         *string `json:"utm_template,omitempty"`
+	// Password sets a new password; the empty string removes protection.
+	Password *string `json:"password,omitempty"`
+	// Variants replaces the destinations of a split link, or splits a plain
+	// one; an empty list ends the split, and the link keeps going to its
+	// first variant. A split link's destinations can only change this way.
+	Variants *[]store.Variant `json:"variants,omitempty"`
+	Sticky   *string          `json:"sticky,omitempty"`
+}
+
+// LinksHandler serves everything under /api/links and routes each request to
+// the list, single-link, or stats endpoint.
+func (h *Handler) LinksHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/links"), "/")
+	switch {
+	case rest == "":
+		h.requireAuth(h.listLinks)(w, r)
+	case strings.HasSuffix(rest, "/stats"):
+		h.requireAuth(h.linkStats)(w, r)
+	case strings.Contains(rest, "/"):
+		http.NotFound(w, r)
+	default:
+		h.requireAuth(h.manageLink)(w, r)
+	}
+}
+
+// listLinks serves GET /api/links.
+func (h *Handler) listLinks(w http.ResponseWriter, r *http.Request, c caller) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxPageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	after, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	// API keys only ever list their own links.
+	owner := c.owner
+	if c.admin {
+		owner = r.URL.Query().Get("owner")
+	}
+
+	// Ask for one link more than the page holds: if it exists, there is a next page.
+	opts := store.ListOptions{After: after, Limit: limit + 1, Owner: owner}
+	var links []store.Link
+	switch r.URL.Query().Get("status") {
+	case "":
+		links, err = h.store.List(opts)
+	case "broken":
+		if h.checker == nil {
+			http.Error(w, "Link checking is turned off", http.StatusBadRequest)
+			return
+		}
+		links, err = h.brokenLinks(opts)
+	default:
+		http.Error(w, "status must be broken", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "list links", err)
+		return
+	}
+
+	resp := ListLinksResponse{Links: make([]LinkView, 0, limit)}
+	if len(links) > limit {
+		links = links[:limit]
+		resp.NextCursor = encodeCursor(links[limit-1].Code)
+	}
+	for _, link := range links {
+		resp.Links = append(resp.Links, h.linkView(link))
+	}
+	h.respondWithJSON(w, http.StatusOK, resp)
+}
+
+// brokenLinks is Store.List for broken links only. The checker knows which
+// codes are broken; the store still decides who owns them, and whether they
+// still exist and point where they did when they were checked.
+func (h *Handler) brokenLinks(opts store.ListOptions) ([]store.Link, error) {
+	var links []store.Link
+	for _, code := range h.checker.Broken() {
+		if len(links) == opts.Limit {
+			break
+		}
+		if code <= opts.After {
+			continue
+		}
+		link, err := h.store.Get(code)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opts.Owner != "" && link.Owner != opts.Owner {
+			continue
+		}
+		if health, ok := h.checker.Health(code, link.URL); ok && health.Broken {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// manageLink serves GET, PATCH and DELETE on /api/links/{code}.
+func (h *Handler) manageLink(w http.ResponseWriter, r *http.Request, c caller) {
+	code := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/links"), "/")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := h.store.Get(code)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !c.canManage(link)) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "look up code", err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.respondWithJSON(w, http.StatusOK, h.linkView(link))
+
+	case http.MethodPatch:
+		h.updateLink(w, r, link)
+
+	case http.MethodDelete:
+		err := h.store.Delete(code)
+		if errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			h.serverError(w, r, "delete link", err)
+			return
+		}
+		h.clicks.Forget(code)
+		h.logger.InfoContext(r.Context(), "Deleted link", "code", code)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// updateLink changes the destination or the settings of an existing link.
+func (h *Handler) updateLink(w http.ResponseWriter, r *http.Request, link store.Link) {
+	var req UpdateLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.URL == "" && req.InterstitialSeconds == nil && req.RedirectStatus == nil &&
+		req.ForwardQuery == nil && req.UTMTemplate == nil && req.Password == nil &&
+		req.Variants == nil && req.Sticky == nil {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	previous := link.URL
+	var err error
+	switch {
+	case req.URL != "" && req.Variants != nil:
+		http.Error(w, "Set either url or variants, not both", http.StatusBadRequest)
+		return
+	case req.URL != "" && len(link.Variants) > 0:
+		http.Error(w, "This link is split; change its variants instead of its url", http.StatusBadRequest)
+		return
+	case req.URL != "":
+		link.URL, err = h.destination(r.Context(), req.URL)
+	case req.Variants != nil && len(*req.Variants) == 0:
+		link.Variants, link.Sticky = nil, ""
+	case req.Variants != nil:
+		if link.Variants, err = h.splitDestinations(r.Context(), *req.Variants); err == nil {
+			link.URL = link.Variants[0].URL
+		}
+	}
+	var reqErr *requestError
+	var violation *policy.Violation
+	switch {
+	case errors.As(err, &reqErr):
+		http.Error(w, reqErr.msg, reqErr.status)
+		return
+	case errors.As(err, &violation):
+		h.respondWithJSON(w, http.StatusUnprocessableEntity, refusal(violation))
+		return
+	}
+	if req.Sticky != nil {
+		link.Sticky = *req.Sticky
+	}
+	if req.InterstitialSeconds != nil {
+		link.InterstitialSeconds = *req.InterstitialSeconds
+	}
+	if req.RedirectStatus != nil {
+		link.RedirectStatus = *req.RedirectStatus
+	}
+	if req.ForwardQuery != nil {
+		link.ForwardQuery = *req.ForwardQuery
+	}
+	if req.UTMTemplate != nil {
+		link.UTMTemplate = *req.UTMTemplate
+	}
+	if err := validateSettings(link); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Password != nil {
+		link.PasswordHash = ""
+		if *req.Password != "" {
+			hash, err := hashPassword(*req.Password)
+			if errors.As(err, &reqErr) {
+				http.Error(w, reqErr.msg, reqErr.status)
+				return
+			}
+			if err != nil {
+				h.serverError(w, r, "hash password", err)
+				return
+			}
+			link.PasswordHash = hash
+		}
+	}
+	// Update fails with ErrNotFound if the link was deleted since we read it.
+	err = h.store.Update(link)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "update link", err)
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "Updated link", "code", link.Code, "previous_url", previous, "url", link.URL)
+	h.respondWithJSON(w, http.StatusOK, h.linkView(link))
+}
+
+// linkView adds the full short URL to a stored link.
+func (h *Handler) linkView(link store.Link) LinkView {
+	view := LinkView{Link: link, ShortURL: h.baseURL + "/" + link.Code, PasswordProtected: link.PasswordHash != ""}
+	// Even a hash helps someone guessing the password offline, so it never
+	// leaves the server.
+	view.PasswordHash = ""
+	if h.checker != nil {
+		if health, ok := h.checker.Health(link.Code, link.URL); ok {
+			view.Health = &health
+		}
+	}
+	return view
+}
+
+// encodeCursor and decodeCursor turn the last code of a page into an opaque
+// token and back. Clients must treat cursors as black boxes, which leaves us
+// free to change what's inside later.
+func encodeCursor(code string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(code))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(b), err
+}
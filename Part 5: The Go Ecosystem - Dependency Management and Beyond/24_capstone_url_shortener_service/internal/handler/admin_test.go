@@ -0,0 +1,155 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/apikey"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/handler"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+// newKey creates an API key and returns the token a client sends and the ID
+// that owns its links.
+func newKey(t *testing.T, keys *apikey.Keyring, name string) (token, owner string) {
+	t.Helper()
+	token, key, err := keys.Create(name, time.Now())
+	if err != nil {
+		t.Fatalf("Create(%q) error: %v", name, err)
+	}
+	return token, key.ID
+}
+
+// listCodes fetches one page of GET /api/links and returns its codes.
+func listCodes(t *testing.T, h *handler.Handler, target, token string) ([]string, string) {
+	t.Helper()
+	w := do(h.LinksHandler, http.MethodGet, target, "", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d; want 200", target, w.Code)
+	}
+	var resp handler.ListLinksResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body, err)
+	}
+	codes := make([]string, 0, len(resp.Links))
+	for _, link := range resp.Links {
+		codes = append(codes, link.Code)
+	}
+	return codes, resp.NextCursor
+}
+
+// TestLinkOwnership checks that an API key can only reach its own links, and
+// that anyone else's answer exactly like links that don't exist.
+func TestLinkOwnership(t *testing.T) {
+	h, s, keys := newKeyedHandler(t)
+	alice, aliceID := newKey(t, keys, "alice")
+	bob, bobID := newKey(t, keys, "bob")
+	s.Set(store.Link{Code: "alice-link", URL: "https://go.dev/", Owner: aliceID})
+	s.Set(store.Link{Code: "bob-link", URL: "https://pkg.go.dev/", Owner: bobID})
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"own link", alice, http.MethodGet, "/api/links/alice-link", "", http.StatusOK},
+		{"other key's link", alice, http.MethodGet, "/api/links/bob-link", "", http.StatusNotFound},
+		{"missing link", alice, http.MethodGet, "/api/links/no-link", "", http.StatusNotFound},
+		{"patch other key's link", alice, http.MethodPatch, "/api/links/bob-link", `{"url": "https://evil.example/"}`, http.StatusNotFound},
+		{"delete other key's link", alice, http.MethodDelete, "/api/links/bob-link", "", http.StatusNotFound},
+		{"admin sees every link", adminToken, http.MethodGet, "/api/links/bob-link", "", http.StatusOK},
+		{"own stats", alice, http.MethodGet, "/api/links/alice-link/stats", "", http.StatusOK},
+		{"other key's stats", alice, http.MethodGet, "/api/links/bob-link/stats", "", http.StatusNotFound},
+		{"missing link's stats", alice, http.MethodGet, "/api/links/no-link/stats", "", http.StatusNotFound},
+		{"admin sees every link's stats", adminToken, http.MethodGet, "/api/links/bob-link/stats", "", http.StatusOK},
+		{"stats without a token", "", http.MethodGet, "/api/links/alice-link/stats", "", http.StatusUnauthorized},
+		{"no token", "", http.MethodGet, "/api/links/alice-link", "", http.StatusUnauthorized},
+		{"unknown token", "usk_nope_nope", http.MethodGet, "/api/links/alice-link", "", http.StatusUnauthorized},
+		{"patch own link", bob, http.MethodPatch, "/api/links/bob-link", `{"redirect_status": 301}`, http.StatusOK},
+		{"patch without changes", bob, http.MethodPatch, "/api/links/bob-link", `{}`, http.StatusBadRequest},
+		{"delete own link", alice, http.MethodDelete, "/api/links/alice-link", "", http.StatusNoContent},
+		{"deleted link", alice, http.MethodGet, "/api/links/alice-link", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := do(h.LinksHandler, tt.method, tt.target, tt.body, tt.token); w.Code != tt.want {
+			t.Errorf("%s: %s %s = %d; want %d", tt.name, tt.method, tt.target, w.Code, tt.want)
+		}
+	}
+
+	link, err := s.Get("bob-link")
+	if err != nil || link.URL != "https://pkg.go.dev/" || link.RedirectStatus != http.StatusMovedPermanently {
+		t.Errorf("bob-link = %+v, %v; want only bob's own change applied", link, err)
+	}
+	if _, err := s.Get("alice-link"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get(alice-link) error = %v; want ErrNotFound", err)
+	}
+
+	// Lists are filtered the same way; only the admin may pick an owner.
+	s.Set(store.Link{Code: "alice-2", URL: "https://go.dev/blog", Owner: aliceID})
+	lists := []struct {
+		token, target string
+		want          []string
+	}{
+		{alice, "/api/links", []string{"alice-2"}},
+		{alice, "/api/links?owner=" + bobID, []string{"alice-2"}},
+		{bob, "/api/links", []string{"bob-link"}},
+		{adminToken, "/api/links", []string{"alice-2", "bob-link"}},
+		{adminToken, "/api/links?owner=" + bobID, []string{"bob-link"}},
+	}
+	for _, l := range lists {
+		if got, _ := listCodes(t, h, l.target, l.token); !slices.Equal(got, l.want) {
+			t.Errorf("GET %s = %v; want %v", l.target, got, l.want)
+		}
+	}
+}
+
+// TestListLinksPaging follows the cursors through every page, and checks
+// that a link deleted between two pages makes nothing skip or repeat.
+func TestListLinksPaging(t *testing.T) {
+	h, s := newTestHandler(t)
+	var want []string
+	for i := range 7 {
+		code := fmt.Sprintf("link-%d", i)
+		s.Set(store.Link{Code: code, URL: fmt.Sprintf("https://go.dev/%d", i)})
+		want = append(want, code)
+	}
+
+	var got []string
+	var sizes []int
+	cursor := ""
+	for range 10 {
+		page, next := listCodes(t, h, "/api/links?limit=3&cursor="+url.QueryEscape(cursor), adminToken)
+		got = append(got, page...)
+		sizes = append(sizes, len(page))
+		if len(got) == 3 {
+			// The first link of the next page goes away.
+			s.Delete("link-3")
+			want = slices.Delete(want, 3, 4)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("paged through %v; want %v", got, want)
+	}
+	if !slices.Equal(sizes, []int{3, 3}) {
+		t.Errorf("page sizes = %v; want [3 3]", sizes)
+	}
+
+	for _, target := range []string{"/api/links?limit=0", "/api/links?limit=501", "/api/links?limit=x", "/api/links?cursor=!!"} {
+		if w := do(h.LinksHandler, http.MethodGet, target, "", adminToken); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d; want 400", target, w.Code)
+		}
+	}
+}
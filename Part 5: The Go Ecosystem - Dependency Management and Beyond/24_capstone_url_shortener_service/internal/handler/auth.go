@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+API requests identify themselves with an `Authorization: Bearer ...` header
+holding one of two kinds of token:
+
+  - an API KEY (see the apikey package). Its ID becomes the owner of every link
+    the key creates, and the key can only see and change its own links.
+  - the ADMIN TOKEN from the configuration, which may manage every link, and
+    export and import them all (see transfer.go).
+*/
+
+var (
+	// errNoCredentials means the request carried no bearer token at all.
+	errNoCredentials = errors.New("no credentials")
+	// errBadCredentials means the request carried a token we don't accept.
+	errBadCredentials = errors.New("invalid credentials")
+)
+
+// caller identifies who sent an API request.
+type caller struct {
+	// admin is true for the admin token.
+	admin bool
+	// owner is the ID of the caller's API key. It is empty for the admin.
+	owner string
+}
+
+// canManage reports whether the caller may see and change a link.
+func (c caller) canManage(link store.Link) bool {
+	return c.admin || (c.owner != "" && link.Owner == c.owner)
+}
+
+// authenticate works out who sent the request from its Authorization header.
+func (h *Handler) authenticate(r *http.Request) (caller, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return caller{}, errNoCredentials
+	}
+	return h.authenticateToken(token)
+}
+
+// authenticateToken works out who a bearer token belongs to. The web form
+// also uses it for the API key typed into it.
+func (h *Handler) authenticateToken(token string) (caller, error) {
+	// ConstantTimeCompare takes the same time whether the first or the last
+	// byte differs, so an attacker can't guess the token one byte at a time
+	// by measuring how long we take to reject it.
+	if h.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1 {
+		return caller{admin: true}, nil
+	}
+	if h.keys != nil {
+		if key, err := h.keys.Authenticate(token); err == nil {
+			return caller{owner: key.ID}, nil
+		}
+	}
+	return caller{}, errBadCredentials
+}
+
+// requireAuth wraps a handler so it only runs for requests with a valid admin
+// token or API key, and tells it who the caller is.
+func (h *Handler) requireAuth(next func(w http.ResponseWriter, r *http.Request, c caller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := h.authenticate(r)
+		if err != nil {
+			unauthorized(w)
+			return
+		}
+		next(w, r, c)
+	}
+}
+
+// requireAdmin is like requireAuth, but only lets the admin token through.
+// API keys get 403 Forbidden: they are valid, just not allowed here.
+func (h *Handler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return h.requireAuth(func(w http.ResponseWriter, r *http.Request, c caller) {
+		if !c.admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// unauthorized sends a 401 response asking for a bearer token.
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="urlshortener"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+)
+
+/*
+POST /api/shorten/batch shortens many URLs in one request. The body is either
+
+  - a JSON array of the same objects /api/shorten accepts:
+    [{"url": "https://go.dev"}, {"url": "https://pkg.go.dev", "alias": "pkg"}]
+  - or CSV (Content-Type: text/csv) with the columns url, alias, ttl_seconds.
+    A first row that names the columns (one of them "url") is treated as a
+    header; it may list them in any order, and may add expires_at.
+
+Each row is handled exactly like a single request to /api/shorten, and one
+bad row doesn't stop the others: every row gets its own result, with either
+the short URL or an error.
+
+Batches can hold thousands of rows, so neither the request nor the response
+is ever held in memory as a whole. We read the body one row at a time (with
+json.Decoder's streaming Token/More API, or csv.Reader), and write each result
+as soon as it's ready as NDJSON ("newline-delimited JSON"): one JSON object per
+line. The client can also read the results as they arrive.
+
+A batch does the work of many requests, so it is rate-limited like them: each
+row takes a token from the client's bucket, the same one /api/shorten uses.
+Once the bucket is empty, the batch stops with a 429 result, and the client
+can send the remaining rows later. Passwords are refused in batches, because
+hashing one is deliberately slow; protected links are created one at a time.
+*/
+
+const (
+	// MaxBatchRows is the largest number of rows accepted in one batch.
+	MaxBatchRows = 10000
+	// maxBatchBytes bounds the size of a batch request body.
+	maxBatchBytes = 16 << 20
+
+	// A large batch takes longer than the server's read and write timeouts
+	// allow a single request. Instead, each row gets batchRowTimeout to
+	// arrive and be answered, and the whole batch at most maxBatchDuration,
+	// so a client that stalls, or trickles rows in, still can't hold the
+	// connection forever.
+	batchRowTimeout  = 10 * time.Second
+	maxBatchDuration = 10 * time.Minute
+)
+
+// BatchResult is one line of the NDJSON response of POST /api/shorten/batch.
+// Row counts from 1 and, for CSV, doesn't include the header.
+type BatchResult struct {
+	Row         int        `json:"row"`
+	Status      int        `json:"status"`
+	OriginalURL string     `json:"original_url,omitempty"`
+	ShortURL    string     `json:"short_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// Reason is set when the destination was refused; see DestinationError.
+	Reason policy.Reason `json:"reason,omitempty"`
+}
+
+// batchReader yields the rows of a batch one at a time.
+// next returns io.EOF after the last row. A rowError means this row is bad
+// but reading can continue; any other error ends the batch.
+type batchReader interface {
+	next() (ShortenURLRequest, error)
+}
+
+// rowError is a problem with a single row of a batch.
+type rowError struct {
+	msg string
+}
+
+func (e *rowError) Error() string {
+	return e.msg
+}
+
+// BatchShortenHandler serves POST /api/shorten/batch.
+func (h *Handler) BatchShortenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c, err := h.authenticate(r)
+	if err != nil && !(errors.Is(err, errNoCredentials) && h.allowAnonymous) {
+		unauthorized(w)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxBatchBytes)
+	var rows batchReader
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json", "":
+		rows, err = newJSONBatch(body)
+	case "text/csv":
+		rows, err = newCSVBatch(body)
+	default:
+		http.Error(w, "Content-Type must be application/json or text/csv", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// From here on the status is 200: results are written while the batch is
+	// still being read, so per-row outcomes are reported in each result instead.
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+	end := time.Now().Add(maxBatchDuration)
+
+	// Rate-limit buckets belong to an API key or an IP address; see rateLimitKey.
+	limitKey := h.rateLimitKey(r)
+	created := 0
+	for row := 1; ; row++ {
+		deadline := time.Now().Add(batchRowTimeout)
+		if deadline.After(end) {
+			deadline = end
+		}
+		rc.SetReadDeadline(deadline)
+		rc.SetWriteDeadline(deadline)
+
+		req, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		result := BatchResult{Row: row}
+		stop := false
+		var rowErr *rowError
+		switch {
+		case row > MaxBatchRows:
+			result.Status = http.StatusRequestEntityTooLarge
+			result.Error = fmt.Sprintf("Batch is limited to %d rows; the rest was not processed", MaxBatchRows)
+			stop = true
+		case errors.As(err, &rowErr):
+			result.Status = http.StatusBadRequest
+			result.Error = rowErr.msg
+		case err != nil:
+			// The body itself is broken (e.g. invalid JSON), so we can't find the next row.
+			result.Status = http.StatusBadRequest
+			result.Error = "Invalid request body: " + err.Error()
+			stop = true
+		case !h.allowRow(row, limitKey, &result):
+			stop = true
+		default:
+			result = h.shortenRow(r.Context(), row, req, c.owner)
+			if result.Status == http.StatusCreated {
+				created++
+			}
+		}
+
+		if err := enc.Encode(result); err != nil {
+			// The client has gone away; there is nobody left to report to.
+			h.logger.ErrorContext(r.Context(), "Failed to write batch result", "error", err)
+			return
+		}
+		// Push the line out now, so the client sees progress as it happens.
+		rc.Flush()
+		if stop {
+			break
+		}
+	}
+	h.logger.InfoContext(r.Context(), "Batch done", "created", created)
+}
+
+// allowRow takes a rate-limit token for a row. The first row was paid for
+// by the request. If the bucket is empty, it fills in the result and reports
+// false.
+func (h *Handler) allowRow(row int, key string, result *BatchResult) bool {
+	if h.createLimiter == nil || row == 1 {
+		return true
+	}
+	d := h.createLimiter.Allow(key)
+	if !d.Allowed {
+		result.Status = http.StatusTooManyRequests
+		result.Error = "Too many requests; the rest was not processed. Retry it in " + seconds(d.RetryAfter) + " seconds"
+	}
+	return d.Allowed
+}
+
+// shortenRow runs one row through the same logic as /api/shorten.
+func (h *Handler) shortenRow(ctx context.Context, row int, req ShortenURLRequest, owner string) BatchResult {
+	if req.Password != "" {
+		return BatchResult{Row: row, Status: http.StatusBadRequest, OriginalURL: req.URL, Error: "Passwords can't be set in a batch; use /api/shorten"}
+	}
+	link, status, err := h.shorten(ctx, req, owner)
+	var reqErr *requestError
+	var violation *policy.Violation
+	switch {
+	case errors.As(err, &reqErr):
+		return BatchResult{Row: row, Status: reqErr.status, OriginalURL: req.URL, Error: reqErr.msg}
+	case errors.As(err, &violation):
+		refused := refusal(violation)
+		return BatchResult{Row: row, Status: http.StatusUnprocessableEntity, OriginalURL: req.URL, Error: refused.Error, Reason: refused.Reason}
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to shorten batch row", "row", row, "error", err)
+		return BatchResult{Row: row, Status: http.StatusInternalServerError, OriginalURL: req.URL, Error: "Internal server error"}
+	}
+	resp := h.linkResponse(link)
+	return BatchResult{Row: row, Status: status, OriginalURL: resp.OriginalURL, ShortURL: resp.ShortURL, ExpiresAt: resp.ExpiresAt}
+}
+
+// --- JSON ---
+
+// jsonBatch reads a JSON array one element at a time.
+type jsonBatch struct {
+	dec *json.Decoder
+}
+
+func newJSONBatch(r io.Reader) (*jsonBatch, error) {
+	dec := json.NewDecoder(r)
+	// The first token must be the opening bracket of the array.
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("expected a JSON array")
+	}
+	return &jsonBatch{dec: dec}, nil
+}
+
+func (b *jsonBatch) next() (ShortenURLRequest, error) {
+	if !b.dec.More() {
+		return ShortenURLRequest{}, io.EOF
+	}
+	// Decode into a RawMessage first: a well-formed element of the wrong shape
+	// (say, a number) is then a bad row, not the end of the batch.
+	var raw json.RawMessage
+	if err := b.dec.Decode(&raw); err != nil {
+		return ShortenURLRequest{}, err
+	}
+	var req ShortenURLRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return ShortenURLRequest{}, &rowError{"Invalid row: " + err.Error()}
+	}
+	return req, nil
+}
+
+// --- CSV ---
+
+// csvBatch reads CSV records one at a time.
+type csvBatch struct {
+	r *csv.Reader
+	// columns maps a column name to its index in each record.
+	columns map[string]int
+	// pending holds the first record when it turned out not to be a header.
+	pending []string
+}
+
+// defaultCSVColumns is the column order of a CSV batch without a header row.
+var defaultCSVColumns = []string{"url", "alias", "ttl_seconds"}
+
+func newCSVBatch(r io.Reader) (*csvBatch, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // rows may leave out trailing columns
+	cr.TrimLeadingSpace = true
+
+	b := &csvBatch{r: cr, columns: make(map[string]int)}
+	first, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isCSVHeader(first) {
+		for i, name := range first {
+			name = strings.ToLower(strings.TrimSpace(name))
+			switch name {
+			case "url", "alias", "ttl_seconds", "expires_at":
+				b.columns[name] = i
+			default:
+				return nil, fmt.Errorf("unknown CSV column %q", name)
+			}
+		}
+		return b, nil
+	}
+	for i, name := range defaultCSVColumns {
+		b.columns[name] = i
+	}
+	b.pending = first
+	return b, nil
+}
+
+// isCSVHeader reports whether a record names the columns rather than holding
+// data. No URL that passes validation is spelled "url", so that's a safe tell.
+func isCSVHeader(record []string) bool {
+	for _, field := range record {
+		if strings.EqualFold(strings.TrimSpace(field), "url") {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *csvBatch) next() (ShortenURLRequest, error) {
+	record := b.pending
+	b.pending = nil
+	if record == nil {
+		var err error
+		record, err = b.r.Read()
+		if err != nil {
+			return ShortenURLRequest{}, err
+		}
+	}
+
+	field := func(name string) string {
+		i, ok := b.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	req := ShortenURLRequest{URL: field("url"), Alias: field("alias")}
+	if s := field("ttl_seconds"); s != "" {
+		ttl, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return req, &rowError{fmt.Sprintf("Invalid ttl_seconds %q", s)}
+		}
+		req.TTLSeconds = ttl
+	}
+	if s := field("expires_at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return req, &rowError{fmt.Sprintf("Invalid expires_at %q: use RFC 3339", s)}
+		}
+		req.ExpiresAt = &t
+	}
+	return req, nil
+}
@@ -0,0 +1,132 @@
+package handler_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/handler"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
+)
+
+// batch posts a body to /api/shorten/batch through h and decodes the NDJSON
+// results. The status of the response itself is always checked to be 200.
+func batch(t *testing.T, h http.Handler, contentType, body string) []handler.BatchResult {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/shorten/batch = %d (%s); want 200", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	var results []handler.BatchResult
+	lines := bufio.NewScanner(w.Body)
+	for lines.Scan() {
+		var res handler.BatchResult
+		if err := json.Unmarshal(lines.Bytes(), &res); err != nil {
+			t.Fatalf("decoding result %q: %v", lines.Text(), err)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// statuses returns the status of every result, in order.
+func statuses(results []handler.BatchResult) []int {
+	var s []int
+	for _, res := range results {
+		s = append(s, res.Status)
+	}
+	return s
+}
+
+// TestBatchPartialFailure checks that bad rows get an error result of their
+// own and don't stop the rows after them.
+func TestBatchPartialFailure(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []int
+	}{
+		{
+			"json", "application/json", `[
+				{"url": "https://go.dev"},
+				{"url": "not a url"},
+				42,
+				{"url": "https://go.dev/play", "password": "correct horse"},
+				{"url": "https://go.dev/blog", "alias": "go-blog"},
+				{"url": "https://pkg.go.dev", "alias": "go-blog"},
+				{"url": "https://go.dev"}
+			]`,
+			[]int{201, 400, 400, 400, 201, 409, 200},
+		},
+		{
+			"csv", "text/csv", "url,ttl_seconds\nhttps://go.dev/doc,\nhttps://go.dev/tour,soon\nhttps://go.dev/ref,3600\n",
+			[]int{201, 400, 201},
+		},
+		{
+			"broken json", "application/json", `[{"url": "https://go.dev/a"}, {"url": `,
+			[]int{201, 400},
+		},
+	}
+	for _, tt := range tests {
+		results := batch(t, http.HandlerFunc(h.BatchShortenHandler), tt.contentType, tt.body)
+		if got := statuses(results); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: statuses = %v; want %v", tt.name, got, tt.want)
+		}
+		for i, res := range results {
+			if res.Row != i+1 {
+				t.Errorf("%s: result %d is for row %d", tt.name, i+1, res.Row)
+			}
+			if (res.Status >= 400) != (res.Error != "") || (res.Status < 400) != (res.ShortURL != "") {
+				t.Errorf("%s: row %d = %+v; want an error or a short URL, to match the status", tt.name, res.Row, res)
+			}
+		}
+	}
+}
+
+// TestBatchRowLimit checks that a batch stops after MaxBatchRows rows.
+func TestBatchRowLimit(t *testing.T) {
+	h, s := newTestHandler(t)
+	body := strings.Repeat("https://go.dev/\n", handler.MaxBatchRows+5)
+	results := batch(t, http.HandlerFunc(h.BatchShortenHandler), "text/csv", body)
+
+	if len(results) != handler.MaxBatchRows+1 {
+		t.Fatalf("got %d results; want %d", len(results), handler.MaxBatchRows+1)
+	}
+	if last := results[len(results)-1]; last.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("last result = %+v; want 413", last)
+	}
+	if n, _ := s.Count(); n != 1 {
+		t.Errorf("store holds %d links; want the one link all rows asked for", n)
+	}
+}
+
+// TestBatchRateLimit checks that every row after the first takes a token
+// from the client's bucket, and that the batch ends when the bucket is empty.
+func TestBatchRateLimit(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.Limit{Rate: 0, Burst: 3})
+	h, _ := newTestHandler(t, func(o *handler.Options) { o.CreateLimiter = limiter })
+	route := h.RateLimit(limiter, http.HandlerFunc(h.BatchShortenHandler))
+
+	body := "https://go.dev/1\nhttps://go.dev/2\nhttps://go.dev/3\nhttps://go.dev/4\nhttps://go.dev/5\n"
+	results := batch(t, route, "text/csv", body)
+	if got, want := statuses(results), []int{201, 201, 201, 429}; !slices.Equal(got, want) {
+		t.Errorf("statuses = %v; want %v", got, want)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	route.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("second batch = %d; want 429", w.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+/*
+Working out the client's IP address is harder than it looks once the service
+runs behind a reverse proxy or load balancer. The TCP connection then comes
+from the proxy, so `r.RemoteAddr` is the proxy's address, and the real client
+is only mentioned in the `X-Forwarded-For` header:
+
+	X-Forwarded-For: <client>, <proxy 1>, <proxy 2>
+
+Each proxy APPENDS the address it received the request from. The header is
+just text, though, and any client can send one with whatever they like in it.
+Believing it blindly would let anyone dodge the rate limiter by claiming a new
+IP with every request.
+
+So we only trust the parts of the header that our own proxies wrote. We walk
+it from right to left, starting at the connection's address: as long as the
+address belongs to a trusted proxy, the entry before it was written by that
+proxy and can be believed. The first address that is NOT a trusted proxy is
+the client.
+*/
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges
+// (e.g. "10.0.0.0/8" or "192.168.1.10") for Options.TrustedProxies.
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range %q: %w", s, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q: %w", s, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the IP address of the client that sent the request,
+// looking through X-Forwarded-For only as far as our trusted proxies go.
+func (h *Handler) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !h.isTrustedProxy(ip) {
+		return ip
+	}
+
+	// Several X-Forwarded-For headers count as one comma-separated list.
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// Garbage in the header: stop at the last address we could trust.
+			break
+		}
+		ip = hop
+		if !h.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrustedProxy reports whether ip belongs to one of the trusted proxies.
+func (h *Handler) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range h.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP address at the other end of the TCP connection.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
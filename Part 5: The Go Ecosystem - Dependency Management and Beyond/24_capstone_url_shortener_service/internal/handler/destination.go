@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+)
+
+/*
+Every destination goes through the same two steps before it is stored,
+whether it comes from /api/shorten, a batch row, or a PATCH:
+
+ 1. It is CANONICALIZED (see the canonical package), so equivalent URLs are
+    stored the same way.
+ 2. It is checked against the destination POLICY (see the policy package):
+    allowed schemes, blocked domains, private addresses, redirect loops.
+
+A refused destination is answered with `422 Unprocessable Entity` and a JSON
+body that says why, so API clients can react to the reason programmatically:
+
+	{"error": "Destination not allowed: evil.example is blocked", "reason": "domain_blocked"}
+*/
+
+// DestinationError is the JSON body of a response refusing a destination.
+type DestinationError struct {
+	Error  string        `json:"error"`
+	Reason policy.Reason `json:"reason"`
+}
+
+// destination canonicalizes a requested destination and checks it against
+// the policy. An unusable URL is returned as a *requestError, a refused one
+// as a *policy.Violation.
+func (h *Handler) destination(ctx context.Context, raw string) (string, error) {
+	canonical, err := h.canon.Canonicalize(raw)
+	if err != nil {
+		// Give the policy a look first: "javascript:alert(1)" isn't a URL we
+		// can canonicalize, but the more useful answer is that its scheme
+		// isn't allowed.
+		var violation *policy.Violation
+		if h.policy != nil && errors.As(h.policy.Check(ctx, raw), &violation) && violation.Reason != policy.ReasonInvalidURL {
+			return "", violation
+		}
+		return "", &requestError{http.StatusBadRequest, "Invalid URL format"}
+	}
+	if h.policy != nil {
+		if err := h.policy.Check(ctx, canonical); err != nil {
+			return "", err
+		}
+	}
+	return canonical, nil
+}
+
+// refusal builds the response body for a refused destination.
+func refusal(v *policy.Violation) DestinationError {
+	return DestinationError{Error: "Destination not allowed: " + v.Detail, Reason: v.Reason}
+}
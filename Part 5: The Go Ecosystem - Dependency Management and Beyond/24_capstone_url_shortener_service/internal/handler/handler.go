@@ -1,13 +1,26 @@
 package handler
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"net/netip"
+	"slices"
 	"strings"
+	"time"
 
 	// --- CORRECTED IMPORT PATHS ---
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/analytics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/apikey"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/canonical"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/linkcheck"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/metrics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
 	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/shortener"
 	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
 )
@@ -21,29 +34,170 @@ redirect responses.
 
 // Handler is a struct that holds the dependencies for our HTTP handlers.
 type Handler struct {
-	logger  *log.Logger
-	store   *store.URLStore
+	logger  *slog.Logger
+	store   store.Store
+	codes   shortener.Generator
+	clicks  *analytics.Tracker
+	keys    *apikey.Keyring
+	canon   *canonical.Canonicalizer
 	baseURL string
+	// adminToken may manage every link (see auth.go). Empty disables it.
+	adminToken string
+	// allowAnonymous lets requests without an API key create links.
+	allowAnonymous bool
+	// trustedProxies are the proxies whose X-Forwarded-For header we believe.
+	trustedProxies []netip.Prefix
+	// policy decides which destinations may be shortened. Nil allows all.
+	policy  *policy.Policy
+	metrics handlerMetrics
+	// passwordLimiter throttles password attempts per code, and cookieSecret
+	// signs unlock cookies (see password.go).
+	passwordLimiter *ratelimit.Limiter
+	cookieSecret    []byte
+	// createLimiter charges batch rows to the client's API allowance (see batch.go).
+	createLimiter *ratelimit.Limiter
+	// checker knows which destinations are broken. Nil if checks are off.
+	checker *linkcheck.Checker
 }
 
+// Options holds the handler settings that come from the configuration.
+type Options struct {
+	// BaseURL is prepended to codes to build short URLs, e.g. "https://sho.rt".
+	BaseURL string
+	// AdminToken is a bearer token that may list and manage every link.
+	// Leave it empty to disable admin access.
+	AdminToken string
+	// AllowAnonymous lets clients without an API key create links. Their
+	// links have no owner, so only the admin can manage them later.
+	AllowAnonymous bool
+	// TrustedProxies lists the reverse proxies (load balancers, CDNs) in front
+	// of the service. Only requests coming from them may set the client IP
+	// with X-Forwarded-For. See ParseTrustedProxies.
+	TrustedProxies []netip.Prefix
+	// StripTrackingParams removes tracking parameters (utm_*, fbclid) from
+	// URLs before they are stored. TrackingParams overrides which ones;
+	// see canonical.Options.
+	StripTrackingParams bool
+	TrackingParams      []string
+	// Policy, if set, refuses unwanted destinations (see destination.go).
+	Policy *policy.Policy
+	// Metrics, if set, is where the handler registers its metrics (see metrics.go).
+	Metrics *metrics.Registry
+	// PasswordLimiter, if set, limits how fast the password of each protected
+	// link may be guessed.
+	PasswordLimiter *ratelimit.Limiter
+	// CreateLimiter, if set, must be the limiter the batch endpoint is
+	// wrapped in with RateLimit. The request itself pays for its first row,
+	// and every further row takes another token from the same bucket.
+	CreateLimiter *ratelimit.Limiter
+	// CookieSecret signs the cookies that unlock protected links. If it is
+	// empty, a random one is made up, and unlocked links lock again when the
+	// process restarts.
+	CookieSecret []byte
+	// Checker, if set, supplies the destination health shown by the
+	// management API.
+	Checker *linkcheck.Checker
+}
+
+// maxCodeAttempts bounds how many generated codes we try for one request
+// before giving up, so a nearly full keyspace can't make a request spin forever.
+const maxCodeAttempts = 10
+
+// errNoFreeCode is returned when every generated code was already taken.
+var errNoFreeCode = errors.New("no free short code found")
+
 // NewHandler is a constructor that creates a new Handler with its dependencies.
-func NewHandler(logger *log.Logger, store *store.URLStore, baseURL string) *Handler {
+// The store can be any implementation of the store.Store interface.
+func NewHandler(logger *slog.Logger, store store.Store, codes shortener.Generator, clicks *analytics.Tracker, keys *apikey.Keyring, opts Options) *Handler {
+	canon := canonical.New(canonical.Options{
+		StripTracking:  opts.StripTrackingParams,
+		TrackingParams: opts.TrackingParams,
+	})
+	secret := opts.CookieSecret
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
 	return &Handler{
-		logger:  logger,
-		store:   store,
-		baseURL: baseURL,
+		logger:         logger,
+		store:          store,
+		codes:          codes,
+		clicks:         clicks,
+		keys:           keys,
+		canon:          canon,
+		policy:         opts.Policy,
+		metrics:        newHandlerMetrics(opts.Metrics, store, logger),
+		baseURL:        opts.BaseURL,
+		adminToken:     opts.AdminToken,
+		allowAnonymous: opts.AllowAnonymous,
+		trustedProxies: opts.TrustedProxies,
+
+		passwordLimiter: opts.PasswordLimiter,
+		cookieSecret:    secret,
+		createLimiter:   opts.CreateLimiter,
+		checker:         opts.Checker,
 	}
 }
 
 // ShortenURLRequest defines the expected structure of the JSON request body.
 type ShortenURLRequest struct {
 	URL string `json:"url"`
+	// Alias is an optional custom short code, e.g. "launch-2026".
+	// When empty, a random code is generated.
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt and TTLSeconds are two optional ways to make a link expire:
+	// at a fixed moment, or a number of seconds from now. Set at most one.
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	// InterstitialSeconds, if set, shows visitors a preview page that counts
+	// down this many seconds (at most MaxInterstitialSeconds) before redirecting.
+	InterstitialSeconds int `json:"interstitial_seconds,omitempty"`
+	// RedirectStatus, ForwardQuery and UTMTemplate control how visitors are
+	// redirected; see redirect.go.
+	RedirectStatus int    `json:"redirect_status,omitempty"`
+	ForwardQuery   bool   `json:"forward_query,omitempty"`
+	UTMTemplate    string `json:"utm_template,omitempty"`
+	// Password, if set, must be entered by visitors before they are
+	// redirected; see password.go.
+	Password string `json:"password,omitempty"`
+	// Variants, instead of URL, split the link's traffic between several
+	// destinations, and Sticky keeps each visitor on one; see split.go.
+	Variants []store.Variant `json:"variants,omitempty"`
+	Sticky   string          `json:"sticky,omitempty"`
 }
 
 // ShortenURLResponse defines the structure of the JSON response body.
 type ShortenURLResponse struct {
-	OriginalURL string `json:"original_url"`
-	ShortURL    string `json:"short_url"`
+	OriginalURL         string     `json:"original_url"`
+	ShortURL            string     `json:"short_url"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	InterstitialSeconds int        `json:"interstitial_seconds,omitempty"`
+	RedirectStatus      int        `json:"redirect_status,omitempty"`
+	ForwardQuery        bool       `json:"forward_query,omitempty"`
+	UTMTemplate         string     `json:"utm_template,omitempty"`
+	PasswordProtected   bool       `json:"password_protected,omitempty"`
+	// Variants and Sticky are only set for split links.
+	Variants []store.Variant `json:"variants,omitempty"`
+	Sticky   string          `json:"sticky,omitempty"`
+}
+
+// expiry works out when the requested link should expire.
+// The zero time means the link never expires.
+func (req ShortenURLRequest) expiry(now time.Time) (time.Time, error) {
+	switch {
+	case req.ExpiresAt != nil && req.TTLSeconds != 0:
+		return time.Time{}, errors.New("set either expires_at or ttl_seconds, not both")
+	case req.ExpiresAt != nil:
+		if !req.ExpiresAt.After(now) {
+			return time.Time{}, errors.New("expires_at must be in the future")
+		}
+		return *req.ExpiresAt, nil
+	case req.TTLSeconds < 0:
+		return time.Time{}, errors.New("ttl_seconds must be positive")
+	case req.TTLSeconds > 0:
+		return now.Add(time.Duration(req.TTLSeconds) * time.Second), nil
+	}
+	return time.Time{}, nil
 }
 
 // ShortenURLHandler handles requests to create a new short URL.
@@ -53,65 +207,400 @@ func (h *Handler) ShortenURLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c, err := h.authenticate(r)
+	if err != nil && !(errors.Is(err, errNoCredentials) && h.allowAnonymous) {
+		unauthorized(w)
+		return
+	}
+
 	var req ShortenURLRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if _, err := url.ParseRequestURI(req.URL); err != nil {
-		http.Error(w, "Invalid URL format", http.StatusBadRequest)
+	// Links created with the admin token, or anonymously, have no owner.
+	link, status, err := h.shorten(r.Context(), req, c.owner)
+	var reqErr *requestError
+	var violation *policy.Violation
+	switch {
+	case errors.As(err, &reqErr):
+		http.Error(w, reqErr.msg, reqErr.status)
+		return
+	case errors.As(err, &violation):
+		h.respondWithJSON(w, http.StatusUnprocessableEntity, refusal(violation))
 		return
 	}
-
-	if code, found := h.store.GetCodeForURL(req.URL); found {
-		h.logger.Printf("Found existing code '%s' for URL '%s'", code, req.URL)
-		h.respondWithJSON(w, http.StatusOK, ShortenURLResponse{
-			OriginalURL: req.URL,
-			ShortURL:    h.baseURL + "/" + code,
-		})
+	if err != nil {
+		h.serverError(w, r, "shorten URL", err)
 		return
 	}
+	h.respondWithJSON(w, status, h.linkResponse(link))
+}
+
+// requestError is a problem with what the client asked for, as opposed to a
+// failure on our side. It carries the HTTP status to report it with.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// shorten validates one request and creates (or finds) its link on behalf of
+// owner. It returns the link and the HTTP status that describes the outcome:
+// 201 for a new link, 200 for an existing one. Problems with the request are
+// returned as *requestError, refused destinations as *policy.Violation; any
+// other error is a server failure.
+func (h *Handler) shorten(ctx context.Context, req ShortenURLRequest, owner string) (store.Link, int, error) {
+	// A split link's URL is that of its first variant. It is not indexed
+	// under that URL, though (see store.Link.Indexed), so every request for a
+	// split gets a link of its own.
+	var err error
+	if len(req.Variants) > 0 {
+		if req.URL != "" {
+			return store.Link{}, 0, &requestError{http.StatusBadRequest, "Set either url or variants, not both"}
+		}
+		if req.Variants, err = h.splitDestinations(ctx, req.Variants); err != nil {
+			return store.Link{}, 0, err
+		}
+		req.URL = req.Variants[0].URL
+	} else {
+		// URLs are stored in canonical form, so that equivalent spellings of one
+		// URL share an index entry and get the same code.
+		if req.URL, err = h.destination(ctx, req.URL); err != nil {
+			return store.Link{}, 0, err
+		}
+	}
 
-	var code string
+	now := time.Now()
+	expiresAt, err := req.expiry(now)
+	if err != nil {
+		return store.Link{}, 0, &requestError{http.StatusBadRequest, "Invalid expiry: " + err.Error()}
+	}
+	link := store.Link{
+		URL:                 req.URL,
+		CreatedAt:           now,
+		ExpiresAt:           expiresAt,
+		Owner:               owner,
+		InterstitialSeconds: req.InterstitialSeconds,
+		RedirectStatus:      req.RedirectStatus,
+		ForwardQuery:        req.ForwardQuery,
+		UTMTemplate:         req.UTMTemplate,
+		Variants:            req.Variants,
+		Sticky:              req.Sticky,
+	}
+	if err := validateSettings(link); err != nil {
+		return store.Link{}, 0, &requestError{http.StatusBadRequest, err.Error()}
+	}
+	if req.Password != "" {
+		if link.PasswordHash, err = hashPassword(req.Password); err != nil {
+			return store.Link{}, 0, err
+		}
+	}
+
+	if req.Alias != "" {
+		link.Code = req.Alias
+		link, status, err := h.createAlias(ctx, link)
+		if status == http.StatusCreated {
+			h.metrics.linksCreated.Inc()
+		}
+		return link, status, err
+	}
+
+	// Only requests for permanent links are idempotent. A request for an
+	// expiring link always gets a fresh code, because it asks for a different
+	// lifetime than any link we may already have. Likewise, a request whose
+	// settings differ from the existing link's gets a link of its own. If
+	// that one has the default settings, it takes over the index entry (see
+	// store.Set), so the next plain request for the URL finds it.
+	// Protected and split links are left out of the index, so a request for
+	// one always gets a link of its own.
+	if link.Permanent() && link.Indexed() {
+		existing, found, err := h.findPermanentLink(link.Owner, req.URL)
+		if err != nil {
+			return store.Link{}, 0, fmt.Errorf("look up URL: %w", err)
+		}
+		if found && sameSettings(existing, link) {
+			h.logger.InfoContext(ctx, "Found existing code", "code", existing.Code, "url", req.URL)
+			return existing, http.StatusOK, nil
+		}
+	}
+
+	link, err = h.createWithGeneratedCode(link)
+	if errors.Is(err, errNoFreeCode) {
+		h.logger.ErrorContext(ctx, "Gave up after code collisions", "attempts", maxCodeAttempts, "url", req.URL)
+		return store.Link{}, 0, &requestError{http.StatusServiceUnavailable, "Could not allocate a short code, please retry"}
+	}
+	if err != nil {
+		return store.Link{}, 0, fmt.Errorf("save URL: %w", err)
+	}
+	h.metrics.linksCreated.Inc()
+	h.logger.InfoContext(ctx, "Created new code", "code", link.Code, "url", req.URL)
+	return link, http.StatusCreated, nil
+}
+
+// createWithGeneratedCode asks the generator for codes until one can be stored.
+// Create fails with ErrExists if the code is already taken (possibly by a
+// request that got there a moment earlier), in which case we try again.
+func (h *Handler) createWithGeneratedCode(link store.Link) (store.Link, error) {
+	links, err := h.store.Count()
+	if err != nil {
+		return link, err
+	}
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := h.codes.Generate(shortener.Request{URL: link.URL, Attempt: attempt, Links: links})
+		if err != nil {
+			return link, err
+		}
+		if shortener.IsReserved(code) {
+			continue
+		}
+		link.Code = code
+		err = h.store.Create(link)
+		if !errors.Is(err, store.ErrExists) {
+			return link, err
+		}
+	}
+	return link, errNoFreeCode
+}
+
+// resumePageSize is how many links ResumeCodes reads at a time.
+const resumePageSize = 1000
+
+// ResumeCodes moves a counting generator (see shortener.Resumer) past every
+// code in the store, so that it doesn't propose codes that are taken. The
+// server calls it at startup, and the import endpoint after every import.
+func (h *Handler) ResumeCodes(ctx context.Context) error {
+	r, ok := h.codes.(shortener.Resumer)
+	if !ok {
+		return nil
+	}
+	after := ""
 	for {
-		code = shortener.GenerateShortCode()
-		if _, found := h.store.Get(code); !found {
-			break
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		links, err := h.store.List(store.ListOptions{After: after, Limit: resumePageSize})
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			r.Resume(link.Code)
+		}
+		if len(links) < resumePageSize {
+			return nil
+		}
+		after = links[len(links)-1].Code
+	}
+}
+
+// findPermanentLink returns the permanent link the owner already has for a URL, if any.
+func (h *Handler) findPermanentLink(owner, originalURL string) (store.Link, bool, error) {
+	code, err := h.store.GetCodeForURL(owner, originalURL)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Link{}, false, nil
+	}
+	if err != nil {
+		return store.Link{}, false, err
+	}
+	link, err := h.store.Get(code)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Link{}, false, nil
+	}
+	if err != nil {
+		return store.Link{}, false, err
+	}
+	return link, link.Permanent(), nil
+}
+
+// createAlias stores a link under the custom alias chosen by the client.
+// Asking for the same alias and URL twice is idempotent; asking for an alias
+// that already points somewhere else, or belongs to someone else, is a conflict.
+func (h *Handler) createAlias(ctx context.Context, link store.Link) (store.Link, int, error) {
+	if err := shortener.ValidateAlias(link.Code); err != nil {
+		return store.Link{}, 0, &requestError{http.StatusBadRequest, "Invalid alias: " + err.Error()}
+	}
+
+	err := h.store.Create(link)
+	if errors.Is(err, store.ErrExists) {
+		existing, err := h.store.Get(link.Code)
+		if err != nil {
+			return store.Link{}, 0, fmt.Errorf("look up alias: %w", err)
+		}
+		if existing.URL != link.URL || existing.Owner != link.Owner || !sameSettings(existing, link) || existing.Expired(time.Now()) {
+			return store.Link{}, 0, &requestError{http.StatusConflict, "Alias is already taken"}
 		}
+		h.logger.InfoContext(ctx, "Found existing alias", "code", link.Code, "url", link.URL)
+		return existing, http.StatusOK, nil
+	}
+	if err != nil {
+		return store.Link{}, 0, fmt.Errorf("save alias: %w", err)
 	}
 
-	h.store.Set(code, req.URL)
-	h.logger.Printf("Created new code '%s' for URL '%s'", code, req.URL)
+	h.logger.InfoContext(ctx, "Created alias", "code", link.Code, "url", link.URL)
+	return link, http.StatusCreated, nil
+}
 
-	h.respondWithJSON(w, http.StatusCreated, ShortenURLResponse{
-		OriginalURL: req.URL,
-		ShortURL:    h.baseURL + "/" + code,
-	})
+// linkResponse builds the JSON response describing a stored link.
+func (h *Handler) linkResponse(link store.Link) ShortenURLResponse {
+	resp := ShortenURLResponse{
+		OriginalURL: link.URL,
+		ShortURL:    h.baseURL + "/" + link.Code,
+	}
+	if !link.Permanent() {
+		expiresAt := link.ExpiresAt
+		resp.ExpiresAt = &expiresAt
+	}
+	resp.InterstitialSeconds = link.InterstitialSeconds
+	resp.RedirectStatus = link.RedirectStatus
+	resp.ForwardQuery = link.ForwardQuery
+	resp.UTMTemplate = link.UTMTemplate
+	resp.PasswordProtected = link.PasswordHash != ""
+	resp.Variants = link.Variants
+	resp.Sticky = link.Sticky
+	return resp
+}
+
+// validateSettings checks the optional per-link settings.
+func validateSettings(link store.Link) error {
+	if err := validateInterstitial(link.InterstitialSeconds); err != nil {
+		return err
+	}
+	if err := store.ValidateRedirectStatus(link.RedirectStatus); err != nil {
+		return err
+	}
+	if err := store.ValidateUTMTemplate(link.UTMTemplate); err != nil {
+		return err
+	}
+	return validateSplit(link)
+}
+
+// sameSettings reports whether two links behave the same when followed, so a
+// request for one can be answered with the other. Protected links are never
+// found by URL (see shorten), so comparing the hashes only tells a protected
+// link from an unprotected one; two hashes of one password never match,
+// because they are salted.
+func sameSettings(a, b store.Link) bool {
+	return a.InterstitialSeconds == b.InterstitialSeconds &&
+		redirectStatus(a) == redirectStatus(b) &&
+		a.ForwardQuery == b.ForwardQuery &&
+		a.UTMTemplate == b.UTMTemplate &&
+		a.PasswordHash == b.PasswordHash &&
+		slices.Equal(a.Variants, b.Variants) &&
+		a.Sticky == b.Sticky
 }
 
 // RedirectHandler handles redirecting a short URL to its original destination.
 func (h *Handler) RedirectHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/")
+	if code == "" {
+		// The root is the home page of the web UI (see web.go).
+		h.HomeHandler(w, r)
+		return
+	}
+	// POST is only used to submit the password form of a protected link.
+	if r.Method == http.MethodPost && !strings.Contains(code, ".") {
+		h.unlock(w, r, code)
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	code := strings.TrimPrefix(r.URL.Path, "/")
-	if code == "" {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Welcome to the Go URL Shortener! Use POST /api/shorten to create a link."))
+	if code, format, ok := strings.Cut(code, "."); ok {
+		h.serveQRCode(w, r, code, format)
+		return
+	}
+	if code, ok := strings.CutSuffix(code, "+"); ok {
+		h.servePreview(w, r, code)
 		return
 	}
 
-	originalURL, found := h.store.Get(code)
-	if !found {
+	link, ok := h.activeLink(w, r, code)
+	if !ok || !h.requirePassword(w, r, link) {
+		return
+	}
+	// A split link sends this click to one of its variants (see split.go).
+	variant, split := h.chooseVariant(w, r, link)
+	if split {
+		link.URL = variant.URL
+	}
+
+	// Record the click for the stats endpoint. This never blocks: if the
+	// analytics pipeline is backed up, the click is dropped instead.
+	h.clicks.Record(analytics.Click{
+		Code:      code,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        h.clientIP(r),
+		Variant:   variant.Name,
+	})
+
+	h.metrics.redirects.Inc()
+
+	target := redirectTarget(link, r)
+	if link.InterstitialSeconds > 0 {
+		h.serveInterstitial(w, r, link, target)
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "Redirecting", "code", code, "url", target)
+	http.Redirect(w, r, target, redirectStatus(link))
+}
+
+// activeLink looks up the link behind a short code. If there is no usable
+// link, it writes the error response itself and returns false.
+func (h *Handler) activeLink(w http.ResponseWriter, r *http.Request, code string) (store.Link, bool) {
+	link, err := h.store.Get(code)
+	if errors.Is(err, store.ErrNotFound) {
+		h.metrics.notFound.Inc()
+		http.NotFound(w, r)
+		return store.Link{}, false
+	}
+	if err != nil {
+		h.serverError(w, r, "look up code", err)
+		return store.Link{}, false
+	}
+
+	// The reaper purges expired links periodically, so until it runs we
+	// may still find one here. 410 Gone tells clients it existed once.
+	if link.Expired(time.Now()) {
+		http.Error(w, "This link has expired", http.StatusGone)
+		return store.Link{}, false
+	}
+	return link, true
+}
+
+// linkStats serves GET /api/links/{code}/stats: click totals, unique
+// visitors, hourly and daily buckets, and the top referrers for one link.
+// Like the rest of /api/links, it answers only the link's owner and the admin.
+func (h *Handler) linkStats(w http.ResponseWriter, r *http.Request, c caller) {
+	code, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/links/"), "/stats")
+	if !ok || code == "" || strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link, err := h.store.Get(code)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !c.canManage(link)) {
 		http.NotFound(w, r)
 		return
 	}
+	if err != nil {
+		h.serverError(w, r, "look up code", err)
+		return
+	}
 
-	h.logger.Printf("Redirecting code '%s' to '%s'", code, originalURL)
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	h.respondWithJSON(w, http.StatusOK, h.clicks.Stats(code))
 }
 
 // respondWithJSON is a helper to write JSON responses.
@@ -125,3 +614,10 @@ func (h *Handler) respondWithJSON(w http.ResponseWriter, status int, payload int
 	w.WriteHeader(status)
 	w.Write(response)
 }
+
+// serverError logs an unexpected failure and sends a generic 500 response.
+// The details stay in the log; clients don't need to see our internals.
+func (h *Handler) serverError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	h.logger.ErrorContext(r.Context(), "Failed to "+action, "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
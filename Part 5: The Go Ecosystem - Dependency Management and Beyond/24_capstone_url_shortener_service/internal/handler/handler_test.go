@@ -0,0 +1,218 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/analytics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/apikey"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/handler"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/shortener"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+These tests drive the handlers the way the server does, through
+net/http/httptest: a request goes in, a ResponseRecorder captures the status,
+headers and body that come out. No network and no real server are involved,
+so they are as fast as plain function calls.
+*/
+
+// adminToken is the admin token of the handlers built by newTestHandler.
+const adminToken = "test-admin-token"
+
+// newTestHandler returns a handler over an empty in-memory store, which lets
+// anonymous clients create links, and the store itself. Tests may change the
+// options before the handler is built.
+func newTestHandler(t *testing.T, configure ...func(*handler.Options)) (*handler.Handler, *store.URLStore) {
+	t.Helper()
+	h, s, _ := newKeyedHandler(t, configure...)
+	return h, s
+}
+
+// newKeyedHandler is newTestHandler for tests that need API keys: it also
+// returns the handler's keyring, which starts out empty.
+func newKeyedHandler(t *testing.T, configure ...func(*handler.Options)) (*handler.Handler, *store.URLStore, *apikey.Keyring) {
+	t.Helper()
+	keys, err := apikey.Open(filepath.Join(t.TempDir(), "apikeys.json"))
+	if err != nil {
+		t.Fatalf("apikey.Open() error: %v", err)
+	}
+	opts := handler.Options{
+		BaseURL:        "https://sho.rt",
+		AdminToken:     adminToken,
+		AllowAnonymous: true,
+	}
+	for _, f := range configure {
+		f(&opts)
+	}
+	s := store.NewURLStore()
+	clicks := analytics.NewTracker(64)
+	t.Cleanup(clicks.Close)
+	h := handler.NewHandler(slog.New(slog.DiscardHandler), s, shortener.NewRandomGenerator(6), clicks, keys, opts)
+	return h, s, keys
+}
+
+// do sends a request to a handler and returns the recorded response. A
+// non-empty token is sent as a bearer token.
+func do(h http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// shorten posts a JSON body to /api/shorten and decodes the response. The
+// response is only decoded if the link was created or found.
+func shorten(t *testing.T, h *handler.Handler, body string) (int, handler.ShortenURLResponse) {
+	t.Helper()
+	w := do(h.ShortenURLHandler, http.MethodPost, "/api/shorten", body, "")
+	var resp handler.ShortenURLResponse
+	if w.Code == http.StatusOK || w.Code == http.StatusCreated {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding response %q: %v", w.Body, err)
+		}
+	}
+	return w.Code, resp
+}
+
+// TestShortenAlias walks through the life of a custom alias. The steps run
+// in order, each on the store left by the ones before.
+func TestShortenAlias(t *testing.T) {
+	h, _ := newTestHandler(t)
+	steps := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"new alias", `{"url": "https://go.dev", "alias": "launch-2026"}`, http.StatusCreated},
+		{"same alias and URL again", `{"url": "https://go.dev", "alias": "launch-2026"}`, http.StatusOK},
+		{"same alias, other URL", `{"url": "https://pkg.go.dev", "alias": "launch-2026"}`, http.StatusConflict},
+		{"same alias, other settings", `{"url": "https://go.dev", "alias": "launch-2026", "redirect_status": 301}`, http.StatusConflict},
+		{"reserved word", `{"url": "https://go.dev", "alias": "api"}`, http.StatusBadRequest},
+		{"reserved word in capitals", `{"url": "https://go.dev", "alias": "Metrics"}`, http.StatusBadRequest},
+		{"too short", `{"url": "https://go.dev", "alias": "ab"}`, http.StatusBadRequest},
+		{"not URL-safe", `{"url": "https://go.dev", "alias": "launch 2026"}`, http.StatusBadRequest},
+		{"second alias for the URL", `{"url": "https://go.dev", "alias": "go-home"}`, http.StatusCreated},
+	}
+	for _, step := range steps {
+		if got, _ := shorten(t, h, step.body); got != step.want {
+			t.Errorf("%s: status = %d; want %d", step.name, got, step.want)
+		}
+	}
+
+	w := do(h.RedirectHandler, http.MethodGet, "/launch-2026", "", "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://go.dev/" {
+		t.Errorf("GET /launch-2026 = %d to %q; want 302 to https://go.dev/", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestShortenExpiry(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"ttl", `{"url": "https://go.dev", "ttl_seconds": 3600}`, http.StatusCreated},
+		{"expires_at", `{"url": "https://go.dev", "expires_at": "2999-01-01T00:00:00Z"}`, http.StatusCreated},
+		{"expires_at in the past", `{"url": "https://go.dev", "expires_at": "2001-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"negative ttl", `{"url": "https://go.dev", "ttl_seconds": -1}`, http.StatusBadRequest},
+		{"both", `{"url": "https://go.dev", "ttl_seconds": 60, "expires_at": "2999-01-01T00:00:00Z"}`, http.StatusBadRequest},
+	}
+	codes := make(map[string]bool)
+	for _, tt := range tests {
+		got, resp := shorten(t, h, tt.body)
+		if got != tt.want {
+			t.Errorf("%s: status = %d; want %d", tt.name, got, tt.want)
+			continue
+		}
+		if got == http.StatusCreated {
+			if resp.ExpiresAt == nil {
+				t.Errorf("%s: response has no expires_at", tt.name)
+			}
+			codes[resp.ShortURL] = true
+		}
+	}
+	// Every request for an expiring link gets a code of its own.
+	if len(codes) != 2 {
+		t.Errorf("expiring links got %d distinct codes; want 2", len(codes))
+	}
+}
+
+// TestRedirectExpired checks that a link which expired, but has not been
+// purged by the reaper yet, answers 410 Gone rather than redirecting.
+func TestRedirectExpired(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Set(store.Link{Code: "old-link", URL: "https://go.dev/", ExpiresAt: time.Now().Add(-time.Minute)})
+	s.Set(store.Link{Code: "new-link", URL: "https://go.dev/", ExpiresAt: time.Now().Add(time.Hour)})
+
+	for target, want := range map[string]int{
+		"/old-link":  http.StatusGone,
+		"/old-link+": http.StatusGone,
+		"/new-link":  http.StatusFound,
+		"/no-link":   http.StatusNotFound,
+	} {
+		if w := do(h.RedirectHandler, http.MethodGet, target, "", ""); w.Code != want {
+			t.Errorf("GET %s = %d; want %d", target, w.Code, want)
+		}
+	}
+}
+
+// TestShortenIdempotentAfterCustomLink checks that a URL first shortened with
+// custom settings still gets one code for every plain request after it.
+func TestShortenIdempotentAfterCustomLink(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if got, _ := shorten(t, h, `{"url": "https://go.dev", "interstitial_seconds": 5}`); got != http.StatusCreated {
+		t.Fatalf("custom link: status = %d; want 201", got)
+	}
+
+	codes := make(map[string]bool)
+	for i, want := range []int{http.StatusCreated, http.StatusOK, http.StatusOK} {
+		got, resp := shorten(t, h, `{"url": "https://go.dev"}`)
+		if got != want {
+			t.Errorf("plain request %d: status = %d; want %d", i+1, got, want)
+		}
+		codes[resp.ShortURL] = true
+	}
+	if len(codes) != 1 {
+		t.Errorf("plain requests got %d distinct codes; want 1", len(codes))
+	}
+}
+
+// TestShortenProtectedLink checks that a password-protected link is never
+// handed out for a request without the password, nor shared between requests
+// with one.
+func TestShortenProtectedLink(t *testing.T) {
+	h, _ := newTestHandler(t)
+	steps := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"protected", `{"url": "https://go.dev", "password": "correct horse"}`, http.StatusCreated},
+		{"plain", `{"url": "https://go.dev"}`, http.StatusCreated},
+		{"plain again", `{"url": "https://go.dev"}`, http.StatusOK},
+		{"protected again", `{"url": "https://go.dev", "password": "correct horse"}`, http.StatusCreated},
+	}
+	codes := make(map[string]bool)
+	for _, step := range steps {
+		got, resp := shorten(t, h, step.body)
+		if got != step.want {
+			t.Errorf("%s: status = %d; want %d", step.name, got, step.want)
+		}
+		codes[resp.ShortURL] = true
+	}
+	if len(codes) != 3 {
+		t.Errorf("got %d distinct codes; want 3: one plain, and one for each protected request", len(codes))
+	}
+}
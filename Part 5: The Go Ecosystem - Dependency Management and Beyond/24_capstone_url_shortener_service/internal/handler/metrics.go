@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"log/slog"
+	"math"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/metrics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+// handlerMetrics are the business metrics of the shortener. Request counts
+// and latencies are measured for every route by metrics.Instrument in main;
+// these count what the requests achieved.
+type handlerMetrics struct {
+	linksCreated *metrics.Counter
+	redirects    *metrics.Counter
+	notFound     *metrics.Counter
+}
+
+// newHandlerMetrics registers the handler's metrics. With a nil registry they
+// are kept in a private one that is never scraped, so the handlers can always
+// count without checking whether metrics are enabled.
+func newHandlerMetrics(reg *metrics.Registry, s store.Store, logger *slog.Logger) handlerMetrics {
+	if reg == nil {
+		return handlerMetrics{
+			linksCreated: new(metrics.Counter),
+			redirects:    new(metrics.Counter),
+			notFound:     new(metrics.Counter),
+		}
+	}
+	reg.NewGaugeFunc("urlshortener_links", "Links in the store, including expired ones not yet purged.", func() float64 {
+		n, err := s.Count()
+		if err != nil {
+			logger.Error("Failed to count links for metrics", "error", err)
+			return math.NaN()
+		}
+		return float64(n)
+	})
+	return handlerMetrics{
+		linksCreated: reg.NewCounter("urlshortener_links_created_total", "Short links created."),
+		redirects:    reg.NewCounter("urlshortener_redirects_total", "Clicks on short links that were redirected."),
+		notFound:     reg.NewCounter("urlshortener_not_found_total", "Requests for short codes that don't exist."),
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/password"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+A link created with a `password` is PROTECTED: instead of redirecting, the
+short URL shows a form asking for the password. The form posts back to the
+same URL, and only a correct password leads on to the destination. The
+password itself is never stored, only its hash (see the password package).
+
+Guessing is throttled PER CODE (see PasswordLimiter in Options), not per
+client: an attacker with a thousand IP addresses gets no more tries than one
+with a single address. The price is that while someone is guessing, the real
+visitors of that link may be asked to wait a little too.
+
+After a correct password the visitor gets an UNLOCK COOKIE, so that they
+don't have to type it again on every click for the next few minutes. The
+cookie holds its own expiry time and an HMAC signature:
+
+	unlock_abc123=1767225600.<HMAC-SHA256 of code, expiry and password hash>
+
+Only the server knows the signing key, so a visitor can neither forge a
+cookie nor extend one. Because the password hash is part of what is signed,
+changing a link's password locks out everyone who unlocked the old one.
+*/
+
+const (
+	// MinPasswordLength and MaxPasswordLength bound link passwords, in characters.
+	MinPasswordLength = 4
+	MaxPasswordLength = 128
+
+	// unlockDuration is how long an unlock cookie lasts.
+	unlockDuration = 10 * time.Minute
+	// unlockCookiePrefix is followed by the code in the unlock cookie's name.
+	unlockCookiePrefix = "unlock_"
+)
+
+// validatePassword checks a password that is about to be set on a link.
+func validatePassword(pw string) error {
+	if n := utf8.RuneCountInString(pw); n < MinPasswordLength || n > MaxPasswordLength {
+		return fmt.Errorf("password must be between %d and %d characters", MinPasswordLength, MaxPasswordLength)
+	}
+	return nil
+}
+
+// hashPassword validates a new link password and returns its hash.
+func hashPassword(pw string) (string, error) {
+	if err := validatePassword(pw); err != nil {
+		return "", &requestError{http.StatusBadRequest, err.Error()}
+	}
+	return password.Hash(pw)
+}
+
+// passwordPage is the data for passwordTemplate.
+type passwordPage struct {
+	ShortURL string
+	// Message explains why the password is being asked for again, if it is.
+	Message string
+}
+
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.error { color: #b3261e; }
+input, button { font: inherit; padding: .5rem; }
+button { padding: .5rem 1rem; background: #0b57d0; color: #fff; border: 0; border-radius: .25rem; }
+</style>
+</head>
+<body>
+<h1>This link is protected</h1>
+<p>Enter the password to continue to the destination of {{.ShortURL}}.</p>
+{{with .Message}}<p class="error">{{.}}</p>{{end}}
+<form method="post">
+<input type="password" name="password" aria-label="Password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// requirePassword lets requests for an unprotected link, or one the visitor
+// has unlocked, through. Otherwise it shows the password form and returns false.
+func (h *Handler) requirePassword(w http.ResponseWriter, r *http.Request, link store.Link) bool {
+	if link.PasswordHash == "" || h.unlocked(r, link) {
+		return true
+	}
+	h.renderPasswordForm(w, r, link, http.StatusOK, "")
+	return false
+}
+
+// unlock handles a submitted password form. path is the code, with the "+"
+// of a preview page if the form was shown on one. On success the visitor gets
+// the unlock cookie and is sent back to the same URL with a GET (303 See
+// Other), which now leads on to the destination.
+func (h *Handler) unlock(w http.ResponseWriter, r *http.Request, path string) {
+	code := strings.TrimSuffix(path, "+")
+	link, ok := h.activeLink(w, r, code)
+	if !ok {
+		return
+	}
+	if link.PasswordHash == "" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.passwordLimiter != nil {
+		d := h.passwordLimiter.Allow(code)
+		if !d.Allowed {
+			w.Header().Set("Retry-After", seconds(d.RetryAfter))
+			h.renderPasswordForm(w, r, link, http.StatusTooManyRequests,
+				"Too many attempts. Please wait "+seconds(d.RetryAfter)+" seconds and try again.")
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+	ok, err := password.Verify(r.PostFormValue("password"), link.PasswordHash)
+	if err != nil {
+		h.serverError(w, r, "check link password", err)
+		return
+	}
+	if !ok {
+		h.logger.WarnContext(r.Context(), "Wrong link password", "code", code, "client_ip", h.clientIP(r))
+		h.renderPasswordForm(w, r, link, http.StatusForbidden, "Wrong password. Please try again.")
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "Unlocked link", "code", code)
+	http.SetCookie(w, h.unlockCookie(link, time.Now().Add(unlockDuration)))
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
+
+func (h *Handler) renderPasswordForm(w http.ResponseWriter, r *http.Request, link store.Link, status int, message string) {
+	var buf bytes.Buffer
+	page := passwordPage{ShortURL: h.baseURL + "/" + link.Code, Message: message}
+	if err := passwordTemplate.Execute(&buf, page); err != nil {
+		h.serverError(w, r, "render password form", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// unlockCookie returns the cookie that unlocks link until expires.
+func (h *Handler) unlockCookie(link store.Link, expires time.Time) *http.Cookie {
+	value := strconv.FormatInt(expires.Unix(), 10)
+	return &http.Cookie{
+		Name:     unlockCookiePrefix + link.Code,
+		Value:    value + "." + base64.RawURLEncoding.EncodeToString(h.unlockSignature(link, value)),
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// unlocked reports whether the request carries a valid, unexpired unlock
+// cookie for link.
+func (h *Handler) unlocked(r *http.Request, link store.Link) bool {
+	cookie, err := r.Cookie(unlockCookiePrefix + link.Code)
+	if err != nil {
+		return false
+	}
+	value, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, h.unlockSignature(link, value)) {
+		return false
+	}
+	expires, err := strconv.ParseInt(value, 10, 64)
+	return err == nil && time.Now().Unix() < expires
+}
+
+// unlockSignature signs the expiry time of an unlock cookie for link.
+func (h *Handler) unlockSignature(link store.Link, expires string) []byte {
+	mac := hmac.New(sha256.New, h.cookieSecret)
+	// The NUL bytes keep the fields apart: without them, code "ab" with
+	// expiry "12" would sign the same bytes as code "ab1" with expiry "2".
+	mac.Write([]byte(link.Code + "\x00" + expires + "\x00" + link.PasswordHash))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+A short link hides where it goes, so visitors can ask before they follow one:
+adding a `+` to a code (`/abc123+`) shows a PREVIEW page with the destination,
+when the link was created and how often it has been clicked, instead of
+redirecting.
+
+Link owners can also make a link ALWAYS show such a page (an INTERSTITIAL),
+by setting `interstitial_seconds`. The page then counts down and redirects by
+itself; visitors who don't want to wait can click through right away.
+
+The page is rendered with html/template rather than by gluing strings together.
+html/template knows it is writing HTML: every value is escaped for the place it
+appears in, so a destination like `https://x/"><script>...` shows up as text,
+and a `javascript:` URL in an href is replaced with a harmless "#ZgotmplZ".
+
+The countdown needs no JavaScript: the `Refresh` response header (understood by
+every browser) tells the browser to load the destination after N seconds.
+
+The preview of a split link lists all of its variants; continuing from there
+goes through the short link, which picks one. An interstitial shows only the
+variant the click was given.
+*/
+
+// MaxInterstitialSeconds is the longest countdown an interstitial page may have.
+const MaxInterstitialSeconds = 60
+
+// validateInterstitial checks the interstitial_seconds setting of a link.
+func validateInterstitial(seconds int) error {
+	if seconds < 0 || seconds > MaxInterstitialSeconds {
+		return fmt.Errorf("interstitial_seconds must be between 0 and %d", MaxInterstitialSeconds)
+	}
+	return nil
+}
+
+// previewPage is the data for previewTemplate.
+type previewPage struct {
+	Link     store.Link
+	ShortURL string
+	// Clicks is nil for a link owned by an API key, whose stats are private.
+	Clicks *int
+	// Countdown is the number of seconds until the redirect; 0 on a plain preview.
+	Countdown int
+}
+
+// previewTemplate is parsed once at startup. template.Must panics if it has a
+// syntax error, which turns a typo into a crash at startup instead of a broken page.
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{if .Countdown}}Redirecting…{{else}}Link preview{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.dest { font-size: 1.2rem; word-break: break-all; }
+dl { display: grid; grid-template-columns: max-content 1fr; gap: .25rem 1rem; }
+dt { color: #666; }
+.button { display: inline-block; margin-top: 1rem; padding: .5rem 1rem; background: #0b57d0; color: #fff; text-decoration: none; border-radius: .25rem; }
+</style>
+</head>
+<body>
+<h1>{{if .Countdown}}You are leaving for another site{{else}}Where this link goes{{end}}</h1>
+{{if .Link.Variants}}<p>This link is split between {{len .Link.Variants}} destinations:</p>
+<ul>{{range .Link.Variants}}<li class="dest"><a href="{{.URL}}" rel="noopener noreferrer">{{.URL}}</a></li>{{end}}</ul>
+{{else}}<p class="dest"><a href="{{.Link.URL}}" rel="noopener noreferrer">{{.Link.URL}}</a></p>
+{{end}}<dl>
+<dt>Short link</dt><dd>{{.ShortURL}}</dd>
+{{with .Link.CreatedAt}}{{if not .IsZero}}<dt>Created</dt><dd><time datetime="{{.Format "2006-01-02T15:04:05Z07:00"}}">{{.Format "January 2, 2006"}}</time></dd>{{end}}{{end}}
+{{with .Clicks}}<dt>Clicks</dt><dd>{{.}}</dd>{{end}}
+</dl>
+{{if .Countdown}}<p>You will be redirected in {{.Countdown}} second{{if ne .Countdown 1}}s{{end}}.</p>{{end}}
+<a class="button" href="{{if .Link.Variants}}{{.ShortURL}}{{else}}{{.Link.URL}}{{end}}" rel="noopener noreferrer">Continue to the site</a>
+</body>
+</html>
+`))
+
+// servePreview answers GET /{code}+ with a page describing the link.
+// Looking at a preview is not a click, so it isn't recorded.
+func (h *Handler) servePreview(w http.ResponseWriter, r *http.Request, code string) {
+	// The preview shows the destination, so it is protected like the link.
+	link, ok := h.activeLink(w, r, code)
+	if !ok || !h.requirePassword(w, r, link) {
+		return
+	}
+	h.renderPreview(w, r, link, 0)
+}
+
+// serveInterstitial answers a click on an interstitial link: the preview page,
+// with a countdown after which the browser goes on to target.
+func (h *Handler) serveInterstitial(w http.ResponseWriter, r *http.Request, link store.Link, target string) {
+	h.logger.InfoContext(r.Context(), "Showing interstitial", "code", link.Code, "url", target)
+	link.URL = target
+	link.Variants = nil
+	w.Header().Set("Refresh", strconv.Itoa(link.InterstitialSeconds)+"; url="+target)
+	h.renderPreview(w, r, link, link.InterstitialSeconds)
+}
+
+func (h *Handler) renderPreview(w http.ResponseWriter, r *http.Request, link store.Link, countdown int) {
+	page := previewPage{
+		Link:      link,
+		ShortURL:  h.baseURL + "/" + link.Code,
+		Countdown: countdown,
+	}
+	if link.Owner == "" {
+		clicks := h.clicks.Stats(link.Code).TotalClicks
+		page.Clicks = &clicks
+	}
+	page.Link.CreatedAt = page.Link.CreatedAt.In(time.UTC)
+
+	// Render into a buffer first: if the template fails halfway, we can still
+	// send a clean 500 instead of half a page.
+	var buf bytes.Buffer
+	if err := previewTemplate.Execute(&buf, page); err != nil {
+		w.Header().Del("Refresh")
+		h.serverError(w, r, "render preview", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The click count changes, and the owner may change the link at any time.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/qrcode"
+)
+
+/*
+Every short link also has a QR code, so it can be printed on a poster or shown
+on a slide:
+
+	GET /{code}.png   a PNG image
+	GET /{code}.svg   an SVG image, which stays sharp at any size
+
+Both take optional query parameters:
+
+	size    width in pixels, 64 to 2048 (default 256)
+	margin  the light border in modules, 0 to 16 (default 4, what the standard asks for)
+	ecc     error-correction level L, M, Q or H (default M); higher survives more damage
+
+The QR code holds the short URL, not the destination, so scanning it goes
+through the redirect (and is counted) like any other click, and editing the
+link later doesn't invalidate printed codes. Short codes never contain a dot,
+so "/abc123.png" can't be mistaken for a code.
+*/
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+	maxQRMargin   = 16
+)
+
+// qrOptions are the query parameters of a QR code request.
+type qrOptions struct {
+	size   int
+	margin int
+	level  qrcode.Level
+}
+
+func parseQROptions(r *http.Request) (qrOptions, error) {
+	opts := qrOptions{size: defaultQRSize, margin: qrcode.QuietZone, level: qrcode.Medium}
+	q := r.URL.Query()
+	if s := q.Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			return opts, fmt.Errorf("size must be a number of pixels between %d and %d", minQRSize, maxQRSize)
+		}
+		opts.size = n
+	}
+	if s := q.Get("margin"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxQRMargin {
+			return opts, fmt.Errorf("margin must be a number of modules between 0 and %d", maxQRMargin)
+		}
+		opts.margin = n
+	}
+	if s := q.Get("ecc"); s != "" {
+		level, err := qrcode.ParseLevel(s)
+		if err != nil {
+			return opts, errors.New("ecc must be one of L, M, Q or H")
+		}
+		opts.level = level
+	}
+	return opts, nil
+}
+
+// serveQRCode answers GET /{code}.png and GET /{code}.svg.
+func (h *Handler) serveQRCode(w http.ResponseWriter, r *http.Request, code, format string) {
+	if format != "png" && format != "svg" {
+		http.NotFound(w, r)
+		return
+	}
+	opts, err := parseQROptions(r)
+	if err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, ok := h.activeLink(w, r, code)
+	if !ok {
+		return
+	}
+
+	qr, err := qrcode.Encode([]byte(h.baseURL+"/"+link.Code), opts.level)
+	if err != nil {
+		// Only possible with an absurdly long base URL.
+		h.serverError(w, r, "encode QR code", err)
+		return
+	}
+
+	// Render into a buffer first, so a failure can still become a clean 500.
+	var buf bytes.Buffer
+	if format == "png" {
+		w.Header().Set("Content-Type", "image/png")
+		err = qr.WritePNG(&buf, opts.size, opts.margin)
+	} else {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = qr.WriteSVG(&buf, opts.size, opts.margin)
+	}
+	if err != nil {
+		w.Header().Del("Content-Type")
+		h.serverError(w, r, "render QR code", err)
+		return
+	}
+
+	// The image only depends on the code and the query, so browsers may keep
+	// it for a while; an hour keeps deleted links from lingering too long.
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(buf.Bytes())
+}
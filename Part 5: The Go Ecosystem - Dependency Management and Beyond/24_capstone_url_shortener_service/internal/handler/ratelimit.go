@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
+)
+
+/*
+RateLimit is MIDDLEWARE: a function that takes an http.Handler and returns a
+new one that does some extra work before (or instead of) calling the original.
+Because the result is again an http.Handler, middleware can be stacked, and
+`main` decides which routes get which limits:
+
+	mux.Handle("/api/shorten", h.RateLimit(createLimiter, http.HandlerFunc(h.ShortenURLHandler)))
+
+Every response carries the current state of the client's bucket:
+
+	RateLimit-Limit:     the bucket size
+	RateLimit-Remaining: requests left right now
+	RateLimit-Reset:     seconds until the bucket is full again
+
+and a rejected request gets `429 Too Many Requests` with a `Retry-After` header
+saying how many seconds to wait.
+*/
+
+// RateLimit wraps next so that each client may only send requests as fast as
+// the limiter allows. A nil limiter means no limit.
+func (h *Handler) RateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := limiter.Allow(h.rateLimitKey(r))
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("RateLimit-Reset", seconds(d.Reset))
+		if !d.Allowed {
+			w.Header().Set("Retry-After", seconds(d.RetryAfter))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey decides whose bucket a request draws from. Requests with a
+// valid API key share the key's bucket, wherever they come from; everyone
+// else is limited per IP address. Invalid keys count as no key, otherwise a
+// client could get a fresh bucket just by making up a new key each time.
+func (h *Handler) rateLimitKey(r *http.Request) string {
+	if c, err := h.authenticate(r); err == nil {
+		if c.admin {
+			return "admin"
+		}
+		return "key:" + c.owner
+	}
+	return "ip:" + networkOf(h.clientIP(r))
+}
+
+// networkOf returns the part of an IP address that identifies a client. For
+// IPv4 that is the whole address. An IPv6 client, though, is usually handed
+// a whole /64 network, and can send every request from a new address in it;
+// so all of a /64 counts as one client.
+func networkOf(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	if addr = addr.Unmap(); addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr, 64).Masked().String()
+}
+
+// seconds formats a duration as whole seconds, rounded up, for HTTP headers.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,48 @@
+package handler_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
+)
+
+// TestRateLimitPerNetwork checks that IPv4 clients are limited per address,
+// and IPv6 clients per /64, so that rotating through the addresses of one
+// network doesn't buy a fresh bucket.
+func TestRateLimitPerNetwork(t *testing.T) {
+	h, _ := newTestHandler(t)
+	route := h.RateLimit(ratelimit.New(ratelimit.Limit{Rate: 0, Burst: 3}), http.NotFoundHandler())
+	get := func(ip string) int {
+		r := httptest.NewRequest(http.MethodGet, "/nope", nil)
+		r.RemoteAddr = net.JoinHostPort(ip, "1234")
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := range 3 {
+		if got := get(fmt.Sprintf("2001:db8:1:2::%x", i+1)); got == http.StatusTooManyRequests {
+			t.Fatalf("request %d from the /64 was limited", i+1)
+		}
+	}
+	if got := get("2001:db8:1:2:ffff:ffff:ffff:ffff"); got != http.StatusTooManyRequests {
+		t.Errorf("fourth address in the same /64 = %d; want 429", got)
+	}
+	if got := get("2001:db8:1:3::1"); got == http.StatusTooManyRequests {
+		t.Errorf("address in the next /64 was limited")
+	}
+
+	for range 3 {
+		get("192.0.2.1")
+	}
+	if got := get("192.0.2.2"); got == http.StatusTooManyRequests {
+		t.Errorf("neighbouring IPv4 address was limited; want a bucket of its own")
+	}
+	if got := get("::ffff:192.0.2.1"); got != http.StatusTooManyRequests {
+		t.Errorf("IPv4-mapped address = %d; want 429 from the bucket of 192.0.2.1", got)
+	}
+}
@@ -0,0 +1,59 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+// TestRedirectTarget checks where each combination of redirect settings
+// sends a click, and in particular which source wins when the visitor's
+// query, the destination's query and the UTM template set the same parameter.
+func TestRedirectTarget(t *testing.T) {
+	h, s := newTestHandler(t)
+	const template = "utm_source=mail&utm_campaign={code}&utm_medium={referrer}"
+	for _, link := range []store.Link{
+		{Code: "plain", URL: "https://go.dev/doc?lang=en"},
+		{Code: "fwd", URL: "https://go.dev/doc?lang=en", ForwardQuery: true},
+		{Code: "utm", URL: "https://go.dev/doc?utm_source=old&x=1", UTMTemplate: template},
+		{Code: "both", URL: "https://go.dev/doc?lang=en", ForwardQuery: true, UTMTemplate: "utm_source=mail"},
+		{Code: "moved", URL: "https://go.dev/", RedirectStatus: http.StatusMovedPermanently},
+		{Code: "perm", URL: "https://go.dev/", RedirectStatus: http.StatusPermanentRedirect, ForwardQuery: true},
+	} {
+		s.Set(link)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		referrer   string
+		wantStatus int
+		want       string
+	}{
+		{"no settings ignore the query", "/plain?ref=mail", "", http.StatusFound, "https://go.dev/doc?lang=en"},
+		{"forwarded query", "/fwd?ref=mail", "", http.StatusFound, "https://go.dev/doc?lang=en&ref=mail"},
+		{"destination beats visitor", "/fwd?lang=fr&ref=mail", "", http.StatusFound, "https://go.dev/doc?lang=en&ref=mail"},
+		{"nothing to forward", "/fwd", "", http.StatusFound, "https://go.dev/doc?lang=en"},
+		{"template beats destination", "/utm", "https://news.example/item?id=1", http.StatusFound,
+			"https://go.dev/doc?utm_campaign=utm&utm_medium=news.example&utm_source=mail&x=1"},
+		{"direct visit", "/utm?ref=mail", "", http.StatusFound,
+			"https://go.dev/doc?utm_campaign=utm&utm_medium=direct&utm_source=mail&x=1"},
+		{"template beats visitor", "/both?utm_source=evil&ref=mail", "", http.StatusFound,
+			"https://go.dev/doc?lang=en&ref=mail&utm_source=mail"},
+		{"301", "/moved", "", http.StatusMovedPermanently, "https://go.dev/"},
+		{"308 with forwarding", "/perm?a=1&a=2", "", http.StatusPermanentRedirect, "https://go.dev/?a=1&a=2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.referrer != "" {
+			r.Header.Set("Referer", tt.referrer)
+		}
+		w := httptest.NewRecorder()
+		h.RedirectHandler(w, r)
+		if got := w.Header().Get("Location"); w.Code != tt.wantStatus || got != tt.want {
+			t.Errorf("%s: GET %s = %d to %q; want %d to %q", tt.name, tt.target, w.Code, got, tt.wantStatus, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+A SPLIT LINK sends its visitors to one of several destinations, for A/B
+tests: one short link in the newsletter, half of the readers on landing page
+A and half on B. Instead of a `url`, the link is created with `variants`:
+
+	{"variants": [
+	    {"name": "A", "url": "https://example.com/landing-a", "weight": 1},
+	    {"name": "B", "url": "https://example.com/landing-b", "weight": 1}
+	 ],
+	 "sticky": "cookie"}
+
+Each click picks a variant at random, in proportion to the WEIGHTS: 1 and 1
+split the traffic evenly, 9 and 1 send 90% to the first. Names are optional
+and default to A, B, C, ...
+
+Picking afresh on every click means a visitor who comes back may land on the
+other page, which muddles the test. `sticky` keeps them on one variant:
+
+  - "cookie" remembers the variant in a cookie (variant_<code>) for 30 days.
+    Precise, but only for the browser that got the cookie.
+  - "ip" derives the variant from a hash of the link's code and the client IP,
+    so there is nothing to store: the same address always gets the same
+    variant, in any browser, until the variants change. Visitors behind one
+    NAT share a variant, though.
+
+Every click is recorded with the name of its variant, and the stats endpoint
+counts clicks and unique visitors per variant (see the analytics package).
+
+A split link must redirect with 302 or 307: browsers cache a 301 or 308 and
+would stop asking us, so they would stick with their first variant for good
+and their clicks would go uncounted.
+*/
+
+const (
+	// StickyCookie and StickyIP are the values of a split link's sticky setting.
+	StickyCookie = "cookie"
+	StickyIP     = "ip"
+
+	// MaxVariants is the most destinations a link may be split between, and
+	// MaxVariantWeight the highest weight one of them may have.
+	MaxVariants      = 10
+	MaxVariantWeight = 1000
+
+	// variantCookiePrefix is followed by the code in the variant cookie's name.
+	variantCookiePrefix = "variant_"
+	// variantCookieDuration is how long a visitor keeps their variant with
+	// sticky "cookie".
+	variantCookieDuration = 30 * 24 * time.Hour
+)
+
+// splitDestinations canonicalizes and checks the URLs of the requested
+// variants like any other destination, and names the variants that have no
+// name. Errors are returned like those of destination.
+func (h *Handler) splitDestinations(ctx context.Context, variants []store.Variant) ([]store.Variant, error) {
+	if len(variants) < 2 || len(variants) > MaxVariants {
+		return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("A split link needs between 2 and %d variants", MaxVariants)}
+	}
+	out := make([]store.Variant, len(variants))
+	for i, v := range variants {
+		destination, err := h.destination(ctx, v.URL)
+		if err != nil {
+			return nil, err
+		}
+		if v.Name == "" {
+			v.Name = string(rune('A' + i))
+		}
+		v.URL = destination
+		out[i] = v
+	}
+	return out, nil
+}
+
+// validateSplit checks the variants and sticky setting of a link.
+func validateSplit(link store.Link) error {
+	if len(link.Variants) == 0 {
+		if link.Sticky != "" {
+			return errors.New("sticky needs variants to choose between")
+		}
+		return nil
+	}
+	switch link.Sticky {
+	case "", StickyCookie, StickyIP:
+	default:
+		return errors.New("sticky must be cookie or ip")
+	}
+	if s := redirectStatus(link); s != http.StatusFound && s != http.StatusTemporaryRedirect {
+		return errors.New("a split link must redirect with 302 or 307, which browsers don't cache")
+	}
+	seen := make(map[string]bool)
+	for _, v := range link.Variants {
+		if !validVariantName(v.Name) {
+			return fmt.Errorf("variant name %q must be 1 to 32 letters, digits, - or _", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("variant name %q is used twice", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Weight < 1 || v.Weight > MaxVariantWeight {
+			return fmt.Errorf("variant weights must be between 1 and %d", MaxVariantWeight)
+		}
+	}
+	return nil
+}
+
+// validVariantName reports whether name can be a variant name. Names are
+// limited to characters that are safe in a cookie value.
+func validVariantName(name string) bool {
+	if len(name) < 1 || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// chooseVariant picks the variant of a split link that a click goes to, and
+// with sticky "cookie" sets the cookie that keeps it. It returns false for
+// links that aren't split.
+func (h *Handler) chooseVariant(w http.ResponseWriter, r *http.Request, link store.Link) (store.Variant, bool) {
+	if len(link.Variants) == 0 {
+		return store.Variant{}, false
+	}
+	total := 0
+	for _, v := range link.Variants {
+		total += v.Weight
+	}
+
+	switch link.Sticky {
+	case StickyIP:
+		hash := fnv.New64a()
+		hash.Write([]byte(link.Code + "\x00" + h.clientIP(r)))
+		return pickVariant(link.Variants, int(hash.Sum64()%uint64(total))), true
+
+	case StickyCookie:
+		if cookie, err := r.Cookie(variantCookiePrefix + link.Code); err == nil {
+			for _, v := range link.Variants {
+				if v.Name == cookie.Value {
+					return v, true
+				}
+			}
+			// The variant was removed since; the visitor gets a new one.
+		}
+		v := pickVariant(link.Variants, rand.IntN(total))
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookiePrefix + link.Code,
+			Value:    v.Name,
+			Path:     "/",
+			MaxAge:   int(variantCookieDuration.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(h.baseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return v, true
+	}
+	return pickVariant(link.Variants, rand.IntN(total)), true
+}
+
+// pickVariant returns the variant that n, a number below the sum of the
+// weights, falls on. Each variant covers a stretch as long as its weight:
+// with weights 3 and 1, 0 to 2 fall on the first and 3 on the second.
+func pickVariant(variants []store.Variant, n int) store.Variant {
+	for _, v := range variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
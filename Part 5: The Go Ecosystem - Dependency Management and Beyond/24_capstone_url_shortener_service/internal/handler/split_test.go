@@ -0,0 +1,132 @@
+package handler_test
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/handler"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+// splitVariants sends three times as much traffic to A as to B.
+var splitVariants = []store.Variant{
+	{Name: "A", URL: "https://go.dev/a", Weight: 3},
+	{Name: "B", URL: "https://go.dev/b", Weight: 1},
+}
+
+// click follows a short link from the given client IP, with the cookies
+// given, and returns the response.
+func click(h *handler.Handler, code, ip string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/"+code, nil)
+	r.RemoteAddr = ip + ":1234"
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.RedirectHandler(w, r)
+	return w
+}
+
+// TestSplitWeights checks that clicks are shared out in proportion to the
+// weights, both when every click picks afresh and when each visitor (here:
+// each IP address) keeps one variant.
+func TestSplitWeights(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Set(store.Link{Code: "fresh", URL: "https://go.dev/a", Variants: splitVariants})
+	s.Set(store.Link{Code: "by-ip", URL: "https://go.dev/a", Variants: splitVariants, Sticky: handler.StickyIP})
+
+	const clicks = 4000
+	for _, code := range []string{"fresh", "by-ip"} {
+		counts := make(map[string]int)
+		for i := range clicks {
+			ip := fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+			counts[click(h, code, ip).Header().Get("Location")]++
+		}
+		// With 4000 clicks, the share of A misses 75% by more than 3.5
+		// points (five standard deviations) in fewer than one run in a
+		// million.
+		if share := float64(counts["https://go.dev/a"]) / clicks; math.Abs(share-0.75) > 0.035 {
+			t.Errorf("%s: %.1f%% of clicks went to A; want about 75%% (%v)", code, 100*share, counts)
+		}
+		if counts["https://go.dev/a"]+counts["https://go.dev/b"] != clicks {
+			t.Errorf("%s: clicks went to %v; want only the two variants", code, counts)
+		}
+	}
+}
+
+func TestSplitStickyIP(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Set(store.Link{Code: "by-ip", URL: "https://go.dev/a", Variants: splitVariants, Sticky: handler.StickyIP})
+
+	for i := range 20 {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		first := click(h, "by-ip", ip).Header().Get("Location")
+		for range 10 {
+			if again := click(h, "by-ip", ip).Header().Get("Location"); again != first {
+				t.Fatalf("%s went to %s, then to %s; want the same variant every time", ip, first, again)
+			}
+		}
+	}
+}
+
+func TestSplitStickyCookie(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Set(store.Link{Code: "by-cookie", URL: "https://go.dev/a", Variants: splitVariants, Sticky: handler.StickyCookie})
+
+	for i := range 20 {
+		w := click(h, "by-cookie", "192.0.2.1")
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != "variant_by-cookie" {
+			t.Fatalf("first click set cookies %v; want variant_by-cookie", cookies)
+		}
+		first := w.Header().Get("Location")
+		for range 10 {
+			// Another address doesn't matter; the cookie does.
+			w := click(h, "by-cookie", fmt.Sprintf("198.51.100.%d", i), cookies[0])
+			if again := w.Header().Get("Location"); again != first {
+				t.Fatalf("visitor %d went to %s, then to %s; want the same variant every time", i, first, again)
+			}
+		}
+	}
+
+	// A cookie for a variant that no longer exists is replaced.
+	w := click(h, "by-cookie", "192.0.2.1", &http.Cookie{Name: "variant_by-cookie", Value: "C"})
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || (cookies[0].Value != "A" && cookies[0].Value != "B") {
+		t.Errorf("click with a stale cookie set %v; want a cookie for A or B", cookies)
+	}
+}
+
+// TestShortenSplitIsNotIndexed checks that a split link is never handed out
+// for a plain request for its first variant's URL, nor shared between
+// requests for a split.
+func TestShortenSplitIsNotIndexed(t *testing.T) {
+	h, _ := newTestHandler(t)
+	const split = `{"variants": [{"url": "https://go.dev/a", "weight": 1}, {"url": "https://go.dev/b", "weight": 1}]}`
+	steps := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"split", split, http.StatusCreated},
+		{"same split again", split, http.StatusCreated},
+		{"first variant alone", `{"url": "https://go.dev/a"}`, http.StatusCreated},
+		{"first variant again", `{"url": "https://go.dev/a"}`, http.StatusOK},
+	}
+	codes := make(map[string]bool)
+	for _, step := range steps {
+		got, resp := shorten(t, h, step.body)
+		if got != step.want {
+			t.Errorf("%s: status = %d; want %d", step.name, got, step.want)
+		}
+		if step.name == "first variant again" && len(resp.Variants) != 0 {
+			t.Errorf("%s: got split link %s; want the plain one", step.name, resp.ShortURL)
+		}
+		codes[resp.ShortURL] = true
+	}
+	if len(codes) != 3 {
+		t.Errorf("got %d distinct codes; want 3: one for each split, and one plain", len(codes))
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/transfer"
+)
+
+/*
+The admin can move every link in and out of a running service:
+
+	GET  /api/admin/export?format=csv                      download every link
+	POST /api/admin/import?conflict=overwrite&dry_run=true upload links
+
+The files are JSON Lines (the default) or CSV, as described in the transfer
+package, and an export can be imported again as it is. The `export` and
+`import` subcommands do the same without a running server.
+
+An import answers with what it did (or, in a dry run, would do):
+
+	{"read": 3, "created": 2, "unchanged": 0, "overwritten": 0, "skipped": 1, "conflicts": ["abc123"]}
+*/
+
+// maxImportBytes bounds the size of an import request body.
+const maxImportBytes = 256 << 20
+
+// transferTimeout replaces the server's read and write timeouts for exports
+// and imports, which move far more data than any other request, but must
+// still end if the other side stalls.
+const transferTimeout = 10 * time.Minute
+
+// ImportResponse is the body of a POST /api/admin/import response. Error is
+// set when the import was refused because of conflicts.
+type ImportResponse struct {
+	transfer.Result
+	Error string `json:"error,omitempty"`
+}
+
+// ExportHandler serves GET /api/admin/export.
+func (h *Handler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	h.requireAdmin(h.exportLinks)(w, r)
+}
+
+// ImportHandler serves POST /api/admin/import.
+func (h *Handler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	h.requireAdmin(h.importLinks)(w, r)
+}
+
+func (h *Handler) exportLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := transfer.JSONL
+	if s := r.URL.Query().Get("format"); s != "" {
+		var err error
+		if format, err = transfer.ParseFormat(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filename := "links-" + time.Now().UTC().Format("20060102-150405") + "." + string(format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(transferTimeout))
+
+	n, err := transfer.Export(r.Context(), w, h.store, format)
+	if err != nil {
+		// The status line is long gone, so the only way left to tell the
+		// client the file is incomplete is to break off the response.
+		h.logger.ErrorContext(r.Context(), "Failed to export links", "exported", n, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	h.logger.InfoContext(r.Context(), "Exported links", "count", n, "format", format)
+}
+
+func (h *Handler) importLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The format comes from ?format=, or else from the Content-Type.
+	query := r.URL.Query()
+	format := transfer.JSONL
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		format = transfer.CSV
+	}
+	var opts transfer.ImportOptions
+	var err error
+	if s := query.Get("format"); s != "" {
+		if format, err = transfer.ParseFormat(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := query.Get("conflict"); s != "" {
+		if opts.Conflict, err = transfer.ParseConflict(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := query.Get("dry_run"); s != "" {
+		if opts.DryRun, err = strconv.ParseBool(s); err != nil {
+			http.Error(w, "dry_run must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(transferTimeout))
+	rc.SetWriteDeadline(time.Now().Add(transferTimeout))
+
+	res, err := transfer.Import(r.Context(), h.store, body, format, opts)
+	var inputErr *transfer.InputError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, transfer.ErrConflict):
+		h.respondWithJSON(w, http.StatusConflict, ImportResponse{Result: res, Error: err.Error()})
+		return
+	case errors.As(err, &inputErr):
+		http.Error(w, "Invalid import: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Import is larger than "+strconv.Itoa(maxImportBytes>>20)+" MiB", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		h.logger.ErrorContext(r.Context(), "Failed to import links", "created", res.Created, "overwritten", res.Overwritten, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !res.DryRun {
+		h.logger.InfoContext(r.Context(), "Imported links", "read", res.Read, "created", res.Created,
+			"overwritten", res.Overwritten, "skipped", res.Skipped, "format", format)
+		// The imported codes may lie ahead of a counting generator.
+		if err := h.ResumeCodes(r.Context()); err != nil {
+			h.logger.ErrorContext(r.Context(), "Failed to resume code generator", "error", err)
+		}
+	}
+	h.respondWithJSON(w, http.StatusOK, ImportResponse{Result: res})
+}
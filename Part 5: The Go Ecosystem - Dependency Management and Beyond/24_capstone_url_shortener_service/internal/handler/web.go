@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/analytics"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+Besides the JSON API, the service has a small WEB UI for people with a browser:
+
+  - `/`             a form to shorten a URL,
+  - `/links`        the links created in this browser, newest first,
+  - `/links/{code}` one link with its QR code and click stats.
+
+Every page is rendered on the server with html/template, and every form is a
+plain HTML form, so the UI works without JavaScript.
+
+The templates and the stylesheet live in the `web` directory next to this
+file. The `//go:embed` directive below makes the compiler copy them INTO the
+binary, so the service is still a single file to deploy, and a template can't
+go missing at runtime.
+
+The UI has no accounts. "Your links" are remembered in a cookie, which is
+enough to find a link again. So the pages show click stats only for anonymous
+links, which belong to nobody; those of a link owned by an API key are for
+its owner, through GET /api/links/{code}/stats. When anonymous links are
+disabled, the form asks for an API key, which is used for that one request
+and never stored.
+
+The form is protected against CROSS-SITE REQUEST FORGERY: without protection,
+any web page could make its visitors' browsers post to our form, with their
+cookies. Each browser gets a random ID in a cookie, and each form carries a
+token derived from it, HMAC(secret, ID). A POST is only accepted if its token
+matches its cookie. Another site can make the browser send our cookie, but it
+can't read it, so it can't compute the matching token.
+*/
+
+//go:embed web
+var webFiles embed.FS
+
+const (
+	// csrfCookie holds the browser's random ID that CSRF tokens are tied to.
+	csrfCookie = "csrf"
+	// recentCookie lists the codes created in this browser, newest first.
+	recentCookie = "recent_links"
+	// maxRecentLinks is how many codes recentCookie remembers.
+	maxRecentLinks = 20
+	// chartDays is how many days the click chart of the detail page covers.
+	chartDays = 30
+)
+
+// webTemplates holds one template set per page. Each page defines "content"
+// (and optionally "title"), which layout.html fills in.
+var webTemplates = map[string]*template.Template{
+	"home":  parsePage("home.html"),
+	"links": parsePage("links.html"),
+	"link":  parsePage("link.html"),
+}
+
+func parsePage(name string) *template.Template {
+	funcs := template.FuncMap{
+		"date": func(t time.Time) string { return t.UTC().Format("Jan 2, 2006 15:04 UTC") },
+	}
+	return template.Must(template.New(name).Funcs(funcs).ParseFS(webFiles, "web/templates/layout.html", "web/templates/"+name))
+}
+
+// StaticHandler serves the UI's stylesheet under /static/.
+func (h *Handler) StaticHandler() http.Handler {
+	static, err := fs.Sub(webFiles, "web/static")
+	if err != nil {
+		panic(err) // the directory is embedded, so this can't happen
+	}
+	return http.StripPrefix("/static/", http.FileServerFS(static))
+}
+
+// expiryChoice is one option of the form's "Expires" menu.
+type expiryChoice struct {
+	Value string
+	Label string
+	TTL   time.Duration
+}
+
+var expiryChoices = []expiryChoice{
+	{"", "Never", 0},
+	{"1h", "In 1 hour", time.Hour},
+	{"1d", "In 1 day", 24 * time.Hour},
+	{"7d", "In 1 week", 7 * 24 * time.Hour},
+	{"30d", "In 30 days", 30 * 24 * time.Hour},
+}
+
+// homeForm holds what the visitor typed, so the form can be shown again
+// after an error without losing it. The password is never sent back.
+type homeForm struct {
+	URL      string
+	Alias    string
+	Expires  string
+	Advanced bool
+}
+
+// homePage is the data for home.html.
+type homePage struct {
+	Form      homeForm
+	Expiries  []expiryChoice
+	CSRFToken string
+	NeedsKey  bool
+	Error     string
+}
+
+// HomeHandler serves the shortening form on GET / and handles its submission.
+func (h *Handler) HomeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.renderHome(w, r, http.StatusOK, homeForm{}, "")
+	case http.MethodPost:
+		h.submitHome(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// submitHome creates the link asked for by the form, then sends the browser
+// on to the link's page.
+func (h *Handler) submitHome(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	form := homeForm{
+		URL:     strings.TrimSpace(r.PostFormValue("url")),
+		Alias:   strings.TrimSpace(r.PostFormValue("alias")),
+		Expires: r.PostFormValue("expires"),
+	}
+	form.Advanced = form.Alias != "" || form.Expires != "" || r.PostFormValue("password") != ""
+
+	if !h.validCSRF(r, r.PostFormValue("csrf_token")) {
+		h.logger.WarnContext(r.Context(), "Rejected form without a valid CSRF token", "client_ip", h.clientIP(r))
+		h.renderHome(w, r, http.StatusForbidden, form, "Your session has expired. Please submit the form again.")
+		return
+	}
+
+	var owner string
+	if key := r.PostFormValue("api_key"); key != "" {
+		c, err := h.authenticateToken(key)
+		if err != nil {
+			h.renderHome(w, r, http.StatusUnauthorized, form, "That API key is not valid.")
+			return
+		}
+		owner = c.owner
+	} else if !h.allowAnonymous {
+		h.renderHome(w, r, http.StatusUnauthorized, form, "Please enter your API key.")
+		return
+	}
+
+	req := ShortenURLRequest{URL: form.URL, Alias: form.Alias, Password: r.PostFormValue("password")}
+	i := slices.IndexFunc(expiryChoices, func(c expiryChoice) bool { return c.Value == form.Expires })
+	if i < 0 {
+		h.renderHome(w, r, http.StatusBadRequest, form, "Please choose when the link expires.")
+		return
+	}
+	req.TTLSeconds = int64(expiryChoices[i].TTL / time.Second)
+
+	link, _, err := h.shorten(r.Context(), req, owner)
+	var reqErr *requestError
+	var violation *policy.Violation
+	switch {
+	case errors.As(err, &reqErr):
+		h.renderHome(w, r, reqErr.status, form, reqErr.msg)
+		return
+	case errors.As(err, &violation):
+		h.renderHome(w, r, http.StatusUnprocessableEntity, form, refusal(violation).Error)
+		return
+	}
+	if err != nil {
+		h.serverError(w, r, "shorten URL", err)
+		return
+	}
+
+	http.SetCookie(w, h.recentCookie(link.Code, recentCodes(r)))
+	if link.PasswordHash != "" {
+		// The visitor just typed the password, so don't make them type it
+		// again to see their own link.
+		http.SetCookie(w, h.unlockCookie(link, time.Now().Add(unlockDuration)))
+	}
+	http.Redirect(w, r, "/links/"+link.Code, http.StatusSeeOther)
+}
+
+func (h *Handler) renderHome(w http.ResponseWriter, r *http.Request, status int, form homeForm, message string) {
+	page := homePage{
+		Form:      form,
+		Expiries:  expiryChoices,
+		CSRFToken: h.csrfToken(w, r),
+		NeedsKey:  !h.allowAnonymous,
+		Error:     message,
+	}
+	h.renderPage(w, r, "home", status, page)
+}
+
+// recentLink is one row of links.html.
+type recentLink struct {
+	Code      string
+	ShortURL  string
+	URL       string
+	CreatedAt time.Time
+	Clicks    int
+	Protected bool
+	// Private hides the clicks of a link owned by an API key.
+	Private bool
+}
+
+// linkPage is the data for link.html.
+type linkPage struct {
+	Link     store.Link
+	ShortURL string
+	// Protected hides the destination of a password-protected link from
+	// visitors who haven't unlocked it.
+	Protected bool
+	// Private hides the stats of a link owned by an API key.
+	Private bool
+	Stats   analytics.Stats
+	Days    []dayBar
+}
+
+// dayBar is one row of the click chart on link.html.
+type dayBar struct {
+	Label   string
+	Clicks  int
+	Percent int
+}
+
+// WebLinksHandler serves GET /links and GET /links/{code}.
+func (h *Handler) WebLinksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	code := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/links"), "/")
+	if strings.Contains(code, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if code == "" {
+		h.serveRecentLinks(w, r)
+		return
+	}
+
+	link, ok := h.activeLink(w, r, code)
+	if !ok {
+		return
+	}
+	page := linkPage{
+		Link:      link,
+		ShortURL:  h.baseURL + "/" + link.Code,
+		Protected: link.PasswordHash != "" && !h.unlocked(r, link),
+		Private:   link.Owner != "",
+	}
+	if !page.Private {
+		page.Stats = h.clicks.Stats(link.Code)
+		page.Days = dayBars(page.Stats.Daily)
+	}
+	h.renderPage(w, r, "link", http.StatusOK, page)
+}
+
+func (h *Handler) serveRecentLinks(w http.ResponseWriter, r *http.Request) {
+	var links []recentLink
+	for _, code := range recentCodes(r) {
+		link, err := h.store.Get(code)
+		if errors.Is(err, store.ErrNotFound) {
+			continue // deleted, or reaped after it expired
+		}
+		if err != nil {
+			h.serverError(w, r, "look up code", err)
+			return
+		}
+		row := recentLink{
+			Code:      link.Code,
+			ShortURL:  h.baseURL + "/" + link.Code,
+			URL:       link.URL,
+			CreatedAt: link.CreatedAt,
+			Protected: link.PasswordHash != "" && !h.unlocked(r, link),
+			Private:   link.Owner != "",
+		}
+		if !row.Private {
+			row.Clicks = h.clicks.Stats(link.Code).TotalClicks
+		}
+		links = append(links, row)
+	}
+	h.renderPage(w, r, "links", http.StatusOK, struct{ Links []recentLink }{links})
+}
+
+// dayBars turns the daily click buckets into a bar chart of the last
+// chartDays days, newest first. Bars are scaled to the busiest day.
+func dayBars(daily []analytics.Bucket) []dayBar {
+	if len(daily) > chartDays {
+		daily = daily[len(daily)-chartDays:]
+	}
+	most := 0
+	for _, b := range daily {
+		most = max(most, b.Clicks)
+	}
+	bars := make([]dayBar, 0, len(daily))
+	for i := len(daily) - 1; i >= 0; i-- {
+		bar := dayBar{Label: daily[i].Start.UTC().Format("Mon, Jan 2"), Clicks: daily[i].Clicks}
+		if most > 0 {
+			bar.Percent = bar.Clicks * 100 / most
+		}
+		bars = append(bars, bar)
+	}
+	return bars
+}
+
+// renderPage executes one of webTemplates and writes it with status.
+func (h *Handler) renderPage(w http.ResponseWriter, r *http.Request, name string, status int, data any) {
+	var buf bytes.Buffer
+	if err := webTemplates[name].ExecuteTemplate(&buf, "layout.html", data); err != nil {
+		h.serverError(w, r, "render "+name+" page", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// Our pages have no reason to be shown inside another site's frame.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// recentCodes reads the codes remembered in the recent-links cookie.
+func recentCodes(r *http.Request) []string {
+	cookie, err := r.Cookie(recentCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	// Codes never contain a ".", so it separates them.
+	return strings.Split(cookie.Value, ".")
+}
+
+// recentCookie returns the recent-links cookie with code added in front of
+// codes. A code that is already listed moves to the front.
+func (h *Handler) recentCookie(code string, codes []string) *http.Cookie {
+	codes = slices.DeleteFunc(codes, func(c string) bool { return c == code })
+	codes = append([]string{code}, codes...)
+	if len(codes) > maxRecentLinks {
+		codes = codes[:maxRecentLinks]
+	}
+	return &http.Cookie{
+		Name:     recentCookie,
+		Value:    strings.Join(codes, "."),
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// csrfToken returns the CSRF token for the request's browser, giving the
+// browser an ID cookie first if it has none.
+func (h *Handler) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return h.csrfSignature(cookie.Value)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	value := base64.RawURLEncoding.EncodeToString(id)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return h.csrfSignature(value)
+}
+
+// validCSRF reports whether token belongs to the request's ID cookie.
+func (h *Handler) validCSRF(r *http.Request, token string) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(h.csrfSignature(cookie.Value)))
+}
+
+// csrfSignature derives the CSRF token for a browser ID. The "csrf" prefix
+// keeps it from ever matching an unlock signature made with the same secret.
+func (h *Handler) csrfSignature(id string) string {
+	mac := hmac.New(sha256.New, h.cookieSecret)
+	mac.Write([]byte("csrf\x00" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/handler"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+// tokenField finds the CSRF token in the form of the home page.
+var tokenField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// browser is what a browser holds after loading the home page: the ID
+// cookie, and the CSRF token the page's form will send back.
+type browser struct {
+	cookie *http.Cookie
+	token  string
+}
+
+// visitHome loads the home page the way a new browser does.
+func visitHome(t *testing.T, h *handler.Handler) browser {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HomeHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var b browser
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "csrf" {
+			b.cookie = c
+		}
+	}
+	m := tokenField.FindStringSubmatch(w.Body.String())
+	if b.cookie == nil || m == nil {
+		t.Fatalf("GET / gave no CSRF cookie or token")
+	}
+	b.token = m[1]
+	return b
+}
+
+// TestHomeFormCSRF checks that the form only works when it sends back the
+// token that belongs to the browser's own cookie.
+func TestHomeFormCSRF(t *testing.T) {
+	h, s := newTestHandler(t, func(o *handler.Options) { o.CookieSecret = []byte("a secret of thirty-two characters") })
+	other, _ := newTestHandler(t, func(o *handler.Options) { o.CookieSecret = []byte("another secret, thirty-two chars") })
+	mine, theirs, foreign := visitHome(t, h), visitHome(t, h), visitHome(t, other)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+		want   int
+	}{
+		{"no cookie, no token", nil, "", http.StatusForbidden},
+		{"cookie, no token", mine.cookie, "", http.StatusForbidden},
+		{"token, no cookie", nil, mine.token, http.StatusForbidden},
+		{"another browser's token", mine.cookie, theirs.token, http.StatusForbidden},
+		{"another server's token", foreign.cookie, foreign.token, http.StatusForbidden},
+		{"made-up token", mine.cookie, "AAAA", http.StatusForbidden},
+		{"matching cookie and token", mine.cookie, mine.token, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		form := url.Values{"url": {"https://go.dev"}, "expires": {""}}
+		if tt.token != "" {
+			form.Set("csrf_token", tt.token)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie != nil {
+			r.AddCookie(tt.cookie)
+		}
+		w := httptest.NewRecorder()
+		h.HomeHandler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: POST / = %d; want %d", tt.name, w.Code, tt.want)
+		}
+	}
+	if n, _ := s.Count(); n != 1 {
+		t.Errorf("store holds %d links; want only the one from the valid form", n)
+	}
+}
+
+// TestPagesHidePrivateStats checks that the web pages show click stats for
+// anonymous links only: those of a key's links are for the key's owner.
+func TestPagesHidePrivateStats(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Set(store.Link{Code: "anon", URL: "https://go.dev/"})
+	s.Set(store.Link{Code: "owned", URL: "https://go.dev/", Owner: "key-1"})
+
+	tests := []struct {
+		page    http.HandlerFunc
+		target  string
+		want    string
+		private bool
+	}{
+		{h.WebLinksHandler, "/links/anon", "unique visitors", false},
+		{h.WebLinksHandler, "/links/owned", "unique visitors", true},
+		{h.RedirectHandler, "/anon+", "<dt>Clicks</dt>", false},
+		{h.RedirectHandler, "/owned+", "<dt>Clicks</dt>", true},
+	}
+	for _, tt := range tests {
+		w := do(tt.page, http.MethodGet, tt.target, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d; want 200", tt.target, w.Code)
+		}
+		if shown := strings.Contains(w.Body.String(), tt.want); shown == tt.private {
+			t.Errorf("GET %s shows the clicks: %v; want %v", tt.target, shown, !tt.private)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+This is the linkcheck package. Destinations disappear: pages move, sites shut
+down, domains expire. The CHECKER visits every stored destination in the
+background, so link owners can find out that a link is dead before their
+visitors do.
+
+A ROUND of checks follows the fan-out/fan-in pattern of the concurrent web
+checker in Part 3, with two changes that matter once there are thousands of
+links instead of a dozen:
+
+  - BOUNDED CONCURRENCY. Instead of one goroutine per URL, a fixed number of
+    WORKERS (Options.Concurrency) take jobs from a channel. However many links
+    there are, only that many requests are in flight.
+  - POLITENESS. A job is a whole HOST with all of its URLs. The worker that
+    takes it checks them one by one, waiting Options.HostDelay in between, so
+    no site ever sees more than one request from us at a time. A shortener
+    full of links to one popular site must not turn into a tool for hammering it.
+
+Handing the hosts out to the workers is the FAN-OUT. Each worker sends its
+results back on a second channel, and the goroutine that started the round
+collects them all and records them: the FAN-IN.
+
+Each URL is checked with a HEAD request, which asks for the headers only.
+Some servers don't implement HEAD, or answer it differently than GET, so a
+failed HEAD is retried with a GET before the attempt counts as a failure.
+
+One failure doesn't make a link broken: the site may just be having a bad
+minute. A link is BROKEN after Options.FailureThreshold failed rounds in a
+row, and healthy again after its first success.
+
+The results live in memory, like the click statistics. After a restart the
+first round, which runs right away, fills them in again.
+*/
+
+// Default settings, used for the zero values in Options.
+const (
+	DefaultConcurrency      = 8
+	DefaultHostDelay        = time.Second
+	DefaultTimeout          = 10 * time.Second
+	DefaultFailureThreshold = 3
+)
+
+// userAgent identifies our requests in the logs of the sites we check.
+const userAgent = "urlshortener-linkcheck/1.0"
+
+// pageSize is how many links are read from the store at a time.
+const pageSize = 500
+
+// errPrivateAddress is the error of a check that would have connected to a
+// non-public address.
+var errPrivateAddress = errors.New("refusing to connect to a non-public address")
+
+// Options configures a Checker.
+type Options struct {
+	// Concurrency is the number of hosts checked at the same time.
+	Concurrency int
+	// HostDelay is the pause between two requests to the same host.
+	HostDelay time.Duration
+	// Timeout bounds each request.
+	Timeout time.Duration
+	// FailureThreshold is the number of failed checks in a row after which a
+	// link counts as broken.
+	FailureThreshold int
+	// AllowPrivate lets the checker connect to private and loopback
+	// addresses. Without it, a stored link can't make the server probe its
+	// own network, even if DNS changed after the link passed the policy check.
+	AllowPrivate bool
+}
+
+// Health is what the checker knows about the destination of one link.
+type Health struct {
+	// URL is the destination that was checked. The link may have been
+	// changed since.
+	URL       string    `json:"-"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Status is the HTTP status of the last check, or 0 if there was no
+	// response; Error then says why.
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	// Failures counts the failed checks since the last successful one.
+	Failures int  `json:"consecutive_failures"`
+	Broken   bool `json:"broken"`
+}
+
+// Round summarises one pass over every link.
+type Round struct {
+	// Checked is the number of distinct destinations requested.
+	Checked int
+	// Broken is the number of broken links afterwards.
+	Broken   int
+	Duration time.Duration
+}
+
+// Checker checks the destinations of the links in a store.
+type Checker struct {
+	store  store.Store
+	client *http.Client
+	opts   Options
+
+	mu     sync.RWMutex
+	health map[string]Health // by code
+}
+
+// New creates a Checker for the links in s.
+func New(s store.Store, opts Options) *Checker {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.HostDelay <= 0 {
+		opts.HostDelay = DefaultHostDelay
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultFailureThreshold
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		// Control runs after the host name has been resolved, right before
+		// each connection, so it sees the address actually dialled. That
+		// covers redirects, too.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || !policy.IsPublicAddress(addr.Addr()) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		// No Proxy: a proxy would be the only address the dialer gets to see.
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: opts.Timeout,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     30 * time.Second,
+	}
+	return &Checker{
+		store:  s,
+		client: &http.Client{Transport: transport},
+		opts:   opts,
+		health: make(map[string]Health),
+	}
+}
+
+// Run checks every link right away, and then again every interval, until ctx
+// is cancelled. It blocks, so start it with `go`. After each round, report
+// (if not nil) is called with its summary and any error.
+func (c *Checker) Run(ctx context.Context, interval time.Duration, report func(Round, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		round, err := c.CheckAll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if report != nil {
+			report(round, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// target is one destination to check, and the codes of the links that go there.
+type target struct {
+	url   string
+	codes []string
+}
+
+// result is the outcome of checking one target.
+type result struct {
+	target
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// failed reports whether the check counts against the link. 401, 403 and 429
+// mean the site is there but won't talk to us (it may want a login, or not
+// like robots), which doesn't make the link dead.
+func (r result) failed() bool {
+	switch {
+	case r.err != nil:
+		return true
+	case r.status == http.StatusUnauthorized, r.status == http.StatusForbidden, r.status == http.StatusTooManyRequests:
+		return false
+	}
+	return r.status >= 400
+}
+
+// CheckAll runs one round: it checks every destination once and records the
+// results. It returns early if ctx is cancelled.
+func (c *Checker) CheckAll(ctx context.Context) (Round, error) {
+	start := time.Now()
+	hosts, codes, err := c.targetsByHost(start)
+	if err != nil {
+		return Round{}, err
+	}
+
+	// Fan out: the feeder hands out one host at a time to a fixed number of
+	// workers, which send back one result per target.
+	queue := make(chan []target)
+	results := make(chan result)
+	go func() {
+		defer close(queue)
+		for _, targets := range hosts {
+			select {
+			case queue <- targets:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var workers sync.WaitGroup
+	for range min(c.opts.Concurrency, len(hosts)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for targets := range queue {
+				c.checkHost(ctx, targets, results)
+			}
+		}()
+	}
+	// results is closed once every worker is done, which ends the loop below.
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// Fan in: record every result as it arrives.
+	round := Round{}
+	for r := range results {
+		c.record(r)
+		round.Checked++
+	}
+	if ctx.Err() != nil {
+		return round, ctx.Err()
+	}
+
+	// Forget links that were deleted or expired since the last round.
+	c.mu.Lock()
+	for code := range c.health {
+		if _, ok := codes[code]; !ok {
+			delete(c.health, code)
+		}
+	}
+	c.mu.Unlock()
+
+	round.Broken = c.BrokenCount()
+	round.Duration = time.Since(start)
+	return round, nil
+}
+
+// targetsByHost reads every active link from the store and groups their
+// destinations by host. Links that share a destination share a target, so it
+// is requested once. It also returns the set of codes it found.
+func (c *Checker) targetsByHost(now time.Time) (map[string][]target, map[string]struct{}, error) {
+	byURL := make(map[string]*target)
+	codes := make(map[string]struct{})
+	for after := ""; ; {
+		links, err := c.store.List(store.ListOptions{After: after, Limit: pageSize})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, link := range links {
+			if link.Expired(now) {
+				continue
+			}
+			codes[link.Code] = struct{}{}
+			t, ok := byURL[link.URL]
+			if !ok {
+				t = &target{url: link.URL}
+				byURL[link.URL] = t
+			}
+			t.codes = append(t.codes, link.Code)
+		}
+		if len(links) < pageSize {
+			break
+		}
+		after = links[len(links)-1].Code
+	}
+
+	hosts := make(map[string][]target)
+	for _, t := range byURL {
+		host := ""
+		if u, err := url.Parse(t.url); err == nil {
+			host = strings.ToLower(u.Hostname())
+		}
+		hosts[host] = append(hosts[host], *t)
+	}
+	return hosts, codes, nil
+}
+
+// checkHost checks the targets of one host, one after the other.
+func (c *Checker) checkHost(ctx context.Context, targets []target, results chan<- result) {
+	for i, t := range targets {
+		if i > 0 {
+			select {
+			case <-time.After(c.opts.HostDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+		results <- c.probe(ctx, t)
+	}
+}
+
+// probe checks one target: HEAD first, then GET if HEAD didn't work out.
+func (c *Checker) probe(ctx context.Context, t target) result {
+	r := result{target: t}
+	r.status, r.latency, r.err = c.request(ctx, http.MethodHead, t.url)
+	if r.failed() && ctx.Err() == nil && !errors.Is(r.err, errPrivateAddress) {
+		r.status, r.latency, r.err = c.request(ctx, http.MethodGet, t.url)
+	}
+	return r
+}
+
+// request sends one request and returns the status code and how long the
+// response took to arrive. Redirects are followed.
+func (c *Checker) request(ctx context.Context, method, rawURL string) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, latency, err
+	}
+	// We only want the status, so the body is closed without being read.
+	resp.Body.Close()
+	return resp.StatusCode, latency, nil
+}
+
+// record updates the health of every link of a checked target.
+func (c *Checker) record(r result) {
+	h := Health{
+		URL:       r.url,
+		CheckedAt: time.Now(),
+		Status:    r.status,
+		LatencyMS: r.latency.Milliseconds(),
+	}
+	if r.err != nil {
+		h.Error = errorText(r.err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, code := range r.codes {
+		entry := h
+		if prev, ok := c.health[code]; ok && prev.URL == r.url && r.failed() {
+			entry.Failures = prev.Failures
+		}
+		if r.failed() {
+			entry.Failures++
+		}
+		entry.Broken = entry.Failures >= c.opts.FailureThreshold
+		c.health[code] = entry
+	}
+}
+
+// errorText describes a failed request without repeating its method and URL,
+// which *url.Error adds and which the caller already knows.
+func errorText(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timed out"
+	}
+	return err.Error()
+}
+
+// Health returns what is known about the link with the given code, as long as
+// it was checked with its current destination url.
+func (c *Checker) Health(code, url string) (Health, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.health[code]
+	if !ok || h.URL != url {
+		return Health{}, false
+	}
+	return h, true
+}
+
+// Broken returns the codes of the broken links, in ascending order.
+func (c *Checker) Broken() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var codes []string
+	for code, h := range c.health {
+		if h.Broken {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// BrokenCount returns the number of broken links.
+func (c *Checker) BrokenCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n := 0
+	for _, h := range c.health {
+		if h.Broken {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+func newStore(t *testing.T, links map[string]string) store.Store {
+	t.Helper()
+	s := store.NewURLStore()
+	for code, url := range links {
+		if err := s.Set(store.Link{Code: code, URL: url}); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	return s
+}
+
+func checkAll(t *testing.T, c *Checker) Round {
+	t.Helper()
+	round, err := c.CheckAll(context.Background())
+	if err != nil {
+		t.Fatalf("CheckAll() error: %v", err)
+	}
+	return round
+}
+
+func TestCheckAll(t *testing.T) {
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			if down.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/no-head":
+			// Like some real servers, this one only answers GET.
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/login":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s := newStore(t, map[string]string{
+		"ok":     srv.URL + "/ok",
+		"ok2":    srv.URL + "/ok",
+		"nohead": srv.URL + "/no-head",
+		"login":  srv.URL + "/login",
+		"gone":   srv.URL + "/gone",
+	})
+	c := New(s, Options{HostDelay: time.Millisecond, FailureThreshold: 2, AllowPrivate: true})
+
+	round := checkAll(t, c)
+	if round.Checked != 4 {
+		t.Errorf("Checked = %d; want 4 (two links share a destination)", round.Checked)
+	}
+	if h, ok := c.Health("nohead", srv.URL+"/no-head"); !ok || h.Status != http.StatusOK || h.Failures != 0 {
+		t.Errorf("Health(nohead) = %+v, %t; want a successful GET after the failed HEAD", h, ok)
+	}
+	if h, _ := c.Health("login", srv.URL+"/login"); h.Failures != 0 {
+		t.Errorf("Health(login) = %+v; 403 should not count as a failure", h)
+	}
+	if h, _ := c.Health("gone", srv.URL+"/gone"); h.Status != http.StatusNotFound || h.Failures != 1 || h.Broken {
+		t.Errorf("Health(gone) after one round = %+v; want one failure, not broken yet", h)
+	}
+	if _, ok := c.Health("gone", "https://elsewhere.example/"); ok {
+		t.Error("Health() with a different URL found an entry; want none once a link points elsewhere")
+	}
+
+	down.Store(true)
+	round = checkAll(t, c)
+	if round.Broken != 1 || !slices.Equal(c.Broken(), []string{"gone"}) {
+		t.Errorf("after two rounds: Broken() = %v (%d); want [gone], as ok and ok2 only failed once", c.Broken(), round.Broken)
+	}
+	checkAll(t, c)
+	if got := c.Broken(); !slices.Equal(got, []string{"gone", "ok", "ok2"}) {
+		t.Errorf("after three rounds: Broken() = %v; want [gone ok ok2]", got)
+	}
+
+	// One success makes a link healthy again, and deleted links are forgotten.
+	down.Store(false)
+	if err := s.Delete("gone"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	checkAll(t, c)
+	if got := c.Broken(); len(got) != 0 {
+		t.Errorf("after recovery: Broken() = %v; want none", got)
+	}
+	if _, ok := c.Health("gone", srv.URL+"/gone"); ok {
+		t.Error("Health(gone) still known after the link was deleted")
+	}
+}
+
+func TestOneRequestPerHostAtATime(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	links := make(map[string]string)
+	for _, code := range []string{"a", "b", "c", "d", "e", "f"} {
+		links[code] = srv.URL + "/" + code
+	}
+	c := New(newStore(t, links), Options{Concurrency: 4, HostDelay: time.Millisecond, AllowPrivate: true})
+	if round := checkAll(t, c); round.Checked != 6 {
+		t.Errorf("Checked = %d; want 6", round.Checked)
+	}
+	if most != 1 {
+		t.Errorf("%d requests to one host at once; want 1", most)
+	}
+}
+
+func TestPrivateAddressesAreRefused(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	c := New(newStore(t, map[string]string{"local": srv.URL}), Options{})
+	checkAll(t, c)
+	h, _ := c.Health("local", srv.URL)
+	if hits.Load() != 0 || h.Failures != 1 || h.Error == "" {
+		t.Errorf("Health(local) = %+v after %d requests; want a failure without any request", h, hits.Load())
+	}
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+/*
+This is the logging package. The service logs with log/slog, the standard
+library's STRUCTURED logger. Instead of formatting a sentence,
+
+	log.Printf("Created new code '%s' for URL '%s'", code, url)
+
+each call has a fixed message and a list of key-value attributes:
+
+	logger.InfoContext(ctx, "Created new code", "code", code, "url", url)
+
+A handler then writes the record out, as text for people reading a terminal
+or as one JSON object per line for log collectors, which can then filter and
+aggregate by attribute ("all lines where code=abc123") without parsing
+sentences:
+
+	time=2026-05-01T10:00:00Z level=INFO msg="Created new code" code=abc123 url=https://go.dev/ request_id=4f1c9a...
+	{"time":"2026-05-01T10:00:00Z","level":"INFO","msg":"Created new code","code":"abc123","url":"https://go.dev/","request_id":"4f1c9a..."}
+
+The request_id attribute above is added by this package. The access log
+middleware (see the handler package) gives every request an ID and stores it
+in the request's context; every line logged with that context, through the
+...Context methods, then carries the ID. Searching the logs for one ID finds
+everything that happened while serving that request.
+*/
+
+// Formats accepted by New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New creates a logger that writes records of at least the given level to w,
+// in the given format, adding the request ID from the context to each one.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q (use %q or %q)", format, FormatText, FormatJSON)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error", in any case.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("logging: unknown level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// requestIDKey is the context key of the request ID. An unexported type means
+// no other package can accidentally use the same key.
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler is a slog.Handler that adds the request ID from the context
+// to every record before passing it on.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"slices"
+	"sort"
+	"sync/atomic"
+)
+
+/*
+A histogram sorts observations into BUCKETS by upper bound. With the bounds
+0.1, 0.5 and 1, an observation of 0.3 lands in the 0.5 bucket. In the output
+each bucket counts everything up to its bound (so the buckets are cumulative,
+and the last one, "+Inf", is the total):
+
+	http_request_duration_seconds_bucket{le="0.1"} 40
+	http_request_duration_seconds_bucket{le="0.5"} 52
+	http_request_duration_seconds_bucket{le="1"} 53
+	http_request_duration_seconds_bucket{le="+Inf"} 53
+	http_request_duration_seconds_sum 7.25
+	http_request_duration_seconds_count 53
+
+Internally each observation increments a single counter, and the running
+totals are only added up at scrape time.
+*/
+
+// DefBuckets are bucket bounds for request durations in seconds, from 5ms to
+// 10s. They are the defaults of the official Prometheus client.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	bounds []float64
+	// counts[i] counts observations in (bounds[i-1], bounds[i]]; the extra
+	// last one counts those above every bound.
+	counts []atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	// SearchFloat64s finds the first bound >= v, which is v's bucket.
+	h.counts[sort.SearchFloat64s(h.bounds, v)].Add(1)
+	addFloat(&h.sum, v)
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+// NewHistogramVec registers a histogram with the given bucket bounds (in
+// increasing order) and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram buckets of " + name + " are not sorted")
+	}
+	bounds := slices.Clone(buckets)
+	v := &HistogramVec{vec[*Histogram]{desc: desc{name, help, "histogram", labels}, newChild: func() *Histogram { return newHistogram(bounds) }}}
+	r.register(name, v)
+	return v
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, h *Histogram) {
+		var total uint64
+		for i := range h.counts {
+			total += h.counts[i].Load()
+			bound := math.Inf(1)
+			if i < len(h.bounds) {
+				bound = h.bounds[i]
+			}
+			writeSample(w, v.name+"_bucket", v.labels, values, "le", formatFloat(bound), float64(total))
+		}
+		writeSample(w, v.name+"_sum", v.labels, values, "", "", math.Float64frombits(h.sum.Load()))
+		writeSample(w, v.name+"_count", v.labels, values, "", "", float64(total))
+	})
+}
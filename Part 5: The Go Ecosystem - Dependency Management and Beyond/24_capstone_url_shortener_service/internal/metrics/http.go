@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Instrument wraps a ServeMux so that every request it serves is counted and
+// timed. Requests are labelled with the mux PATTERN they matched ("/api/links/")
+// rather than their path ("/api/links/abc123"): there is a fixed number of
+// patterns, but no limit to the paths clients can make up. Routes added to the
+// mux later are measured without any further work.
+//
+// It registers http_requests_total, http_request_duration_seconds and
+// http_requests_in_flight, so it may be called only once per registry.
+func (r *Registry) Instrument(mux *http.ServeMux) http.Handler {
+	requests := r.NewCounterVec("http_requests_total", "HTTP requests served.", "route", "method", "status")
+	duration := r.NewHistogramVec("http_request_duration_seconds", "Time taken to serve HTTP requests.", DefBuckets, "route")
+	var inFlight atomic.Int64
+	r.NewGaugeFunc("http_requests_in_flight", "HTTP requests being served right now.", func() float64 {
+		return float64(inFlight.Load())
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, route := mux.Handler(req)
+		if route == "" {
+			route = "unmatched"
+		}
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		mux.ServeHTTP(sw, req)
+
+		duration.With(route).Observe(time.Since(start).Seconds())
+		requests.With(route, method(req.Method), strconv.Itoa(sw.status())).Inc()
+	})
+}
+
+// method returns the request method, or "other" for non-standard ones, which
+// clients can make up as freely as paths.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return m
+	}
+	return "other"
+}
+
+// statusWriter remembers the status code a handler sends.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter, for
+// handlers that flush or set deadlines (see the batch handler).
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// status is the code that was sent; 200 if the handler never set one.
+func (sw *statusWriter) status() int {
+	if sw.code == 0 {
+		return http.StatusOK
+	}
+	return sw.code
+}
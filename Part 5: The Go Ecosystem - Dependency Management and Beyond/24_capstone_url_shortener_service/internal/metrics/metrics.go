@@ -0,0 +1,320 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+This is the metrics package. It lets the service report numbers about itself
+(requests served, latencies, links created, memory in use, ...) in the
+PROMETHEUS TEXT FORMAT, which most monitoring systems can scrape:
+
+	# HELP http_requests_total HTTP requests served.
+	# TYPE http_requests_total counter
+	http_requests_total{method="GET",route="/",status="302"} 1027
+	http_requests_total{method="POST",route="/api/shorten",status="201"} 12
+
+The format is simple enough to write with the standard library, so that's what
+we do instead of pulling in the official client. There are three kinds of
+metric:
+
+  - a COUNTER only goes up (requests served). Prometheus works out rates from
+    how fast it grows, and notices when a restart resets it to zero.
+  - a GAUGE goes up and down (links in the store, goroutines running).
+  - a HISTOGRAM counts observations (request durations) into buckets, so
+    percentiles can be estimated later: "95% of requests took under 50ms".
+
+Metrics may have LABELS, like route and status above; every combination of
+label values is a separate time series. Label values must come from a small,
+fixed set: a label holding the short code or the client IP would create a new
+series for every link or visitor, and eventually sink the monitoring system.
+
+Every metric is created through a Registry, which writes them all out when
+scraped. Updating a metric is cheap and safe for concurrent use: it is an
+atomic add on the hot path, and all the formatting happens at scrape time.
+*/
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family is a metric with all its labelled series, as registered.
+type family interface {
+	// write appends the family in the text format to w.
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and writes them out. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	names    map[string]bool
+	families []family
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a family, panicking on a duplicate name: two metrics with one
+// name is a programming error that should show up the first time the code runs.
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every metric in the text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics, for GET /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		r.WriteTo(w)
+	})
+}
+
+// --- Counters ---
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits atomic.Uint64 // a float64, stored as its bits so it can be updated atomically
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v, which must not be negative, to the counter.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't go down")
+	}
+	addFloat(&c.bits, v)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// addFloat adds v to a float64 stored as bits. There is no atomic float
+// addition, so we use compare-and-swap: read the old value, compute the new
+// one, and store it only if nobody changed the old one in the meantime.
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// CounterVec is a counter with labels: one Counter per combination of label values.
+type CounterVec struct {
+	vec[*Counter]
+}
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[*Counter]{desc: desc{name, help, "counter", labels}, newChild: func() *Counter { return new(Counter) }}}
+	r.register(name, v)
+	return v
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, c *Counter) {
+		writeSample(w, v.name, v.labels, values, "", "", c.Value())
+	})
+}
+
+// --- Gauges ---
+
+// valueFunc is a gauge or counter whose value is computed when it is scraped.
+type valueFunc struct {
+	desc
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn at every scrape.
+// That suits values that already live elsewhere, like the number of links in
+// the store: there is nothing to keep in sync.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &valueFunc{desc{name, help, "gauge", nil}, fn})
+}
+
+// NewCounterFunc is NewGaugeFunc for a count that is kept elsewhere, like the
+// hits of the link cache. fn must never return less than it did before.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, &valueFunc{desc{name, help, "counter", nil}, fn})
+}
+
+func (g *valueFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.name, nil, nil, "", "", g.value())
+}
+
+// --- Shared plumbing ---
+
+// desc describes a metric family.
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.kind)
+}
+
+// vec keeps the children of a labelled metric, keyed by their label values.
+type vec[T any] struct {
+	desc
+	newChild func() T
+
+	mu       sync.RWMutex
+	children map[string]child[T]
+}
+
+type child[T any] struct {
+	values []string
+	metric T
+}
+
+// With returns the metric for the given label values, in the order the
+// labels were declared, creating it on first use.
+func (v *vec[T]) With(values ...string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	if v.children == nil {
+		v.children = make(map[string]child[T])
+	}
+	c = child[T]{slices.Clone(values), v.newChild()}
+	v.children[key] = c
+	return c.metric
+}
+
+// each calls fn for every child, sorted by label values so the output is stable.
+func (v *vec[T]) each(fn func(values []string, metric T)) {
+	v.mu.RLock()
+	children := make([]child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+	slices.SortFunc(children, func(a, b child[T]) int { return slices.Compare(a.values, b.values) })
+	for _, c := range children {
+		fn(c.values, c.metric)
+	}
+}
+
+// writeSample writes one line: name{labels} value. extraLabel, if not empty,
+// is appended to the labels (histograms use it for "le").
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, label, values[i])
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(w *bufio.Writer, name, value string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	labelEscaper.WriteString(w, value)
+	w.WriteByte('"')
+}
+
+// formatFloat writes numbers the way Prometheus expects, including "+Inf".
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written, for WriteTo.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
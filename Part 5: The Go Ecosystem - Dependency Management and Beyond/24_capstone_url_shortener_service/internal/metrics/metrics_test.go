@@ -0,0 +1,120 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/metrics"
+)
+
+func scrape(t *testing.T, reg *metrics.Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	return b.String()
+}
+
+func TestExpositionFormat(t *testing.T) {
+	reg := metrics.NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Requests.\nBy route.", "route", "status")
+	requests.With("/b", "200").Add(2)
+	requests.With("/a", "404").Inc()
+	requests.With(`say "hi"\`, "200").Inc()
+	reg.NewCounter("plain_total", "No labels.").Inc()
+	reg.NewGaugeFunc("answer", "A gauge.", func() float64 { return 42 })
+	reg.NewCounterFunc("kept_total", "Counted elsewhere.", func() float64 { return 7 })
+	latency := reg.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.With("/").Observe(v)
+	}
+
+	want := `# HELP requests_total Requests.\nBy route.
+# TYPE requests_total counter
+requests_total{route="/a",status="404"} 1
+requests_total{route="/b",status="200"} 2
+requests_total{route="say \"hi\"\\",status="200"} 1
+# HELP plain_total No labels.
+# TYPE plain_total counter
+plain_total 1
+# HELP answer A gauge.
+# TYPE answer gauge
+answer 42
+# HELP kept_total Counted elsewhere.
+# TYPE kept_total counter
+kept_total 7
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 2
+latency_seconds_bucket{route="/",le="1"} 3
+latency_seconds_bucket{route="/",le="+Inf"} 4
+latency_seconds_sum{route="/"} 3.65
+latency_seconds_count{route="/"} 4
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := reg.NewCounterVec("hits_total", "Hits.", "worker")
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.With("all").Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.With("all").Value(); got != 8000 {
+		t.Errorf("counter = %v; want 8000", got)
+	}
+}
+
+func TestDuplicateNamePanics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.NewCounter("x_total", "X.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering x_total twice didn't panic")
+		}
+	}()
+	reg.NewCounter("x_total", "X again.")
+}
+
+func TestInstrument(t *testing.T) {
+	reg := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/items/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	h := reg.Instrument(mux)
+	for _, path := range []string{"/items/1", "/items/2", "/items/missing", "/elsewhere"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	out := scrape(t, reg)
+	for _, line := range []string{
+		`http_requests_total{route="/items/",method="GET",status="200"} 2`,
+		`http_requests_total{route="/items/",method="GET",status="404"} 1`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_request_duration_seconds_count{route="/items/"} 3`,
+		`http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
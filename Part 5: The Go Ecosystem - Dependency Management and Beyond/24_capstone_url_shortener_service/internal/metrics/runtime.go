@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"bufio"
+	"runtime"
+	"time"
+)
+
+// runtimeCollector reports the state of the Go runtime: goroutines, memory
+// and garbage collection. The names follow the official client, so existing
+// Go dashboards work with them.
+type runtimeCollector struct {
+	start time.Time
+}
+
+// RegisterRuntime adds the Go runtime metrics to the registry.
+func (r *Registry) RegisterRuntime() {
+	r.register("go_runtime", &runtimeCollector{start: time.Now()})
+}
+
+func (c *runtimeCollector) write(w *bufio.Writer) {
+	// ReadMemStats briefly stops the world, which is fine once per scrape but
+	// is why it happens here and not on every request.
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	gauge := func(name, help string, v float64) {
+		desc{name, help, "gauge", nil}.writeHeader(w)
+		writeSample(w, name, nil, nil, "", "", v)
+	}
+	counter := func(name, help string, v float64) {
+		desc{name, help, "counter", nil}.writeHeader(w)
+		writeSample(w, name, nil, nil, "", "", v)
+	}
+
+	desc{"go_info", "Information about the Go environment.", "gauge", nil}.writeHeader(w)
+	writeSample(w, "go_info", []string{"version"}, []string{runtime.Version()}, "", "", 1)
+	gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(m.Alloc))
+	counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(m.TotalAlloc))
+	gauge("go_memstats_sys_bytes", "Number of bytes obtained from the system.", float64(m.Sys))
+	gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(m.HeapObjects))
+	gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(m.HeapInuse))
+	gauge("go_memstats_next_gc_bytes", "Heap size at which the next garbage collection will take place.", float64(m.NextGC))
+	counter("go_gc_cycles_total", "Number of completed garbage collection cycles.", float64(m.NumGC))
+	counter("go_gc_pause_seconds_total", "Total time the world was stopped for garbage collection.", float64(m.PauseTotalNs)/1e9)
+	gauge("process_start_time_seconds", "Start time of the process since the Unix epoch in seconds.", float64(c.start.UnixNano())/1e9)
+}
@@ -0,0 +1,99 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+This is the password package. It turns the passwords of protected links into
+HASHES that are safe to store, and checks passwords against them.
+
+API key secrets (see the apikey package) are random, so one fast SHA-256 is
+enough for them. Passwords are chosen by people, and people choose guessable
+ones: with a fast hash, an attacker holding a copy of the database could try
+billions of guesses per second. So we use PBKDF2, which repeats HMAC-SHA256
+hundreds of thousands of times. One hash then takes a good fraction of a
+second, which nobody notices when they log in, but which slows guessing down
+by the same factor.
+
+Every hash also gets its own random SALT, mixed into the computation. Two
+links with the same password get different hashes, so an attacker has to
+attack each hash on its own instead of all of them at once.
+
+The stored string records everything needed to check a password later:
+
+	pbkdf2-sha256$600000$<salt, base64>$<hash, base64>
+
+Because the iteration count is part of it, we can raise the count for new
+passwords later without breaking the old ones.
+*/
+
+const (
+	// algorithm names the scheme at the start of every hash.
+	algorithm = "pbkdf2-sha256"
+	// Iterations is the PBKDF2 iteration count of new hashes, as recommended
+	// by OWASP for PBKDF2-HMAC-SHA256.
+	Iterations = 600_000
+
+	saltBytes = 16
+	keyBytes  = 32
+)
+
+// ErrMalformedHash is returned by Verify for strings that Hash didn't produce.
+var ErrMalformedHash = errors.New("password: malformed hash")
+
+// Hash returns the salted hash of a password, ready to be stored.
+func Hash(password string) (string, error) {
+	return hash(password, Iterations)
+}
+
+func hash(password string, iterations int) (string, error) {
+	salt := make([]byte, saltBytes)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keyBytes)
+	if err != nil {
+		return "", fmt.Errorf("password: %w", err)
+	}
+	return strings.Join([]string{
+		algorithm,
+		strconv.Itoa(iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// Verify reports whether password is the one that encoded was made from.
+func Verify(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != algorithm {
+		return false, ErrMalformedHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false, ErrMalformedHash
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false, fmt.Errorf("password: %w", err)
+	}
+	// ConstantTimeCompare takes as long for a wrong first byte as for a wrong
+	// last one, so response times don't tell an attacker how close they got.
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
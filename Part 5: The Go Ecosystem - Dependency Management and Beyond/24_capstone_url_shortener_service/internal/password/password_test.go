@@ -0,0 +1,60 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := hash("correct horse", 1000)
+	if err != nil {
+		t.Fatalf("hash() error: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "pbkdf2-sha256$1000$") {
+		t.Errorf("hash() = %q, want the algorithm and iteration count first", encoded)
+	}
+	if strings.Contains(encoded, "correct horse") {
+		t.Errorf("hash() = %q contains the password", encoded)
+	}
+
+	for _, tt := range []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse", true},
+		{"Correct horse", false},
+		{"correct horse ", false},
+		{"", false},
+	} {
+		got, err := Verify(tt.password, encoded)
+		if err != nil || got != tt.want {
+			t.Errorf("Verify(%q) = %t, %v; want %t", tt.password, got, err, tt.want)
+		}
+	}
+}
+
+func TestHashIsSalted(t *testing.T) {
+	a, _ := hash("secret", 1000)
+	b, _ := hash("secret", 1000)
+	if a == b {
+		t.Errorf("two hashes of the same password are both %q", a)
+	}
+}
+
+func TestVerifyRejectsMalformedHashes(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"secret",
+		"bcrypt$10$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$many$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$0$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$1000$not base64$aGFzaA",
+		"pbkdf2-sha256$1000$c2FsdA$",
+		"pbkdf2-sha256$1000$c2FsdA$aGFzaA$extra",
+	} {
+		if ok, err := Verify("secret", encoded); ok || !errors.Is(err, ErrMalformedHash) {
+			t.Errorf("Verify(%q) = %t, %v; want ErrMalformedHash", encoded, ok, err)
+		}
+	}
+}
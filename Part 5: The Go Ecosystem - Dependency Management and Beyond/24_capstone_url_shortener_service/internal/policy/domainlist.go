@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A domain list is a plain text file with one domain per line:
+
+	# Known phishing domains
+	evil.example
+	*.tracker.example     (the "*." is optional: subdomains always match)
+
+Blank lines and everything after a "#" are ignored. A domain matches itself
+and all of its subdomains, so "evil.example" also covers "www.evil.example".
+International domains must be written in their ASCII form (xn--...).
+
+The file is checked for changes every few seconds and reloaded when its
+modification time changes, so operators can block a domain without a restart.
+If a reload fails (say, the file is halfway through being rewritten), the
+previous list stays in effect.
+*/
+
+// reloadInterval is how often a domain list file is checked for changes.
+const reloadInterval = 2 * time.Second
+
+// domainList is a set of domains loaded from a file.
+type domainList struct {
+	path string
+
+	mu      sync.Mutex
+	domains map[string]bool
+	modTime time.Time
+	checked time.Time
+}
+
+func openDomainList(path string) (*domainList, error) {
+	l := &domainList{path: path}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// matches reports whether host, or any domain it is a subdomain of, is on the list.
+func (l *domainList) matches(host string) bool {
+	l.reloadIfChanged()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		if l.domains[host] {
+			return true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return false
+		}
+		host = parent
+	}
+}
+
+// reloadIfChanged re-reads the file if it has been modified, at most once
+// every reloadInterval.
+func (l *domainList) reloadIfChanged() {
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.checked) < reloadInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.checked = now
+	l.mu.Unlock()
+
+	info, err := os.Stat(l.path)
+	if err != nil || info.ModTime().Equal(l.loadedModTime()) {
+		return
+	}
+	l.load()
+}
+
+func (l *domainList) loadedModTime() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.modTime
+}
+
+// load reads the file and replaces the list.
+func (l *domainList) load() error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("policy: open domain list: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("policy: stat domain list: %w", err)
+	}
+
+	domains := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		domain := strings.ToLower(strings.TrimSpace(line))
+		domain = strings.TrimPrefix(domain, "*.")
+		domain = strings.Trim(domain, ".")
+		if domain != "" {
+			domains[domain] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("policy: read domain list %s: %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	l.domains = domains
+	l.modTime = info.ModTime()
+	l.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,277 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+This is the policy package. It decides which destinations the service is
+willing to shorten. A URL shortener is an attractive tool for abuse: a short
+link hides where it goes, so it can dress up a phishing page, a malware
+download, or a `javascript:` URL that runs code in the visitor's browser.
+
+Every destination is checked against these rules, in order:
+
+ 1. SCHEME: only the allowed schemes (http and https by default).
+ 2. REDIRECT LOOPS: a link to one of our own short links would redirect to
+    itself (or to another short link, and so on), so the service's own host
+    is refused.
+ 3. DOMAIN LISTS: a blocklist of domains that are refused, and optionally an
+    allowlist: if one is configured, ONLY its domains are accepted. Listing a
+    domain covers its subdomains too. Both lists are plain text files (see
+    domainlist.go), reloaded automatically when they change.
+ 4. PRIVATE ADDRESSES: destinations on loopback (127.0.0.1, ::1, localhost),
+    private (10.x, 192.168.x, ...) or link-local networks are refused. They
+    mean nothing to most visitors, and pointing visitors' browsers at their
+    own routers and intranets is a classic attack. Optionally, host names are
+    resolved too, to catch public names that point at private addresses.
+
+A refused URL produces a *Violation, which carries a machine-readable Reason
+(for API clients) and a human-readable Detail.
+*/
+
+// Reason is a machine-readable code saying why a destination was refused.
+type Reason string
+
+// The reasons a destination can be refused for.
+const (
+	ReasonInvalidURL       Reason = "invalid_url"
+	ReasonSchemeNotAllowed Reason = "scheme_not_allowed"
+	ReasonRedirectLoop     Reason = "redirect_loop"
+	ReasonDomainBlocked    Reason = "domain_blocked"
+	ReasonDomainNotAllowed Reason = "domain_not_allowed"
+	ReasonPrivateAddress   Reason = "private_address"
+)
+
+// Violation is the error returned for a destination the policy refuses.
+type Violation struct {
+	Reason Reason
+	Detail string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("policy: %s: %s", v.Reason, v.Detail)
+}
+
+// DefaultSchemes are the schemes allowed when Options.AllowedSchemes is empty.
+var DefaultSchemes = []string{"http", "https"}
+
+// lookupTimeout bounds how long a DNS lookup may hold up a request.
+const lookupTimeout = 2 * time.Second
+
+// Options configures a Policy.
+type Options struct {
+	// AllowedSchemes lists the URL schemes that may be shortened.
+	// Empty means DefaultSchemes.
+	AllowedSchemes []string
+	// BlocklistFile and AllowlistFile are paths to domain list files.
+	// Empty means no such list.
+	BlocklistFile string
+	AllowlistFile string
+	// AllowPrivate turns off the check for private and loopback destinations,
+	// which is useful for a shortener that only serves an intranet.
+	AllowPrivate bool
+	// BaseURL is the service's own address, e.g. "https://sho.rt".
+	// Destinations on the same host are refused as redirect loops.
+	BaseURL string
+	// LookupIP, if set, resolves host names so that names pointing at private
+	// addresses are refused too. Lookup failures are not treated as
+	// violations, since a domain may not be set up yet when it is shortened.
+	LookupIP func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// Policy checks destinations. It is safe for concurrent use.
+type Policy struct {
+	schemes      map[string]bool
+	blocklist    *domainList
+	allowlist    *domainList
+	allowPrivate bool
+	selfHost     string
+	lookupIP     func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// New creates a Policy and loads its domain lists. A configured list file
+// that can't be read is an error: silently running without a blocklist, or
+// with an empty allowlist, is not what anyone wants.
+func New(opts Options) (*Policy, error) {
+	p := &Policy{
+		schemes:      make(map[string]bool),
+		allowPrivate: opts.AllowPrivate,
+		lookupIP:     opts.LookupIP,
+	}
+	schemes := opts.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+	for _, s := range schemes {
+		p.schemes[strings.ToLower(s)] = true
+	}
+
+	if opts.BaseURL != "" {
+		base, err := url.Parse(opts.BaseURL)
+		if err != nil || base.Host == "" {
+			return nil, fmt.Errorf("policy: invalid base URL %q", opts.BaseURL)
+		}
+		p.selfHost = strings.ToLower(base.Hostname())
+	}
+
+	var err error
+	if opts.BlocklistFile != "" {
+		if p.blocklist, err = openDomainList(opts.BlocklistFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.AllowlistFile != "" {
+		if p.allowlist, err = openDomainList(opts.AllowlistFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Check returns a *Violation if the destination URL may not be shortened.
+// It expects an absolute URL, ideally already canonical (lowercase host,
+// punycode), since that is what the domain lists are written in.
+func (p *Policy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return &Violation{ReasonInvalidURL, "the URL must be absolute, with a scheme and host"}
+	}
+	// The scheme is checked first, so "javascript:alert(1)" is reported as a
+	// forbidden scheme rather than as a URL without a host.
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return &Violation{ReasonSchemeNotAllowed, fmt.Sprintf("the %q scheme is not allowed", u.Scheme)}
+	}
+	if u.Host == "" {
+		return &Violation{ReasonInvalidURL, "the URL must be absolute, with a scheme and host"}
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if p.selfHost != "" && host == p.selfHost {
+		return &Violation{ReasonRedirectLoop, "the URL points back at this service"}
+	}
+
+	if p.blocklist != nil && p.blocklist.matches(host) {
+		return &Violation{ReasonDomainBlocked, fmt.Sprintf("%s is blocked", host)}
+	}
+	if p.allowlist != nil && !p.allowlist.matches(host) {
+		return &Violation{ReasonDomainNotAllowed, fmt.Sprintf("%s is not on the list of allowed domains", host)}
+	}
+
+	if !p.allowPrivate {
+		if err := p.checkAddress(ctx, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAddress refuses hosts that are, or resolve to, non-public addresses.
+func (p *Policy) checkAddress(ctx context.Context, host string) error {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &Violation{ReasonPrivateAddress, host + " is a loopback host"}
+	}
+	if addr, ok := parseIP(host); ok {
+		if !isPublic(addr) {
+			return &Violation{ReasonPrivateAddress, addr.String() + " is not a public address"}
+		}
+		return nil
+	}
+
+	if p.lookupIP == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	addrs, err := p.lookupIP(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr) {
+			return &Violation{ReasonPrivateAddress, fmt.Sprintf("%s resolves to %s, which is not a public address", host, addr.Unmap())}
+		}
+	}
+	return nil
+}
+
+// IsPublicAddress reports whether an address is reachable on the public
+// internet. Code that connects to destinations itself (see the linkcheck
+// package) uses it to stay out of private networks.
+func IsPublicAddress(addr netip.Addr) bool {
+	return isPublic(addr)
+}
+
+// isPublic reports whether an address is reachable on the public internet.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap() // ::ffff:127.0.0.1 is 127.0.0.1
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !isSharedAddress(addr)
+}
+
+// sharedAddressSpace is 100.64.0.0/10, used by carrier-grade NAT. Like the
+// private ranges it is never reachable from the internet, but
+// netip.Addr.IsPrivate doesn't include it.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isSharedAddress(addr netip.Addr) bool {
+	return sharedAddressSpace.Contains(addr)
+}
+
+// parseIP parses a host that is an IP address. Besides the usual forms, it
+// accepts the legacy IPv4 spellings browsers still honour, such as
+// "2130706433", "0x7f.1" or "0177.0.0.1" (all of them 127.0.0.1), which would
+// otherwise slip past the private address check.
+func parseIP(host string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		return addr, true
+	}
+	return parseLegacyIPv4(host)
+}
+
+// parseLegacyIPv4 follows the IPv4 parser of the WHATWG URL standard: one to
+// four dot-separated numbers in decimal, octal (leading 0) or hex (leading 0x),
+// where the last number fills all the remaining bytes.
+func parseLegacyIPv4(host string) (netip.Addr, bool) {
+	parts := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+	nums := make([]uint64, len(parts))
+	for i, part := range parts {
+		base := 10
+		switch {
+		case strings.HasPrefix(part, "0x"):
+			part, base = part[2:], 16
+			if part == "" {
+				part = "0"
+			}
+		case len(part) > 1 && part[0] == '0':
+			part, base = part[1:], 8
+		}
+		n, err := strconv.ParseUint(part, base, 32)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		nums[i] = n
+	}
+
+	var ip uint64
+	for i, n := range nums[:len(nums)-1] {
+		if n > 255 {
+			return netip.Addr{}, false
+		}
+		ip |= n << (8 * (3 - i))
+	}
+	last := nums[len(nums)-1]
+	if last >= 1<<(8*(5-len(nums))) {
+		return netip.Addr{}, false
+	}
+	ip |= last
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), true
+}
@@ -0,0 +1,163 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/policy"
+)
+
+// reason returns the Reason of a policy error, or "" for nil.
+func reason(t *testing.T, err error) policy.Reason {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var v *policy.Violation
+	if !errors.As(err, &v) {
+		t.Fatalf("error %v is not a *policy.Violation", err)
+	}
+	return v.Reason
+}
+
+func TestCheck(t *testing.T) {
+	p, err := policy.New(policy.Options{BaseURL: "https://sho.rt"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	tests := []struct {
+		url  string
+		want policy.Reason
+	}{
+		{"https://go.dev/doc", ""},
+		{"http://93.184.215.14/", ""},
+		{"http://[2606:4700::1]/", ""},
+		{"javascript:alert(1)", policy.ReasonSchemeNotAllowed},
+		{"https:///no-host", policy.ReasonInvalidURL},
+		{"ftp://files.example/x", policy.ReasonSchemeNotAllowed},
+		{"https://sho.rt/abc123", policy.ReasonRedirectLoop},
+		{"https://SHO.RT./abc123", policy.ReasonRedirectLoop},
+		{"http://localhost:8080/", policy.ReasonPrivateAddress},
+		{"http://app.localhost/", policy.ReasonPrivateAddress},
+		{"http://127.0.0.1/", policy.ReasonPrivateAddress},
+		{"http://10.1.2.3/", policy.ReasonPrivateAddress},
+		{"http://192.168.1.1/admin", policy.ReasonPrivateAddress},
+		{"http://169.254.169.254/latest/meta-data", policy.ReasonPrivateAddress},
+		{"http://100.64.0.1/", policy.ReasonPrivateAddress},
+		{"http://0.0.0.0/", policy.ReasonPrivateAddress},
+		{"http://[::1]/", policy.ReasonPrivateAddress},
+		{"http://[::ffff:127.0.0.1]/", policy.ReasonPrivateAddress},
+		{"http://[fd00::1]/", policy.ReasonPrivateAddress},
+		// Legacy spellings of 127.0.0.1 that browsers still accept.
+		{"http://2130706433/", policy.ReasonPrivateAddress},
+		{"http://0x7f.1/", policy.ReasonPrivateAddress},
+		{"http://0177.0.0.1/", policy.ReasonPrivateAddress},
+	}
+	for _, tt := range tests {
+		if got := reason(t, p.Check(context.Background(), tt.url)); got != tt.want {
+			t.Errorf("Check(%q) reason = %q; want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	p, err := policy.New(policy.Options{AllowedSchemes: []string{"https", "ftp"}, AllowPrivate: true})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	for url, want := range map[string]policy.Reason{
+		"ftp://files.example/x": "",
+		"http://go.dev/":        policy.ReasonSchemeNotAllowed,
+		"https://10.0.0.1/":     "",
+	} {
+		if got := reason(t, p.Check(context.Background(), url)); got != want {
+			t.Errorf("Check(%q) reason = %q; want %q", url, got, want)
+		}
+	}
+}
+
+func TestLookupIP(t *testing.T) {
+	p, err := policy.New(policy.Options{
+		LookupIP: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			switch host {
+			case "intranet.example":
+				return []netip.Addr{netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("10.0.0.5")}, nil
+			case "public.example":
+				return []netip.Addr{netip.MustParseAddr("93.184.215.14")}, nil
+			}
+			return nil, errors.New("no such host")
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	for url, want := range map[string]policy.Reason{
+		"https://intranet.example/": policy.ReasonPrivateAddress,
+		"https://public.example/":   "",
+		"https://unknown.example/":  "",
+	} {
+		if got := reason(t, p.Check(context.Background(), url)); got != want {
+			t.Errorf("Check(%q) reason = %q; want %q", url, got, want)
+		}
+	}
+}
+
+func TestDomainLists(t *testing.T) {
+	dir := t.TempDir()
+	blocklist := filepath.Join(dir, "blocked.txt")
+	allowlist := filepath.Join(dir, "allowed.txt")
+	writeFile(t, blocklist, "# phishing\nevil.example\n*.tracker.example  # and its subdomains\n")
+	writeFile(t, allowlist, "example\ngo.dev\n")
+
+	p, err := policy.New(policy.Options{BlocklistFile: blocklist, AllowlistFile: allowlist})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	check := func(url string, want policy.Reason) {
+		t.Helper()
+		if got := reason(t, p.Check(context.Background(), url)); got != want {
+			t.Errorf("Check(%q) reason = %q; want %q", url, got, want)
+		}
+	}
+	check("https://go.dev/", "")
+	check("https://pkg.go.dev/", "")
+	check("https://evil.example/", policy.ReasonDomainBlocked)
+	check("https://www.evil.example/", policy.ReasonDomainBlocked)
+	check("https://a.b.tracker.example/", policy.ReasonDomainBlocked)
+	check("https://fine.example/", "")
+	check("https://github.com/", policy.ReasonDomainNotAllowed)
+
+	// Block go.dev while the policy is in use; the change is picked up on the next reload check.
+	writeFile(t, blocklist, "evil.example\ngo.dev\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(blocklist, later, later); err != nil {
+		t.Fatalf("Chtimes() error: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for reason(t, p.Check(context.Background(), "https://go.dev/")) != policy.ReasonDomainBlocked {
+		if time.Now().After(deadline) {
+			t.Fatal("the updated blocklist was not reloaded")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	check("https://a.b.tracker.example/", "")
+}
+
+func TestMissingListFile(t *testing.T) {
+	_, err := policy.New(policy.Options{BlocklistFile: filepath.Join(t.TempDir(), "missing.txt")})
+	if err == nil {
+		t.Error("New() with a missing blocklist succeeded")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package qrcode
+
+// matrix is a QR code under construction. Coordinates are (x, y) = (column, row),
+// with (0, 0) in the top-left corner.
+type matrix struct {
+	size    int
+	modules []bool
+	// function marks the modules of function patterns, which are never
+	// overwritten by data or changed by masking.
+	function []bool
+	version  int
+}
+
+func newMatrix(version int) *matrix {
+	size := 17 + 4*version
+	return &matrix{
+		size:     size,
+		modules:  make([]bool, size*size),
+		function: make([]bool, size*size),
+		version:  version,
+	}
+}
+
+func (m *matrix) get(x, y int) bool {
+	return m.modules[y*m.size+x]
+}
+
+// setFunction sets a module and marks it as part of a function pattern.
+func (m *matrix) setFunction(x, y int, dark bool) {
+	m.modules[y*m.size+x] = dark
+	m.function[y*m.size+x] = true
+}
+
+// drawFunctionPatterns draws everything that isn't data: the finder and
+// alignment patterns, the timing lines, and the version information. It also
+// reserves the format information area, which is filled in once the mask is known.
+func (m *matrix) drawFunctionPatterns() {
+	// Timing patterns: alternating lines along row 6 and column 6.
+	for i := 0; i < m.size; i++ {
+		m.setFunction(6, i, i%2 == 0)
+		m.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns in three corners, with their light separators.
+	m.drawFinder(3, 3)
+	m.drawFinder(m.size-4, 3)
+	m.drawFinder(3, m.size-4)
+
+	// Alignment patterns sit on every combination of the listed positions,
+	// except where they would overlap a finder pattern.
+	pos := alignmentPositions[m.version]
+	last := len(pos) - 1
+	for i, y := range pos {
+		for j, x := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			m.drawAlignment(x, y)
+		}
+	}
+
+	m.drawFormat(Low, 0) // placeholder, so the area is marked as a function pattern
+	m.drawVersion()
+}
+
+// drawFinder draws a 7x7 finder pattern centred on (cx, cy), plus the
+// one-module light border around it that stays inside the grid.
+func (m *matrix) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= m.size || y >= m.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy)) // rings: 0-1 dark, 2 light, 3 dark, 4 light
+			m.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws a 5x5 alignment pattern centred on (cx, cy).
+func (m *matrix) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat writes the 15 bits of format information (level and mask),
+// twice: once around the top-left finder and once split between the other two.
+// The 5 data bits are protected by a BCH code and XOR-ed with a fixed pattern
+// so the result is never all light.
+func (m *matrix) drawFormat(level Level, mask int) {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	// Around the top-left finder.
+	for i := 0; i <= 5; i++ {
+		m.setFunction(8, i, bit(i))
+	}
+	m.setFunction(8, 7, bit(6))
+	m.setFunction(8, 8, bit(7))
+	m.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.setFunction(14-i, 8, bit(i))
+	}
+
+	// Below the top-right and beside the bottom-left finder.
+	for i := 0; i < 8; i++ {
+		m.setFunction(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.setFunction(8, m.size-15+i, bit(i))
+	}
+	m.setFunction(8, m.size-8, true) // the "dark module" is always dark
+}
+
+// drawVersion writes the 18 bits of version information (versions 7 and up),
+// in two 6x3 blocks next to the top-right and bottom-left finders.
+func (m *matrix) drawVersion() {
+	if m.version < 7 {
+		return
+	}
+	rem := m.version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := m.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 == 1
+		a, b := m.size-11+i%3, i/3
+		m.setFunction(a, b, dark)
+		m.setFunction(b, a, dark)
+	}
+}
+
+// placeData writes the codewords into every module that isn't a function
+// pattern. The bits go in two-module-wide columns, starting in the bottom-right
+// corner and zigzagging up, then down, then up again, skipping the vertical
+// timing line in column 6. Leftover modules stay light.
+func (m *matrix) placeData(codewords []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < m.size; vert++ {
+			y := vert
+			if upward {
+				y = m.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if m.function[y*m.size+x] || i >= len(codewords)*8 {
+					continue
+				}
+				m.modules[y*m.size+x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// maskFuncs are the eight data masks: a module is flipped where the function
+// returns true.
+var maskFuncs = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// applyMask flips the data modules selected by the mask. Applying the same
+// mask twice undoes it.
+func (m *matrix) applyMask(mask int) {
+	f := maskFuncs[mask]
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if !m.function[y*m.size+x] && f(x, y) {
+				m.modules[y*m.size+x] = !m.modules[y*m.size+x]
+			}
+		}
+	}
+}
+
+// bestMask tries every mask and returns the one with the lowest penalty.
+func (m *matrix) bestMask(level Level) int {
+	best, bestScore := 0, -1
+	for mask := range maskFuncs {
+		m.applyMask(mask)
+		m.drawFormat(level, mask)
+		if score := m.penalty(); bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		m.applyMask(mask)
+	}
+	return best
+}
+
+// penalty scores how hard the symbol would be to scan, using the four rules
+// of the standard:
+//
+//  1. Runs of five or more same-coloured modules in a row or column.
+//  2. 2x2 blocks of one colour.
+//  3. Stretches that look like a finder pattern (dark-light-dark-dark-dark-light-dark
+//     next to four light modules).
+//  4. An unbalanced ratio of dark to light modules.
+func (m *matrix) penalty() int {
+	score := 0
+	line := make([]bool, m.size)
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			line[x] = m.get(x, y)
+		}
+		score += linePenalty(line)
+	}
+	for x := 0; x < m.size; x++ {
+		for y := 0; y < m.size; y++ {
+			line[y] = m.get(x, y)
+		}
+		score += linePenalty(line)
+	}
+
+	for y := 0; y < m.size-1; y++ {
+		for x := 0; x < m.size-1; x++ {
+			c := m.get(x, y)
+			if c == m.get(x+1, y) && c == m.get(x, y+1) && c == m.get(x+1, y+1) {
+				score += 3
+			}
+		}
+	}
+
+	dark := 0
+	for _, d := range m.modules {
+		if d {
+			dark++
+		}
+	}
+	// 10 points for every full 5% the dark share is away from 50%.
+	total := m.size * m.size
+	score += abs(dark*20-total*10) / total * 10
+	return score
+}
+
+// finderLike is the 1:1:3:1:1 pattern that penalty rule 3 looks for.
+var finderLike = []bool{true, false, true, true, true, false, true}
+
+// linePenalty scores one row or column for rules 1 and 3.
+func linePenalty(line []bool) int {
+	score := 0
+
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			score += 3 + run - 5
+		}
+		run = 1
+	}
+
+	// Outside the symbol counts as light, like the quiet zone around it.
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < len(line) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i+len(finderLike) <= len(line); i++ {
+		match := true
+		for j, want := range finderLike {
+			if line[i+j] != want {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		if light(i-4, i) {
+			score += 40
+		}
+		if light(i+7, i+11) {
+			score += 40
+		}
+	}
+	return score
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
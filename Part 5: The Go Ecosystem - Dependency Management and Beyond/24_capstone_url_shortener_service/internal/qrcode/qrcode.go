@@ -0,0 +1,253 @@
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+This is the qrcode package: a small, self-contained QR code encoder, written
+against the QR code standard (ISO/IEC 18004) using only the standard library.
+
+A QR code is a square grid of dark and light MODULES. Building one takes four steps:
+
+ 1. ENCODE the data as a stream of bits. We only use BYTE MODE, which can hold
+    any data (a URL is just bytes), followed by padding up to the capacity of
+    the chosen version.
+ 2. Add ERROR CORRECTION. The bytes are split into blocks and each block gets
+    Reed-Solomon check bytes (reedsolomon.go), so a scanner can recover the
+    data even if part of the code is dirty, torn or covered by a logo.
+ 3. PLACE everything in the grid (matrix.go): first the fixed FUNCTION
+    PATTERNS (the three big finder squares, timing lines, alignment squares),
+    then the data bits, snaking up and down in two-module-wide columns.
+ 4. MASK the data. One of eight fixed patterns is XOR-ed over the data area to
+    avoid shapes that confuse scanners (big blocks of one colour, things that
+    look like finder patterns). We try all eight and keep the one with the
+    lowest penalty score.
+
+The size of the grid is the VERSION: version 1 is 21x21 modules and each
+version adds 4 more. This encoder supports versions 1 to 10 (up to 57x57),
+which holds up to 271 bytes at the lowest error-correction level — plenty for
+a short link.
+*/
+
+// Level is an error-correction level. Higher levels survive more damage but
+// leave less room for data.
+type Level int
+
+const (
+	// Low recovers about 7% of damaged codewords.
+	Low Level = iota
+	// Medium recovers about 15% of damaged codewords.
+	Medium
+	// Quartile recovers about 25% of damaged codewords.
+	Quartile
+	// High recovers about 30% of damaged codewords.
+	High
+)
+
+// MaxVersion is the largest QR code version this package can produce.
+const MaxVersion = 10
+
+// ErrTooLong is returned when the data doesn't fit in a version 10 code.
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// ParseLevel parses an error-correction level written as "L", "M", "Q" or "H".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return Low, nil
+	case "M":
+		return Medium, nil
+	case "Q":
+		return Quartile, nil
+	case "H":
+		return High, nil
+	}
+	return 0, fmt.Errorf("qrcode: unknown error-correction level %q (want L, M, Q or H)", s)
+}
+
+// String returns the one-letter name of the level.
+func (l Level) String() string {
+	switch l {
+	case Low:
+		return "L"
+	case Medium:
+		return "M"
+	case Quartile:
+		return "Q"
+	case High:
+		return "H"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// formatBits is how the level is written into the format information.
+// The order is not the same as the order of the constants, for historical reasons.
+func (l Level) formatBits() int {
+	return [...]int{Low: 1, Medium: 0, Quartile: 3, High: 2}[l]
+}
+
+// Code is an encoded QR code.
+type Code struct {
+	// Version is the symbol version (1 to MaxVersion), and Size the number of
+	// modules along each side: 17 + 4*Version.
+	Version int
+	Size    int
+	Level   Level
+	// Mask is the data mask (0 to 7) that was applied.
+	Mask int
+
+	// modules holds the grid row by row; true is a dark module.
+	modules []bool
+}
+
+// Black reports whether the module in column x and row y is dark.
+// Coordinates outside the grid (in the quiet zone) are light.
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y*c.Size+x]
+}
+
+// Encode encodes data as the smallest QR code that holds it at the given
+// error-correction level, with the mask that gives the lowest penalty.
+func Encode(data []byte, level Level) (*Code, error) {
+	return encode(data, level, -1)
+}
+
+// encode is Encode with a fixed mask, or the best mask if mask is -1.
+func encode(data []byte, level Level, mask int) (*Code, error) {
+	if level < Low || level > High {
+		return nil, fmt.Errorf("qrcode: invalid error-correction level %d", int(level))
+	}
+
+	version := 0
+	for v := 1; v <= MaxVersion; v++ {
+		if dataBits(v, len(data)) <= 8*dataCodewords(v, level) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := addErrorCorrection(dataStream(data, version, level), version, level)
+
+	m := newMatrix(version)
+	m.drawFunctionPatterns()
+	m.placeData(codewords)
+	if mask < 0 {
+		mask = m.bestMask(level)
+	}
+	m.applyMask(mask)
+	m.drawFormat(level, mask)
+
+	return &Code{
+		Version: version,
+		Size:    m.size,
+		Level:   level,
+		Mask:    mask,
+		modules: m.modules,
+	}, nil
+}
+
+// charCountBits is the width of the length field in byte mode.
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// dataBits is the number of bits n bytes take up in byte mode: the 4-bit mode
+// indicator, the length, and 8 bits per byte.
+func dataBits(version, n int) int {
+	return 4 + charCountBits(version) + 8*n
+}
+
+// dataStream builds the data codewords: mode, length, data, terminator and padding.
+func dataStream(data []byte, version int, level Level) []byte {
+	capacity := 8 * dataCodewords(version, level)
+	var bits bitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// Up to four zero bits mark the end of the data, then zeros up to a byte boundary.
+	bits.append(0, min(4, capacity-bits.len()))
+	bits.append(0, (8-bits.len()%8)%8)
+	// Any space left is filled with the alternating pad bytes 11101100 and 00010001.
+	for pad := 0xEC; bits.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes
+}
+
+// addErrorCorrection splits the data codewords into blocks, computes each
+// block's Reed-Solomon codewords, and interleaves everything: the first byte
+// of every block, then the second byte of every block, and so on. Interleaving
+// spreads each block over the whole symbol, so one damaged area doesn't wipe
+// out a single block.
+func addErrorCorrection(data []byte, version int, level Level) []byte {
+	layout := blockLayouts[version][level]
+	gen := generatorPoly(layout.ecPerBlock)
+
+	var blocks, ecBlocks [][]byte
+	for _, group := range layout.groups {
+		for i := 0; i < group.blocks; i++ {
+			block := data[:group.dataPerBlock]
+			data = data[group.dataPerBlock:]
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, gen))
+		}
+	}
+
+	out := make([]byte, 0, totalCodewords(version))
+	for i := 0; ; i++ {
+		wrote := false
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+				wrote = true
+			}
+		}
+		if !wrote {
+			break
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, ec := range ecBlocks {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// bitBuffer is a growing sequence of bits, packed most significant bit first.
+type bitBuffer struct {
+	bytes []byte
+	n     int
+}
+
+func (b *bitBuffer) len() int {
+	return b.n
+}
+
+// append adds the lowest `count` bits of v, most significant first.
+func (b *bitBuffer) append(v, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if v>>i&1 == 1 {
+			b.bytes[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
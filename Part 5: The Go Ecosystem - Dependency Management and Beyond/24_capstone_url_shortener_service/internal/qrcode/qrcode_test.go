@@ -0,0 +1,248 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The files in testdata hold known-good symbols made by another encoder:
+// the encoded text, the mask it chose, then the grid with '#' for dark modules.
+// Different encoders may pick different masks (the penalty rules leave some
+// room for interpretation), so we encode with the same mask and expect the
+// exact same grid.
+func TestGoldenMatrices(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no golden files found: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+			text := lines[0]
+			mask, err := strconv.Atoi(lines[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			grid := lines[2:]
+			// The file name is v<version>-<level>.txt.
+			name := strings.TrimSuffix(filepath.Base(file), ".txt")
+			level, err := ParseLevel(name[strings.IndexByte(name, '-')+1:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, err := encode([]byte(text), level, mask)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.Size != len(grid) {
+				t.Fatalf("Size = %d, want %d", code.Size, len(grid))
+			}
+			wrong := 0
+			for y, row := range grid {
+				for x, ch := range row {
+					if code.Black(x, y) != (ch == '#') {
+						wrong++
+					}
+				}
+			}
+			if wrong > 0 {
+				t.Errorf("%d of %d modules differ from the golden symbol", wrong, code.Size*code.Size)
+			}
+		})
+	}
+}
+
+// TestRoundTrip encodes data of many lengths at every level, then reads it
+// back out of the grid the way a scanner would: format information, unmasking,
+// the zigzag walk, de-interleaving and the Reed-Solomon check.
+func TestRoundTrip(t *testing.T) {
+	for level := Low; level <= High; level++ {
+		for n := 0; ; n += 7 {
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = byte(i*31 + n)
+			}
+			code, err := Encode(data, level)
+			if errors.Is(err, ErrTooLong) {
+				if n <= 100 {
+					t.Errorf("level %v: %d bytes reported as too long", level, n)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decode(code)
+			if err != nil {
+				t.Fatalf("level %v, %d bytes (version %d): %v", level, n, code.Version, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("level %v, %d bytes (version %d): decoded %x, want %x", level, n, code.Version, got, data)
+			}
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(make([]byte, 271), Low); err != nil {
+		t.Errorf("271 bytes at level L: %v", err)
+	}
+	if _, err := Encode(make([]byte, 272), Low); !errors.Is(err, ErrTooLong) {
+		t.Errorf("272 bytes at level L: err = %v, want ErrTooLong", err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"L", "m", "Q", "h"} {
+		level, err := ParseLevel(s)
+		if err != nil || level.String() != strings.ToUpper(s) {
+			t.Errorf("ParseLevel(%q) = %v, %v", s, level, err)
+		}
+	}
+	if _, err := ParseLevel("X"); err == nil {
+		t.Error("ParseLevel(\"X\") succeeded")
+	}
+}
+
+// decode is a minimal QR reader for the symbols this package makes.
+func decode(c *Code) ([]byte, error) {
+	m := newMatrix(c.Version)
+	m.drawFunctionPatterns()
+
+	// Read the first copy of the format information.
+	bits := 0
+	read := func(x, y, i int) {
+		if c.Black(x, y) {
+			bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		read(8, i, i)
+	}
+	read(8, 7, 6)
+	read(8, 8, 7)
+	read(7, 8, 8)
+	for i := 9; i < 15; i++ {
+		read(14-i, 8, i)
+	}
+	bits ^= 0x5412
+	if mask := bits >> 10 & 7; mask != c.Mask {
+		return nil, errors.New("format information has the wrong mask")
+	}
+	if bits>>13 != c.Level.formatBits() {
+		return nil, errors.New("format information has the wrong level")
+	}
+
+	// Unmask the data and walk the same zigzag placeData uses.
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			m.modules[y*c.Size+x] = c.Black(x, y) != (!m.function[y*c.Size+x] && maskFuncs[c.Mask](x, y))
+		}
+	}
+	var stream bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				if !m.function[y*c.Size+right-j] && stream.len() < totalCodewords(c.Version)*8 {
+					b := 0
+					if m.get(right-j, y) {
+						b = 1
+					}
+					stream.append(b, 1)
+				}
+			}
+		}
+	}
+	codewords := stream.bytes
+
+	// De-interleave into blocks and check each block's error correction.
+	layout := blockLayouts[c.Version][c.Level]
+	var blocks [][]byte
+	for _, g := range layout.groups {
+		for i := 0; i < g.blocks; i++ {
+			blocks = append(blocks, make([]byte, 0, g.dataPerBlock+layout.ecPerBlock))
+		}
+	}
+	next := 0
+	for i := 0; ; i++ {
+		wrote := false
+		for b := range blocks {
+			if i < blockDataLen(layout, b) {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+				wrote = true
+			}
+		}
+		if !wrote {
+			break
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+	gen := generatorPoly(layout.ecPerBlock)
+	var data []byte
+	for b, block := range blocks {
+		n := blockDataLen(layout, b)
+		if !bytes.Equal(rsRemainder(block[:n], gen), block[n:]) {
+			return nil, errors.New("Reed-Solomon check failed")
+		}
+		data = append(data, block[:n]...)
+	}
+
+	// Parse the byte-mode segment.
+	var in bitReader
+	in.bytes = data
+	if mode := in.read(4); mode != 0b0100 {
+		return nil, errors.New("not byte mode")
+	}
+	out := make([]byte, in.read(charCountBits(c.Version)))
+	for i := range out {
+		out[i] = byte(in.read(8))
+	}
+	return out, nil
+}
+
+func blockDataLen(layout blockLayout, block int) int {
+	for _, g := range layout.groups {
+		if block < g.blocks {
+			return g.dataPerBlock
+		}
+		block -= g.blocks
+	}
+	return 0
+}
+
+type bitReader struct {
+	bytes []byte
+	pos   int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | int(r.bytes[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
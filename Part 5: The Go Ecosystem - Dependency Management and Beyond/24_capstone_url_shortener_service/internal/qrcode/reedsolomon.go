@@ -0,0 +1,76 @@
+package qrcode
+
+/*
+Reed-Solomon error correction treats a block of bytes as the coefficients of
+a polynomial, and appends the remainder of dividing it by a fixed GENERATOR
+polynomial. A scanner that finds the remainder no longer matches can work out
+which bytes were damaged, and fix up to half as many as there are check bytes.
+
+The arithmetic happens in the finite field GF(256): 256 "numbers" (the byte
+values) where addition and subtraction are both XOR, and multiplication is
+defined by the primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11D).
+Multiplying is easiest with logarithms: every non-zero element is a power of
+the generator α = 2, so a*b = α^(log a + log b). We precompute both tables.
+*/
+
+var gfExp, gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	gfExp[255] = gfExp[0]
+}
+
+// gfMul multiplies two elements of GF(256).
+func gfMul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[a]+gfLog[b])%255]
+}
+
+// generatorPoly returns the coefficients of (x - α^0)(x - α^1)...(x - α^(degree-1)),
+// highest power first, without the leading coefficient (which is always 1).
+func generatorPoly(degree int) []int {
+	poly := make([]int, degree)
+	poly[degree-1] = 1 // start with the polynomial "1"
+	root := 1
+	for i := 0; i < degree; i++ {
+		// Multiply the current polynomial by (x - root).
+		for j := 0; j < degree; j++ {
+			poly[j] = gfMul(poly[j], root)
+			if j+1 < degree {
+				poly[j] ^= poly[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return poly
+}
+
+// rsRemainder returns the error-correction codewords of a block: the
+// remainder of the block (times x^len(gen)) divided by the generator.
+// This is ordinary polynomial long division, with GF(256) arithmetic.
+func rsRemainder(block []byte, gen []int) []byte {
+	rem := make([]int, len(gen))
+	for _, b := range block {
+		factor := int(b) ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range gen {
+			rem[i] ^= gfMul(g, factor)
+		}
+	}
+	out := make([]byte, len(rem))
+	for i, r := range rem {
+		out[i] = byte(r)
+	}
+	return out
+}
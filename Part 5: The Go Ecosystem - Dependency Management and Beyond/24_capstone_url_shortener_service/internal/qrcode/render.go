@@ -0,0 +1,71 @@
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// QuietZone is the light border, in modules, that the standard asks for
+// around a QR code. Scanners use it to find where the code starts.
+const QuietZone = 4
+
+// Image renders the code as a black-and-white image with a border of margin
+// modules. Every module is a square of whole pixels, as many as fit in size
+// pixels, so the image may come out a little smaller than size — or larger,
+// if size is too small to give each module one pixel.
+func (c *Code) Image(size, margin int) *image.Paletted {
+	total := c.Size + 2*margin
+	scale := max(1, size/total)
+
+	palette := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, total*scale, total*scale), palette)
+	for y := 0; y < total; y++ {
+		for x := 0; x < total; x++ {
+			if !c.Black(x-margin, y-margin) {
+				continue // index 0 is already white
+			}
+			for py := y * scale; py < (y+1)*scale; py++ {
+				for px := x * scale; px < (x+1)*scale; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// WritePNG writes the code as a PNG image; see Image for size and margin.
+func (c *Code) WritePNG(w io.Writer, size, margin int) error {
+	return png.Encode(w, c.Image(size, margin))
+}
+
+// WriteSVG writes the code as an SVG image size pixels wide, with a border of
+// margin modules. SVG is a vector format, so it scales to any size without
+// blurring. The dark modules become a single path, one rectangle per run of
+// dark modules in a row.
+func (c *Code) WriteSVG(w io.Writer, size, margin int) error {
+	total := c.Size + 2*margin
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, total, total)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for c.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(bw, "M%d %dh%dv1h-%dz", x+margin, y+margin, run, run)
+			x += run
+		}
+	}
+	fmt.Fprint(bw, `"/></svg>`)
+	return bw.Flush()
+}
@@ -0,0 +1,115 @@
+package qrcode
+
+// The tables below are copied from the QR code standard (ISO/IEC 18004),
+// for versions 1 to MaxVersion.
+
+// blockGroup is a run of error-correction blocks with the same number of data codewords.
+type blockGroup struct {
+	blocks       int
+	dataPerBlock int
+}
+
+// blockLayout describes how the codewords of one version and level are split
+// into blocks. Every block gets ecPerBlock error-correction codewords.
+type blockLayout struct {
+	ecPerBlock int
+	groups     []blockGroup
+}
+
+// blockLayouts is indexed by version, then by Level (Low, Medium, Quartile, High).
+var blockLayouts = [MaxVersion + 1][4]blockLayout{
+	1: {
+		{7, []blockGroup{{1, 19}}},
+		{10, []blockGroup{{1, 16}}},
+		{13, []blockGroup{{1, 13}}},
+		{17, []blockGroup{{1, 9}}},
+	},
+	2: {
+		{10, []blockGroup{{1, 34}}},
+		{16, []blockGroup{{1, 28}}},
+		{22, []blockGroup{{1, 22}}},
+		{28, []blockGroup{{1, 16}}},
+	},
+	3: {
+		{15, []blockGroup{{1, 55}}},
+		{26, []blockGroup{{1, 44}}},
+		{18, []blockGroup{{2, 17}}},
+		{22, []blockGroup{{2, 13}}},
+	},
+	4: {
+		{20, []blockGroup{{1, 80}}},
+		{18, []blockGroup{{2, 32}}},
+		{26, []blockGroup{{2, 24}}},
+		{16, []blockGroup{{4, 9}}},
+	},
+	5: {
+		{26, []blockGroup{{1, 108}}},
+		{24, []blockGroup{{2, 43}}},
+		{18, []blockGroup{{2, 15}, {2, 16}}},
+		{22, []blockGroup{{2, 11}, {2, 12}}},
+	},
+	6: {
+		{18, []blockGroup{{2, 68}}},
+		{16, []blockGroup{{4, 27}}},
+		{24, []blockGroup{{4, 19}}},
+		{28, []blockGroup{{4, 15}}},
+	},
+	7: {
+		{20, []blockGroup{{2, 78}}},
+		{18, []blockGroup{{4, 31}}},
+		{18, []blockGroup{{2, 14}, {4, 15}}},
+		{26, []blockGroup{{4, 13}, {1, 14}}},
+	},
+	8: {
+		{24, []blockGroup{{2, 97}}},
+		{22, []blockGroup{{2, 38}, {2, 39}}},
+		{22, []blockGroup{{4, 18}, {2, 19}}},
+		{26, []blockGroup{{4, 14}, {2, 15}}},
+	},
+	9: {
+		{30, []blockGroup{{2, 116}}},
+		{22, []blockGroup{{3, 36}, {2, 37}}},
+		{20, []blockGroup{{4, 16}, {4, 17}}},
+		{24, []blockGroup{{4, 12}, {4, 13}}},
+	},
+	10: {
+		{18, []blockGroup{{2, 68}, {2, 69}}},
+		{26, []blockGroup{{4, 43}, {1, 44}}},
+		{24, []blockGroup{{6, 19}, {2, 20}}},
+		{28, []blockGroup{{6, 15}, {2, 16}}},
+	},
+}
+
+// alignmentPositions lists, per version, the row and column coordinates of the
+// centres of the alignment patterns.
+var alignmentPositions = [MaxVersion + 1][]int{
+	2:  {6, 18},
+	3:  {6, 22},
+	4:  {6, 26},
+	5:  {6, 30},
+	6:  {6, 34},
+	7:  {6, 22, 38},
+	8:  {6, 24, 42},
+	9:  {6, 26, 46},
+	10: {6, 28, 50},
+}
+
+// dataCodewords returns how many data codewords a version and level hold.
+func dataCodewords(version int, level Level) int {
+	n := 0
+	for _, g := range blockLayouts[version][level].groups {
+		n += g.blocks * g.dataPerBlock
+	}
+	return n
+}
+
+// totalCodewords returns the number of data plus error-correction codewords
+// of a version. It is the same at every level.
+func totalCodewords(version int) int {
+	layout := blockLayouts[version][Low]
+	n := dataCodewords(version, Low)
+	for _, g := range layout.groups {
+		n += g.blocks * layout.ecPerBlock
+	}
+	return n
+}
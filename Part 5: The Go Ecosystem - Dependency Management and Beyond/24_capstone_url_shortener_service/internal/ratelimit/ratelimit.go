@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+This is the ratelimit package. It implements the TOKEN BUCKET algorithm, the
+most common way to rate-limit an API.
+
+Picture every client owning a bucket that holds at most `Burst` tokens. Each
+request takes one token out; a request that finds the bucket empty is
+rejected. Tokens drip back in at a steady `Rate` per second, so:
+  - a client may send a short burst of up to `Burst` requests at once,
+  - but over a longer period it can't average more than `Rate` requests per second.
+
+We don't need a timer to add the tokens. Each bucket remembers how many tokens
+it had and when; on the next request we work out how many have dripped in since
+then. That makes an idle bucket completely free, apart from its memory.
+
+And even that memory can go: a bucket left alone long enough to refill
+completely is indistinguishable from a brand-new one, so Evict can delete it
+without changing anyone's limit. That keeps the map as small as the set of
+recently active clients, however many clients come and go.
+
+Between two runs of Evict, though, a client with many addresses could still
+make us hold a bucket for each. So the map also has a hard cap, MaxBuckets:
+when it is full, a new key pushes out the LEAST RECENTLY USED bucket, found in
+constant time through a list kept in order of use (as in store.CachedStore).
+That bucket's client starts over with a full bucket, which is the price of
+bounded memory; it only matters once far more clients than MaxBuckets are
+active at the same time.
+*/
+
+// DefaultMaxBuckets is the bucket cap of a Limit whose MaxBuckets is 0.
+const DefaultMaxBuckets = 100_000
+
+// Limit describes the size and refill speed of every bucket.
+type Limit struct {
+	// Rate is how many tokens are added per second.
+	Rate float64
+	// Burst is the capacity of the bucket: the most requests allowed at once.
+	Burst int
+	// MaxBuckets is the most buckets the Limiter keeps in memory at once;
+	// 0 means DefaultMaxBuckets.
+	MaxBuckets int
+}
+
+// PerMinute returns a Limit that allows n requests per minute on average,
+// and up to burst at once.
+func PerMinute(n, burst int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Decision is the outcome of one Allow call, with everything needed to fill
+// in the rate-limit response headers.
+type Decision struct {
+	Allowed bool
+	// Limit is the bucket capacity, and Remaining the whole tokens left in it.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait for the next token.
+	RetryAfter time.Duration
+}
+
+// bucket is the state of one client.
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps one token bucket per key (for example per client IP).
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element // of *bucket
+	lru     *list.List               // most recently used at the front
+}
+
+// New creates a Limiter in which every key gets its own bucket of the given size.
+func New(limit Limit) *Limiter {
+	if limit.MaxBuckets <= 0 {
+		limit.MaxBuckets = DefaultMaxBuckets
+	}
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Allow takes one token from the bucket of key, if there is one.
+func (l *Limiter) Allow(key string) Decision {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt is Allow with an explicit clock, which makes the limiter easy to test.
+func (l *Limiter) AllowAt(key string, now time.Time) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var b *bucket
+	if el, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(el)
+		b = el.Value.(*bucket)
+	} else {
+		// A new client starts with a full bucket.
+		b = &bucket{key: key, tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		for l.lru.Len() > l.limit.MaxBuckets {
+			l.remove(l.lru.Back())
+		}
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	d := Decision{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.timeFor(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.timeFor(float64(l.limit.Burst) - b.tokens)
+	return d
+}
+
+// Evict deletes every bucket that would be full by now. Those clients lose
+// nothing: their next request starts with a full bucket either way.
+// It returns the number of buckets removed.
+func (l *Limiter) Evict(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, el := range l.buckets {
+		if l.refill(el.Value.(*bucket), now) >= float64(l.limit.Burst) {
+			l.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+// remove drops a bucket. The caller must hold l.mu.
+func (l *Limiter) remove(el *list.Element) {
+	l.lru.Remove(el)
+	delete(l.buckets, el.Value.(*bucket).key)
+}
+
+// Len returns the number of buckets currently held in memory.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// RunEvictor calls Evict every interval until ctx is cancelled.
+// It blocks, so start it with `go`.
+func (l *Limiter) RunEvictor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			l.Evict(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refill returns how many tokens b holds at the given time.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.limit.Rate)
+}
+
+// timeFor returns how long it takes to drip in the given number of tokens.
+func (l *Limiter) timeFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if l.limit.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,144 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/ratelimit"
+)
+
+var start = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func TestBurstThenRefill(t *testing.T) {
+	// 1 token per second, up to 3 at once.
+	l := ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		d := l.AllowAt("client", start)
+		if !d.Allowed {
+			t.Fatalf("request %d of the burst was rejected", i+1)
+		}
+		if want := 2 - i; d.Remaining != want {
+			t.Errorf("request %d: Remaining = %d; want %d", i+1, d.Remaining, want)
+		}
+	}
+
+	d := l.AllowAt("client", start)
+	if d.Allowed {
+		t.Fatalf("request beyond the burst was allowed")
+	}
+	if d.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v; want 1s", d.RetryAfter)
+	}
+	if d.Reset != 3*time.Second {
+		t.Errorf("Reset = %v; want 3s", d.Reset)
+	}
+
+	// Half a second later there is still no whole token...
+	if d := l.AllowAt("client", start.Add(500*time.Millisecond)); d.Allowed {
+		t.Errorf("request after 0.5s was allowed")
+	}
+	// ...but after one second there is.
+	if d := l.AllowAt("client", start.Add(time.Second)); !d.Allowed {
+		t.Errorf("request after 1s was rejected")
+	}
+}
+
+func TestKeysAreIndependent(t *testing.T) {
+	l := ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 1})
+
+	if !l.AllowAt("alice", start).Allowed {
+		t.Fatalf("first request from alice was rejected")
+	}
+	if l.AllowAt("alice", start).Allowed {
+		t.Errorf("second request from alice was allowed")
+	}
+	if !l.AllowAt("bob", start).Allowed {
+		t.Errorf("bob was limited by alice's requests")
+	}
+}
+
+func TestEvictOnlyRemovesFullBuckets(t *testing.T) {
+	l := ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 5})
+
+	l.AllowAt("idle", start)
+	for i := 0; i < 5; i++ {
+		l.AllowAt("busy", start.Add(3*time.Second))
+	}
+
+	// At start+4s, "idle" has refilled (it only used one token at start),
+	// while "busy" emptied its bucket a second ago and must be kept.
+	if n := l.Evict(start.Add(4 * time.Second)); n != 1 {
+		t.Errorf("Evict() = %d; want 1", n)
+	}
+	if n := l.Len(); n != 1 {
+		t.Errorf("Len() after Evict = %d; want 1", n)
+	}
+	if d := l.AllowAt("busy", start.Add(4*time.Second)); d.Remaining != 0 {
+		t.Errorf("busy bucket lost its state: Remaining = %d; want 0", d.Remaining)
+	}
+}
+
+func TestConcurrentAllow(t *testing.T) {
+	l := ratelimit.New(ratelimit.Limit{Rate: 0.001, Burst: 100})
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if l.AllowAt("shared", start).Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+				l.AllowAt(fmt.Sprint("own", i), start)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// With no time passing, exactly one burst's worth may get through.
+	if allowed != 100 {
+		t.Errorf("%d requests allowed; want exactly 100", allowed)
+	}
+}
+
+func TestMaxBucketsDropsLeastRecentlyUsed(t *testing.T) {
+	l := ratelimit.New(ratelimit.Limit{Rate: 0, Burst: 1, MaxBuckets: 3})
+
+	for _, key := range []string{"a", "b", "c"} {
+		l.AllowAt(key, start)
+	}
+	// "a" is used again, so "b" is now the least recently used bucket.
+	l.AllowAt("a", start)
+	l.AllowAt("d", start)
+	if n := l.Len(); n != 3 {
+		t.Errorf("Len() = %d; want the cap of 3", n)
+	}
+	// "b" was dropped and starts over (pushing out "c" in turn), while "a"
+	// and "d" kept their empty buckets.
+	if !l.AllowAt("b", start).Allowed {
+		t.Errorf("request from the dropped key was rejected; want a fresh bucket")
+	}
+	for _, key := range []string{"a", "d"} {
+		if l.AllowAt(key, start).Allowed {
+			t.Errorf("%s got a fresh bucket; want its empty one", key)
+		}
+	}
+}
+
+func TestDefaultMaxBuckets(t *testing.T) {
+	l := ratelimit.New(ratelimit.Limit{Rate: 0, Burst: 1})
+	for i := range ratelimit.DefaultMaxBuckets + 10 {
+		l.AllowAt(fmt.Sprint(i), start)
+	}
+	if n := l.Len(); n != ratelimit.DefaultMaxBuckets {
+		t.Errorf("Len() = %d; want %d", n, ratelimit.DefaultMaxBuckets)
+	}
+}
@@ -0,0 +1,66 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+Aliases are human-chosen short codes such as "launch-2026". Because they share
+the same namespace as generated codes and live directly under "/", we have to be
+careful about what we accept:
+  - Only URL-safe characters, so the short link never needs escaping.
+  - A sensible length, so nobody can claim a single letter or a whole paragraph.
+  - No RESERVED words, which are paths the service uses (or may use) itself.
+*/
+
+const (
+	// MinAliasLength and MaxAliasLength bound the length of a custom alias.
+	MinAliasLength = 3
+	MaxAliasLength = 64
+
+	// aliasExtraChars are allowed in aliases in addition to the code charset.
+	aliasExtraChars = "-_"
+)
+
+// ErrReservedAlias is returned for aliases that clash with the service's own routes.
+var ErrReservedAlias = errors.New("alias is reserved")
+
+// reservedAliases are compared case-insensitively, so "API" is reserved too.
+var reservedAliases = map[string]bool{
+	"admin":   true,
+	"api":     true,
+	"assets":  true,
+	"health":  true,
+	"healthz": true,
+	"links":   true,
+	"login":   true,
+	"logout":  true,
+	"metrics": true,
+	"static":  true,
+	"status":  true,
+}
+
+// ValidateAlias checks that a custom alias is allowed to be used as a short code.
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("alias must be between %d and %d characters long", MinAliasLength, MaxAliasLength)
+	}
+	for _, r := range alias {
+		if !strings.ContainsRune(charset, r) && !strings.ContainsRune(aliasExtraChars, r) {
+			return fmt.Errorf("alias may only contain letters, digits, '-' and '_' (found %q)", r)
+		}
+	}
+	if IsReserved(alias) {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+// IsReserved reports whether a code clashes with one of the service's own
+// routes. Generated codes are checked too, since "status" is a perfectly
+// valid random six-letter code.
+func IsReserved(code string) bool {
+	return reservedAliases[strings.ToLower(code)]
+}
@@ -0,0 +1,44 @@
+package shortener
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias   string
+		wantErr bool
+	}{
+		{"launch-2026", false},
+		{"go_home", false},
+		{"abc", false},
+		{strings.Repeat("a", MaxAliasLength), false},
+		{"ab", true},
+		{strings.Repeat("a", MaxAliasLength+1), true},
+		{"launch 2026", true},
+		{"a/b/c", true},
+		{"café", true},
+		{"%41bc", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateAlias(tt.alias); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateAlias(%q) = %v; want error: %t", tt.alias, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReservedAliases(t *testing.T) {
+	for _, alias := range []string{"api", "API", "Metrics", "healthz"} {
+		if err := ValidateAlias(alias); !errors.Is(err, ErrReservedAlias) {
+			t.Errorf("ValidateAlias(%q) = %v; want ErrReservedAlias", alias, err)
+		}
+		if !IsReserved(alias) {
+			t.Errorf("IsReserved(%q) = false; want true", alias)
+		}
+	}
+	if IsReserved("apis") {
+		t.Error("IsReserved(\"apis\") = true; want only whole words reserved")
+	}
+}
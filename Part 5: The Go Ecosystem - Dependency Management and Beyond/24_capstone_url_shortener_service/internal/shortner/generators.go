@@ -0,0 +1,109 @@
+package shortener
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+)
+
+// --- Random ---
+
+// RandomGenerator picks every character independently from crypto/rand.
+// Unlike math/rand, crypto/rand can't be predicted from earlier outputs, so
+// nobody can guess the codes of links they weren't given.
+type RandomGenerator struct {
+	minLength int
+}
+
+// NewRandomGenerator creates a RandomGenerator whose codes are at least minLength long.
+func NewRandomGenerator(minLength int) *RandomGenerator {
+	return &RandomGenerator{minLength: minLength}
+}
+
+// Generate returns a random code. The length grows with the number of stored
+// links and, as a safety valve, by one character for every three collisions in a row.
+func (g *RandomGenerator) Generate(req Request) (string, error) {
+	length := LengthFor(req.Links, g.minLength) + req.Attempt/3
+	b := make([]byte, length)
+	// Rejection sampling: a random byte is in [0, 256), and 256 is not a
+	// multiple of 62. Taking `byte % 62` would make the first characters of
+	// the charset slightly more likely, so we throw away bytes >= 248 (= 4*62).
+	const limit = 256 - 256%len(charset)
+	var buf [1]byte
+	for i := range b {
+		for {
+			if _, err := rand.Read(buf[:]); err != nil {
+				return "", fmt.Errorf("read random bytes: %w", err)
+			}
+			if int(buf[0]) < limit {
+				break
+			}
+		}
+		b[i] = charset[int(buf[0])%len(charset)]
+	}
+	return string(b), nil
+}
+
+// --- Sequential ---
+
+// SequentialGenerator hands out an increasing counter in base 62. Codes are
+// as short as possible, but anyone can guess the next one.
+type SequentialGenerator struct {
+	next atomic.Uint64
+}
+
+// NewSequentialGenerator creates a SequentialGenerator whose first code encodes start.
+func NewSequentialGenerator(start uint64) *SequentialGenerator {
+	g := &SequentialGenerator{}
+	g.next.Store(start)
+	return g
+}
+
+// Generate returns the next counter value. A collision (for example with a
+// custom alias) simply moves on to the following value on the next attempt.
+func (g *SequentialGenerator) Generate(req Request) (string, error) {
+	n := g.next.Add(1) - 1
+	return encodeBase62(n, charset), nil
+}
+
+// Resume moves the counter past code, if code is a base-62 number.
+func (g *SequentialGenerator) Resume(code string) {
+	if n, ok := decodeBase62(code, charset); ok && n < math.MaxUint64 {
+		advance(&g.next, n+1)
+	}
+}
+
+// --- Hash ---
+
+// HashGenerator derives the code from a SHA-256 hash of the URL, so the same
+// URL always proposes the same code. Collisions are resolved by PROBING:
+// attempt N hashes the URL together with N, giving a new candidate each time.
+type HashGenerator struct {
+	minLength int
+}
+
+// NewHashGenerator creates a HashGenerator whose codes are at least minLength long.
+func NewHashGenerator(minLength int) *HashGenerator {
+	return &HashGenerator{minLength: minLength}
+}
+
+// Generate returns the truncated hash of the URL for the given attempt.
+func (g *HashGenerator) Generate(req Request) (string, error) {
+	input := req.URL
+	if req.Attempt > 0 {
+		input += "#" + strconv.Itoa(req.Attempt)
+	}
+	sum := sha256.Sum256([]byte(input))
+
+	length := LengthFor(req.Links, g.minLength)
+	code := encodeBase62(binary.BigEndian.Uint64(sum[:8]), charset)
+	// Pad short encodings so every code has the same length.
+	for len(code) < length {
+		code = charset[:1] + code
+	}
+	return code[len(code)-length:], nil
+}
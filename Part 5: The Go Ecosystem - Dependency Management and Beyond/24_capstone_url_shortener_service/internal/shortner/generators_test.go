@@ -0,0 +1,139 @@
+package shortener
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// generate calls Generate and fails the test on an error.
+func generate(t *testing.T, g Generator, req Request) string {
+	t.Helper()
+	code, err := g.Generate(req)
+	if err != nil {
+		t.Fatalf("Generate(%+v): %v", req, err)
+	}
+	return code
+}
+
+func TestNew(t *testing.T) {
+	for _, strategy := range []string{"", "random", "sequential", "hash", "hashids"} {
+		if _, err := New(strategy, Options{}); err != nil {
+			t.Errorf("New(%q) = %v; want a generator", strategy, err)
+		}
+	}
+	if _, err := New("uuid", Options{}); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("New(\"uuid\") = %v; want ErrUnknownStrategy", err)
+	}
+}
+
+func TestLengthFor(t *testing.T) {
+	tests := []struct {
+		links, minLength, want int
+	}{
+		{0, 6, 6},
+		{1000, 6, 6},
+		{int(maxFill*keyspace(6)) + 1, 6, 7},
+		{int(maxFill*keyspace(7)) + 1, 6, 8},
+		{0, 2, 2},
+		{1 << 62, 6, maxCodeLength},
+	}
+	for _, tt := range tests {
+		if got := LengthFor(tt.links, tt.minLength); got != tt.want {
+			t.Errorf("LengthFor(%d, %d) = %d; want %d", tt.links, tt.minLength, got, tt.want)
+		}
+	}
+}
+
+func TestRandomGenerator(t *testing.T) {
+	g := NewRandomGenerator(6)
+	seen := make(map[string]bool)
+	for range 1000 {
+		code := generate(t, g, Request{})
+		if len(code) != 6 || strings.Trim(code, charset) != "" {
+			t.Fatalf("Generate() = %q; want 6 characters of the charset", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 999 {
+		t.Errorf("1000 codes, %d distinct; want (almost) all of them", len(seen))
+	}
+	if code := generate(t, g, Request{Attempt: 3}); len(code) != 7 {
+		t.Errorf("Generate() on attempt 3 = %q; want a longer code", code)
+	}
+}
+
+func TestSequentialGenerator(t *testing.T) {
+	g := NewSequentialGenerator(0)
+	var got []string
+	for range 3 {
+		got = append(got, generate(t, g, Request{}))
+	}
+	if strings.Join(got, " ") != "a b c" {
+		t.Errorf("first codes = %v; want [a b c]", got)
+	}
+
+	g.Resume("qi")     // 16*62 + 8 = 1000
+	g.Resume("c")      // behind the counter: ignored
+	g.Resume("aqi")    // leading zero: not a code of ours
+	g.Resume("my-url") // not base 62
+	if code := generate(t, g, Request{}); code != "qj" {
+		t.Errorf("Generate() after Resume(\"qi\") = %q; want \"qj\"", code)
+	}
+}
+
+func TestHashGenerator(t *testing.T) {
+	g := NewHashGenerator(6)
+	first := generate(t, g, Request{URL: "https://go.dev"})
+	if again := generate(t, g, Request{URL: "https://go.dev"}); again != first {
+		t.Errorf("the same URL got %q and %q; want the same code", first, again)
+	}
+	if retry := generate(t, g, Request{URL: "https://go.dev", Attempt: 1}); retry == first {
+		t.Errorf("attempt 1 got the same code %q as attempt 0", retry)
+	}
+	if code := generate(t, g, Request{URL: "https://go.dev", Links: 1 << 40}); len(code) != LengthFor(1<<40, 6) {
+		t.Errorf("Generate() for a full store = %q; want %d characters", code, LengthFor(1<<40, 6))
+	}
+}
+
+func TestHashidsGenerator(t *testing.T) {
+	g := NewHashidsGenerator("pepper", 6, 0)
+	seen := make(map[string]bool)
+	for n := range uint64(10000) {
+		code := g.Encode(n)
+		if len(code) < 6 || seen[code] {
+			t.Fatalf("Encode(%d) = %q; want a new code of at least 6 characters", n, code)
+		}
+		seen[code] = true
+		if got, ok := g.Decode(code); !ok || got != n {
+			t.Fatalf("Decode(%q) = %d, %t; want %d", code, got, ok, n)
+		}
+	}
+
+	other := NewHashidsGenerator("salt", 6, 0)
+	for _, code := range []string{"", "a", "launch-2026", "aaaaaa", other.Encode(5)} {
+		if n, ok := g.Decode(code); ok {
+			t.Errorf("Decode(%q) = %d; want it rejected", code, n)
+		}
+	}
+
+	g.Resume(g.Encode(500))
+	g.Resume(g.Encode(20))
+	g.Resume(other.Encode(9000))
+	if code := generate(t, g, Request{}); code != g.Encode(501) {
+		t.Errorf("Generate() after Resume = %q; want the code of 501, %q", code, g.Encode(501))
+	}
+}
+
+func TestDecodeBase62(t *testing.T) {
+	for _, n := range []uint64{0, 1, 61, 62, 1000, 1 << 40, 1<<64 - 1} {
+		if got, ok := decodeBase62(encodeBase62(n, charset), charset); !ok || got != n {
+			t.Errorf("decodeBase62(encodeBase62(%d)) = %d, %t", n, got, ok)
+		}
+	}
+	for _, s := range []string{"", "ab", "a-b", "zzzzzzzzzzzz"} {
+		if n, ok := decodeBase62(s, charset); ok {
+			t.Errorf("decodeBase62(%q) = %d; want it rejected", s, n)
+		}
+	}
+}
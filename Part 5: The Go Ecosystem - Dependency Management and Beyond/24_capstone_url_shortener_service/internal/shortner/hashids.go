@@ -0,0 +1,126 @@
+package shortener
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+HashidsGenerator follows the idea behind the popular Hashids library: take a
+plain counter and write it in an alphabet that has been shuffled with a secret
+SALT. The codes are still unique (each number has exactly one encoding), but
+consecutive numbers come out looking unrelated to each other.
+
+This is obfuscation, not encryption: it hides the size and growth rate of the
+service from casual observers, but should not be relied on for security.
+The output is in the style of Hashids, not byte-for-byte compatible with it.
+*/
+
+// HashidsGenerator encodes an increasing counter with a salted alphabet.
+type HashidsGenerator struct {
+	salt     string
+	alphabet string
+	// offset is added to every number to pad codes to the minimum length.
+	offset uint64
+	next   atomic.Uint64
+}
+
+// NewHashidsGenerator creates a HashidsGenerator. Codes are padded to at least
+// minLength characters, and the first one encodes start.
+func NewHashidsGenerator(salt string, minLength int, start uint64) *HashidsGenerator {
+	g := &HashidsGenerator{
+		salt:     salt,
+		alphabet: consistentShuffle(charset, salt),
+	}
+	// To reach the minimum length we add a fixed offset of 62^(minLength-2)
+	// before encoding, which guarantees at least minLength-1 digits after the
+	// lottery character. A decoder simply subtracts it again.
+	if minLength >= 2 {
+		g.offset = 1
+		for i := 0; i < min(minLength, maxCodeLength)-2; i++ {
+			g.offset *= uint64(len(charset))
+		}
+	}
+	g.next.Store(start)
+	return g
+}
+
+// Generate returns the encoding of the next counter value.
+func (g *HashidsGenerator) Generate(req Request) (string, error) {
+	return g.Encode(g.next.Add(1) - 1), nil
+}
+
+// Encode writes n as an obfuscated code.
+func (g *HashidsGenerator) Encode(n uint64) string {
+	// The first character, the "lottery", is picked from the number itself.
+	lottery := g.alphabet[n%uint64(len(g.alphabet))]
+	code := []byte{lottery}
+
+	// Every following digit is written with an alphabet reshuffled by the salt
+	// and the characters written so far. That's why neighbouring numbers, or
+	// repeated digits within one number, end up looking so different. A
+	// decoder can replay the same shuffles, so every code still has exactly
+	// one number behind it.
+	alphabet := g.alphabet
+	for _, digit := range []byte(encodeBase62(n+g.offset, charset)) {
+		alphabet = consistentShuffle(alphabet, g.salt+string(code))
+		code = append(code, alphabet[strings.IndexByte(charset, digit)])
+	}
+	return string(code)
+}
+
+// Decode returns the number behind a code written by Encode. It reports false
+// for anything Encode can't have written with this salt.
+func (g *HashidsGenerator) Decode(code string) (uint64, bool) {
+	if len(code) < 2 {
+		return 0, false
+	}
+	// Replay the shuffles of Encode to turn each character back into a digit.
+	alphabet := g.alphabet
+	digits := make([]byte, 0, len(code)-1)
+	for i := 1; i < len(code); i++ {
+		alphabet = consistentShuffle(alphabet, g.salt+code[:i])
+		j := strings.IndexByte(alphabet, code[i])
+		if j < 0 {
+			return 0, false
+		}
+		digits = append(digits, charset[j])
+	}
+	m, ok := decodeBase62(string(digits), charset)
+	if !ok || m < g.offset {
+		return 0, false
+	}
+	// The lottery character isn't checked above; encoding the number again
+	// checks everything at once.
+	n := m - g.offset
+	if g.Encode(n) != code {
+		return 0, false
+	}
+	return n, true
+}
+
+// Resume moves the counter past code, if code is one of this generator's.
+func (g *HashidsGenerator) Resume(code string) {
+	if n, ok := g.Decode(code); ok && n < math.MaxUint64 {
+		advance(&g.next, n+1)
+	}
+}
+
+// consistentShuffle deterministically shuffles alphabet using key, so the same
+// key always produces the same order. It is a Fisher-Yates shuffle driven by
+// the bytes of the key instead of a random number generator.
+func consistentShuffle(alphabet, key string) string {
+	if key == "" {
+		return alphabet
+	}
+	b := []byte(alphabet)
+	for i, v, p := len(b)-1, 0, 0; i > 0; i, v = i-1, v+1 {
+		v %= len(key)
+		c := int(key[v])
+		p += c
+		j := (c + v + p) % i
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
@@ -1,31 +1,178 @@
 package shortener
 
 import (
-	"math/rand"
-	"time"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync/atomic"
 )
 
 /*
 This is the shortener package. Its sole responsibility is to handle the
 "business logic" of creating a short code. Keeping it separate makes the logic
 reusable and easy to test independently.
+
+There is more than one good way to pick a short code, so the strategy is hidden
+behind the `Generator` interface and chosen in the configuration:
+  - "random":     cryptographically random characters (the default).
+  - "sequential": a counter written in base 62: "1", "2", ... "Z", "10", ...
+  - "hash":       derived from a SHA-256 hash of the URL, so it is deterministic.
+  - "hashids":    a counter, scrambled with a secret salt so codes look random
+                  and don't reveal how many links exist.
+
+A generator only PROPOSES a code. The handler tries to store it and, if the code
+is already taken, asks again with a higher attempt number, up to a fixed limit.
+
+The counters of "sequential" and "hashids" live in memory. After a restart they
+must not start over, or every code they propose would be taken, and requests
+would fail once the attempts run out. Nor can they start from the number of
+links: once links are deleted or expire, that number is below the counter, and
+the generator walks back over codes it has handed out. Instead, both decode
+the codes already in the store and continue after the highest one they find
+(see Resumer).
 */
 
 const (
-	// The character set for generating random short codes.
+	// The character set for short codes. It doubles as the digits of our base-62 numbers.
 	charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	// The length of the generated short codes. 6 characters gives 56.8 billion possibilities.
-	codeLength = 6
+	// DefaultCodeLength is the starting length of random and hashed codes.
+	// 6 characters gives 56.8 billion possibilities.
+	DefaultCodeLength = 6
+	// maxCodeLength caps how far codes may grow; 62^10 still fits in a uint64.
+	maxCodeLength = 10
+	// maxFill is the fraction of the keyspace we allow to be used before codes
+	// get longer. Keeping it low keeps the chance of a random collision low.
+	maxFill = 0.01
 )
 
-var seededRand *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+// ErrUnknownStrategy is returned by New for an unrecognised strategy name.
+var ErrUnknownStrategy = errors.New("unknown code generation strategy")
+
+// Request describes the code the caller needs.
+type Request struct {
+	// URL is the long URL the code will point to.
+	URL string
+	// Attempt is 0 for the first try and goes up by one after every collision.
+	Attempt int
+	// Links is how many links the store currently holds. Generators use it to
+	// grow the code length as the keyspace fills up.
+	Links int
+}
+
+// Generator proposes short codes.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Generator interface {
+	Generate(req Request) (string, error)
+}
+
+// Resumer is implemented by the generators that count. Resume moves the
+// counter past the number behind code, if code is one the generator could
+// have proposed, so that it is never proposed again. The counter never moves
+// back. A custom alias that happens to decode moves the counter too, which
+// only skips some codes.
+type Resumer interface {
+	Resume(code string)
+}
+
+// Options configures the generator built by New.
+type Options struct {
+	// MinLength is the shortest code the random and hash strategies produce,
+	// and the padded length of hashids codes. Zero means DefaultCodeLength.
+	MinLength int
+	// Start is the first counter value for the sequential and hashids
+	// strategies. To continue after a restart, Resume the generator with the
+	// stored codes instead: they may be far apart from the number of links.
+	Start uint64
+	// Salt scrambles hashids codes. Keep it secret and never change it once
+	// codes have been handed out.
+	Salt string
+}
+
+// New builds the generator for the named strategy.
+func New(strategy string, opts Options) (Generator, error) {
+	if opts.MinLength <= 0 {
+		opts.MinLength = DefaultCodeLength
+	}
+	switch strategy {
+	case "", "random":
+		return NewRandomGenerator(opts.MinLength), nil
+	case "sequential":
+		return NewSequentialGenerator(opts.Start), nil
+	case "hash":
+		return NewHashGenerator(opts.MinLength), nil
+	case "hashids":
+		return NewHashidsGenerator(opts.Salt, opts.MinLength, opts.Start), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, strategy)
+}
 
-// GenerateShortCode creates a random string of a fixed length from the charset.
-func GenerateShortCode() string {
-	b := make([]byte, codeLength)
-	for i := range b {
-		// `seededRand.Intn` returns a random integer in [0,n).
-		b[i] = charset[seededRand.Intn(len(charset))]
+// LengthFor returns the code length to use when the store holds `links` links:
+// the smallest length, at least minLength, that keeps the keyspace under maxFill.
+func LengthFor(links, minLength int) int {
+	length := minLength
+	for length < maxCodeLength && float64(links) >= maxFill*keyspace(length) {
+		length++
+	}
+	return length
+}
+
+// keyspace is the number of distinct codes of the given length.
+func keyspace(length int) float64 {
+	n := 1.0
+	for i := 0; i < length; i++ {
+		n *= float64(len(charset))
+	}
+	return n
+}
+
+// advance moves a counter forward to at least n. It never moves it back,
+// even when another goroutine moves it at the same time.
+func advance(counter *atomic.Uint64, n uint64) {
+	for {
+		current := counter.Load()
+		if current >= n || counter.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+// decodeBase62 reads a number written by encodeBase62. It fails for strings
+// encodeBase62 can't have written: empty ones, ones with characters outside
+// the alphabet or with leading zeros, and numbers that don't fit a uint64.
+func decodeBase62(s, alphabet string) (uint64, bool) {
+	if s == "" || (len(s) > 1 && s[0] == alphabet[0]) {
+		return 0, false
+	}
+	base := uint64(len(alphabet))
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(alphabet, s[i])
+		if digit < 0 {
+			return 0, false
+		}
+		hi, lo := bits.Mul64(n, base)
+		sum, carry := bits.Add64(lo, uint64(digit), 0)
+		if hi != 0 || carry != 0 {
+			return 0, false
+		}
+		n = sum
+	}
+	return n, true
+}
+
+// encodeBase62 writes n in base 62 using the given alphabet, most significant digit first.
+func encodeBase62(n uint64, alphabet string) string {
+	if n == 0 {
+		return alphabet[:1]
+	}
+	base := uint64(len(alphabet))
+	var buf [16]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = alphabet[n%base]
+		n /= base
 	}
-	return string(b)
+	return string(buf[i:])
 }
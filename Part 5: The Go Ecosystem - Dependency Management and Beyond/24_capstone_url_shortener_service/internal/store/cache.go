@@ -0,0 +1,297 @@
+package store
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+CachedStore puts a READ-THROUGH CACHE in front of another Store.
+
+With a database backend, every redirect is a round trip to the database, yet
+most traffic goes to a small number of popular links. CachedStore keeps the
+links it has looked up recently in memory: a lookup is answered from the
+cache (a HIT) when it can be, and otherwise from the backend (a MISS), whose
+answer is then kept for next time. Everything else passes straight through.
+
+Four details make it safe to use:
+
+  - The cache is BOUNDED. It holds at most Size entries and, when full, drops
+    the LEAST RECENTLY USED one. Entries live in a doubly linked list, most
+    recently used at the front, and a map finds an entry's list element, so
+    both a lookup and moving an entry to the front take constant time.
+
+  - Entries EXPIRE after a TTL. Writes through the CachedStore remove the
+    entries they affect (INVALIDATION), but nothing can tell it about writes
+    by other processes sharing the same database, such as a second instance
+    of the service. The TTL bounds how long it can keep serving a link that
+    someone else changed.
+
+  - Codes that DON'T EXIST are cached too, for a shorter NegativeTTL. Someone
+    scanning for valid codes would otherwise turn every guess into a database
+    query; a NEGATIVE CACHE answers repeated guesses from memory.
+
+  - Concurrent misses for the same code are COLLAPSED into one backend call
+    (the "singleflight" pattern). When a popular link drops out of the cache,
+    the hundreds of requests that arrive before it is loaded again all wait
+    for the first one's lookup, rather than all hitting the database at once
+    (a "cache stampede").
+
+Invalidation has to deal with one race. A lookup may fetch a link from the
+backend just before a write changes it, and finish just after the write
+invalidated the cache: caching its answer would bring the old link back. So
+a write also marks any lookup in flight for its code as STALE, and a stale
+lookup returns its answer without caching it.
+*/
+
+// CacheOptions configures a CachedStore.
+type CacheOptions struct {
+	// Size is the most entries the cache holds, found or not. It must be positive.
+	Size int
+	// TTL is how long a link stays cached after it was loaded.
+	TTL time.Duration
+	// NegativeTTL is how long a code that doesn't exist is remembered.
+	// Zero turns the negative cache off.
+	NegativeTTL time.Duration
+}
+
+// DefaultCacheOptions returns options for a cache of 10,000 links, kept for
+// a minute, and unknown codes, kept for ten seconds.
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		Size:        10000,
+		TTL:         time.Minute,
+		NegativeTTL: 10 * time.Second,
+	}
+}
+
+// CacheStats are the counters of a CachedStore.
+type CacheStats struct {
+	// Hits are lookups answered with a cached link, and NegativeHits those
+	// answered with a cached ErrNotFound.
+	Hits         uint64
+	NegativeHits uint64
+	// Misses are lookups that went to the backend. Shared are lookups that
+	// waited for another lookup of the same code instead.
+	Misses uint64
+	Shared uint64
+	// Evictions are entries dropped to make room for newer ones.
+	Evictions uint64
+	// Entries is the number of entries in the cache now.
+	Entries int
+}
+
+// CachedStore is a Store that caches the lookups of another Store.
+type CachedStore struct {
+	backend Store
+	opts    CacheOptions
+	now     func() time.Time // time.Now, except in tests
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // code -> element of lru, holding a *cacheEntry
+	lru     *list.List               // most recently used at the front
+	loads   map[string]*load         // lookups in flight, by code
+
+	hits, negativeHits, misses, shared, evictions atomic.Uint64
+}
+
+// cacheEntry is a cached answer to Get(code): a link, or ErrNotFound.
+type cacheEntry struct {
+	code    string
+	link    Link
+	found   bool
+	expires time.Time
+}
+
+// load is a backend lookup in flight. Its result may be read once done is closed.
+type load struct {
+	done  chan struct{}
+	link  Link
+	err   error
+	stale bool // a write changed the code while the lookup ran; guarded by CachedStore.mu
+}
+
+// CachedStore must satisfy the Store interface.
+var _ Store = (*CachedStore)(nil)
+
+// NewCachedStore wraps backend in a cache. Closing the CachedStore closes the backend.
+func NewCachedStore(backend Store, opts CacheOptions) *CachedStore {
+	return &CachedStore{
+		backend: backend,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		loads:   make(map[string]*load),
+	}
+}
+
+// Get returns the link for a code, from the cache if it can.
+func (c *CachedStore) Get(code string) (Link, error) {
+	c.mu.Lock()
+	if el, found := c.entries[code]; found {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			if !e.found {
+				c.negativeHits.Add(1)
+				return Link{}, ErrNotFound
+			}
+			c.hits.Add(1)
+			return e.link, nil
+		}
+		c.removeElement(el)
+	}
+	if l, found := c.loads[code]; found {
+		// Someone is loading this code already: wait for their answer.
+		c.mu.Unlock()
+		c.shared.Add(1)
+		<-l.done
+		return l.link, l.err
+	}
+	l := &load{done: make(chan struct{})}
+	c.loads[code] = l
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	l.link, l.err = c.backend.Get(code)
+
+	c.mu.Lock()
+	if !l.stale {
+		delete(c.loads, code)
+		c.add(code, l.link, l.err)
+	}
+	c.mu.Unlock()
+	close(l.done)
+	return l.link, l.err
+}
+
+// add caches the answer to Get(code). Errors other than ErrNotFound are not
+// cached: the next lookup should try the backend again. The caller must hold c.mu.
+func (c *CachedStore) add(code string, link Link, err error) {
+	e := &cacheEntry{code: code, link: link, found: err == nil}
+	switch {
+	case err == nil:
+		e.expires = c.now().Add(c.opts.TTL)
+	case errors.Is(err, ErrNotFound) && c.opts.NegativeTTL > 0:
+		e.expires = c.now().Add(c.opts.NegativeTTL)
+	default:
+		return
+	}
+	if el, found := c.entries[code]; found {
+		c.removeElement(el)
+	}
+	c.entries[code] = c.lru.PushFront(e)
+	for c.lru.Len() > max(c.opts.Size, 1) {
+		c.removeElement(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// removeElement drops an entry from the cache. The caller must hold c.mu.
+func (c *CachedStore) removeElement(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).code)
+}
+
+// invalidate forgets everything cached about a code, and makes sure that a
+// lookup of it still in flight won't cache its (possibly old) answer.
+func (c *CachedStore) invalidate(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.entries[code]; found {
+		c.removeElement(el)
+	}
+	if l, found := c.loads[code]; found {
+		l.stale = true
+		delete(c.loads, code)
+	}
+}
+
+// The writes go to the backend first and invalidate the cache afterwards, so
+// that a lookup between the two can't cache the old link. They invalidate even
+// when the write fails: a Create that fails with ErrExists, for one, shows
+// that a cached "not found" was wrong.
+
+// Set saves a link and invalidates its code.
+func (c *CachedStore) Set(link Link) error {
+	err := c.backend.Set(link)
+	c.invalidate(link.Code)
+	return err
+}
+
+// Create saves a new link and invalidates its code.
+func (c *CachedStore) Create(link Link) error {
+	err := c.backend.Create(link)
+	c.invalidate(link.Code)
+	return err
+}
+
+// Update replaces a link and invalidates its code.
+func (c *CachedStore) Update(link Link) error {
+	err := c.backend.Update(link)
+	c.invalidate(link.Code)
+	return err
+}
+
+// Delete removes a link and invalidates its code.
+func (c *CachedStore) Delete(code string) error {
+	err := c.backend.Delete(code)
+	c.invalidate(code)
+	return err
+}
+
+// DeleteExpired removes expired links from the backend, and their entries
+// from the cache.
+func (c *CachedStore) DeleteExpired(now time.Time) (int, error) {
+	n, err := c.backend.DeleteExpired(now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*cacheEntry); e.found && e.link.Expired(now) {
+			c.removeElement(el)
+		}
+		el = next
+	}
+	return n, err
+}
+
+// List is passed straight to the backend.
+func (c *CachedStore) List(opts ListOptions) ([]Link, error) {
+	return c.backend.List(opts)
+}
+
+// GetCodeForURL is passed straight to the backend.
+func (c *CachedStore) GetCodeForURL(owner, url string) (string, error) {
+	return c.backend.GetCodeForURL(owner, url)
+}
+
+// Count is passed straight to the backend.
+func (c *CachedStore) Count() (int, error) {
+	return c.backend.Count()
+}
+
+// Close closes the backend.
+func (c *CachedStore) Close() error {
+	return c.backend.Close()
+}
+
+// Stats returns the cache's counters.
+func (c *CachedStore) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Shared:       c.shared.Load(),
+		Evictions:    c.evictions.Load(),
+		Entries:      entries,
+	}
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the Get calls that reach the backend. If gate is set,
+// each Get waits for it to be closed first.
+type countingStore struct {
+	Store
+	gets atomic.Int64
+	gate chan struct{}
+}
+
+func (s *countingStore) Get(code string) (Link, error) {
+	s.gets.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	return s.Store.Get(code)
+}
+
+// newTestCache returns a cache over an in-memory store, and a function that
+// moves the cache's clock forward.
+func newTestCache(opts CacheOptions) (*CachedStore, *countingStore, func(time.Duration)) {
+	backend := &countingStore{Store: NewURLStore()}
+	c := NewCachedStore(backend, opts)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, backend, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCacheHitsAndExpiry(t *testing.T) {
+	c, backend, advance := newTestCache(CacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: 10 * time.Second})
+	c.Set(Link{Code: "abc", URL: "https://go.dev"})
+
+	for range 3 {
+		if link, err := c.Get("abc"); err != nil || link.URL != "https://go.dev" {
+			t.Fatalf("Get() = %+v, %v", link, err)
+		}
+	}
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("backend lookups = %d; want 1", n)
+	}
+
+	// Behind the cache's back, the link changes. Once the TTL is over, the
+	// cache must see it.
+	backend.Store.Update(Link{Code: "abc", URL: "https://pkg.go.dev"})
+	if link, _ := c.Get("abc"); link.URL != "https://go.dev" {
+		t.Errorf("Get() within TTL = %q; want the cached link", link.URL)
+	}
+	advance(time.Minute)
+	if link, _ := c.Get("abc"); link.URL != "https://pkg.go.dev" {
+		t.Errorf("Get() after TTL = %q; want the new link", link.URL)
+	}
+
+	want := CacheStats{Hits: 3, Misses: 2, Entries: 1}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+}
+
+func TestCacheNegativeEntries(t *testing.T) {
+	c, backend, advance := newTestCache(CacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: 10 * time.Second})
+
+	for range 3 {
+		if _, err := c.Get("nope"); err != ErrNotFound {
+			t.Fatalf("Get() error = %v; want ErrNotFound", err)
+		}
+	}
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("backend lookups = %d; want 1", n)
+	}
+	advance(10 * time.Second)
+	c.Get("nope")
+	if n := backend.gets.Load(); n != 2 {
+		t.Errorf("backend lookups after NegativeTTL = %d; want 2", n)
+	}
+
+	// Creating the code must end its negative entry at once.
+	if err := c.Create(Link{Code: "nope", URL: "https://go.dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("nope"); err != nil {
+		t.Errorf("Get() after Create error = %v", err)
+	}
+	if s := c.Stats(); s.NegativeHits != 2 {
+		t.Errorf("NegativeHits = %d; want 2", s.NegativeHits)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	c, _, _ := newTestCache(DefaultCacheOptions())
+	c.Set(Link{Code: "abc", URL: "https://go.dev"})
+	c.Get("abc")
+
+	c.Update(Link{Code: "abc", URL: "https://pkg.go.dev"})
+	if link, _ := c.Get("abc"); link.URL != "https://pkg.go.dev" {
+		t.Errorf("Get() after Update = %q", link.URL)
+	}
+	c.Delete("abc")
+	if _, err := c.Get("abc"); err != ErrNotFound {
+		t.Errorf("Get() after Delete error = %v; want ErrNotFound", err)
+	}
+
+	c.Set(Link{Code: "old", URL: "https://go.dev", ExpiresAt: time.Now().Add(-time.Second)})
+	c.Get("old")
+	c.DeleteExpired(time.Now())
+	if _, err := c.Get("old"); err != ErrNotFound {
+		t.Errorf("Get() after DeleteExpired error = %v; want ErrNotFound", err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, backend, _ := newTestCache(CacheOptions{Size: 2, TTL: time.Minute})
+	for _, code := range []string{"a", "b", "c"} {
+		c.Set(Link{Code: code, URL: "https://go.dev/" + code})
+	}
+	c.Get("a")
+	c.Get("b")
+	c.Get("a") // b is now the least recently used
+	c.Get("c") // ... so it makes room for c
+
+	backend.gets.Store(0)
+	c.Get("a")
+	c.Get("c")
+	if n := backend.gets.Load(); n != 0 {
+		t.Errorf("backend lookups for a and c = %d; want 0", n)
+	}
+	c.Get("b")
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("backend lookups for b = %d; want 1", n)
+	}
+	if s := c.Stats(); s.Evictions != 2 || s.Entries != 2 {
+		t.Errorf("Stats() = %+v; want 2 evictions and 2 entries", s)
+	}
+}
+
+func TestCacheCollapsesConcurrentMisses(t *testing.T) {
+	c, backend, _ := newTestCache(DefaultCacheOptions())
+	c.Set(Link{Code: "abc", URL: "https://go.dev"})
+	backend.gate = make(chan struct{})
+
+	const lookups = 10
+	var wg sync.WaitGroup
+	for range lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if link, err := c.Get("abc"); err != nil || link.URL != "https://go.dev" {
+				t.Errorf("Get() = %+v, %v", link, err)
+			}
+		}()
+	}
+	// Let the lookups pile up behind the first one before it can finish.
+	for c.Stats().Shared < lookups-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.gate)
+	wg.Wait()
+
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("backend lookups = %d; want 1", n)
+	}
+}
+
+func TestCacheDropsLookupRacingAWrite(t *testing.T) {
+	c, backend, _ := newTestCache(DefaultCacheOptions())
+	c.Set(Link{Code: "abc", URL: "https://go.dev"})
+	backend.gate = make(chan struct{})
+
+	// Start a lookup, and change the link while the lookup waits for the
+	// backend. Its answer may be the old link, but it must not be cached.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Get("abc")
+	}()
+	for backend.gets.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Update(Link{Code: "abc", URL: "https://pkg.go.dev"})
+	// Pretend the lookup read the backend before the update did: let it
+	// find the old link, then put the new one back.
+	backend.Store.Update(Link{Code: "abc", URL: "https://go.dev"})
+	close(backend.gate)
+	<-done
+	backend.Store.Update(Link{Code: "abc", URL: "https://pkg.go.dev"})
+
+	if link, _ := c.Get("abc"); link.URL != "https://pkg.go.dev" {
+		t.Errorf("Get() = %q; the racing lookup's answer was cached", link.URL)
+	}
+}
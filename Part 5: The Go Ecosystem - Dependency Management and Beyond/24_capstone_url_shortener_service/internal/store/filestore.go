@@ -0,0 +1,584 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+FileStore makes the in-memory maps durable using two classic database techniques:
+
+ 1. A WRITE-AHEAD LOG (WAL). Before a change is applied to memory, it is appended
+    to `wal.log` as a single line. Appending is cheap, and because we only ever add
+    to the end of the file, a crash can at worst leave one half-written line behind.
+
+ 2. SNAPSHOTS. Replaying a log that grows forever would make startup slower and
+    slower. So every now and then we write the full contents of the store to
+    `snapshot.dat` and empty the log. This is called LOG COMPACTION.
+
+On startup we load the snapshot and then replay the log on top of it. Every line
+carries a CRC-32 checksum, so a torn or corrupted tail left by a crash is detected
+and cut off instead of being loaded as garbage. A crash can only damage the LAST
+line, though: a bad line with good records after it means the file was damaged
+some other way, and cutting it off would throw away every write acknowledged
+after it. OpenFileStore refuses to start instead, and leaves the log alone.
+
+Each line looks like this:
+
+	1c291ca3 {"op":"set","code":"aB3dC9","url":"https://go.dev","created_at":"..."}
+	5e0b2a41 {"op":"delete","code":"aB3dC9","url":""}
+*/
+
+// ErrClosed is returned when writing to a FileStore that has been closed.
+var ErrClosed = errors.New("store: closed")
+
+const (
+	logFileName      = "wal.log"
+	snapshotFileName = "snapshot.dat"
+
+	opSet    = "set"
+	opDelete = "delete"
+)
+
+// SyncPolicy controls when the log is flushed to stable storage with fsync.
+// It is a trade-off between durability and write throughput.
+type SyncPolicy int
+
+const (
+	// SyncAlways calls fsync after every write. Nothing acknowledged is ever lost.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic calls fsync in the background every FileOptions.SyncInterval.
+	// A crash can lose at most that window of writes.
+	SyncPeriodic
+	// SyncNever leaves flushing entirely to the operating system.
+	SyncNever
+)
+
+// FileOptions configures a FileStore.
+type FileOptions struct {
+	// Sync selects the fsync policy for the log.
+	Sync SyncPolicy
+	// SyncInterval is how often the log is fsynced when Sync is SyncPeriodic.
+	SyncInterval time.Duration
+	// SnapshotInterval is how often a snapshot is taken. Zero disables periodic snapshots.
+	SnapshotInterval time.Duration
+	// CompactThreshold compacts the log once it holds this many records. Zero disables it.
+	CompactThreshold int
+	// OnError, if set, is called with errors from background syncs and snapshots,
+	// which have no caller to return them to.
+	OnError func(error)
+}
+
+// DefaultFileOptions returns options that favour durability: every write is
+// fsynced, and the log is compacted every five minutes or every 10,000 writes.
+func DefaultFileOptions() FileOptions {
+	return FileOptions{
+		Sync:             SyncAlways,
+		SyncInterval:     time.Second,
+		SnapshotInterval: 5 * time.Minute,
+		CompactThreshold: 10000,
+	}
+}
+
+// record is a single entry in the log or the snapshot. Embedding Link
+// flattens its fields into the record, so new Link fields are logged
+// automatically and older log lines (which lack them) still decode.
+type record struct {
+	Op string `json:"op"`
+	Link
+}
+
+// FileStore is a durable Store backed by a write-ahead log and periodic snapshots.
+// Reads are served from memory, so they are just as fast as with URLStore.
+type FileStore struct {
+	mem  *URLStore
+	dir  string
+	opts FileOptions
+
+	// mu serialises writers so that log order always matches memory order.
+	mu      sync.Mutex
+	log     *os.File
+	size    int64 // bytes in the log; used to roll back a failed append
+	records int   // records in the log since the last compaction
+	dirty   bool  // true if there are writes that have not been fsynced yet
+	// failed is set when a failed append could not be rolled back. The log
+	// may then hold a record the caller was told had failed, so no more
+	// writes are accepted.
+	failed error
+
+	compactCh chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// FileStore must satisfy the Store interface.
+var _ Store = (*FileStore)(nil)
+
+// OpenFileStore opens (or creates) a FileStore in dir and recovers its contents
+// from the snapshot and log found there.
+func OpenFileStore(dir string, opts FileOptions) (*FileStore, error) {
+	if opts.Sync == SyncPeriodic && opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("store: create data dir: %w", err)
+	}
+
+	s := &FileStore{
+		mem:       NewURLStore(),
+		dir:       dir,
+		opts:      opts,
+		compactCh: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// recover loads the snapshot, replays the log and cuts off any torn tail.
+func (s *FileStore) recover() error {
+	snap, err := os.Open(filepath.Join(s.dir, snapshotFileName))
+	switch {
+	case err == nil:
+		_, _, clean, err := replay(snap, s.apply)
+		snap.Close()
+		if err != nil {
+			return fmt.Errorf("store: read snapshot: %w", err)
+		}
+		// Snapshots are written to a temporary file and renamed into place,
+		// so a damaged one means something other than a crash went wrong.
+		if !clean {
+			return fmt.Errorf("store: snapshot %s is corrupt", snapshotFileName)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("store: open snapshot: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, logFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("store: open log: %w", err)
+	}
+	size, n, clean, err := replay(f, s.apply)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("store: replay log: %w", err)
+	}
+	if !clean {
+		// The last write was interrupted by a crash. It was never acknowledged,
+		// so it is safe to drop it and continue from the last good record.
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return fmt.Errorf("store: truncate torn log: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("store: sync log: %w", err)
+		}
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("store: seek log: %w", err)
+	}
+
+	s.log = f
+	s.size = size
+	s.records = n
+	return nil
+}
+
+// apply executes a single record against the in-memory maps.
+func (s *FileStore) apply(rec record) {
+	switch rec.Op {
+	case opSet:
+		s.mem.Set(rec.Link)
+	case opDelete:
+		s.mem.mu.Lock()
+		s.mem.remove(rec.Code)
+		s.mem.mu.Unlock()
+	}
+}
+
+// Get retrieves the link for a given short code.
+func (s *FileStore) Get(code string) (Link, error) {
+	return s.mem.Get(code)
+}
+
+// GetCodeForURL checks if the owner already has a short code for a given original URL.
+func (s *FileStore) GetCodeForURL(owner, url string) (string, error) {
+	return s.mem.GetCodeForURL(owner, url)
+}
+
+// Count returns the number of stored links.
+func (s *FileStore) Count() (int, error) {
+	return s.mem.Count()
+}
+
+// Set appends the new mapping to the log and then applies it to memory.
+// If the log write fails, memory is left untouched and the error is returned.
+func (s *FileStore) Set(link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(record{Op: opSet, Link: link}); err != nil {
+		return err
+	}
+	return s.mem.Set(link)
+}
+
+// Create is like Set, but fails with ErrExists if the code is already taken.
+func (s *FileStore) Create(link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Holding s.mu means no other writer can claim the code between this
+	// check and the write below.
+	if _, err := s.mem.Get(link.Code); err == nil {
+		return ErrExists
+	}
+	if err := s.append(record{Op: opSet, Link: link}); err != nil {
+		return err
+	}
+	return s.mem.Set(link)
+}
+
+// Update logs the new version of an existing link and applies it to memory.
+func (s *FileStore) Update(link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.mem.Get(link.Code); err != nil {
+		return err
+	}
+	if err := s.append(record{Op: opSet, Link: link}); err != nil {
+		return err
+	}
+	return s.mem.Set(link)
+}
+
+// Delete logs a delete record and removes the link from memory.
+func (s *FileStore) Delete(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.mem.Get(code); err != nil {
+		return err
+	}
+	rec := record{Op: opDelete, Link: Link{Code: code}}
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+// List returns up to opts.Limit links whose codes sort after opts.After.
+func (s *FileStore) List(opts ListOptions) ([]Link, error) {
+	return s.mem.List(opts)
+}
+
+// DeleteExpired logs a delete record for every expired link and then removes
+// them from memory. If a log write fails, the links deleted so far stay deleted.
+func (s *FileStore) DeleteExpired(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, code := range s.mem.expired(now) {
+		rec := record{Op: opDelete, Link: Link{Code: code}}
+		if err := s.append(rec); err != nil {
+			return n, err
+		}
+		s.apply(rec)
+		n++
+	}
+	return n, nil
+}
+
+// append writes one record to the log, honouring the sync policy. The caller must hold s.mu.
+func (s *FileStore) append(rec record) error {
+	if s.log == nil {
+		return ErrClosed
+	}
+	if s.failed != nil {
+		return s.failed
+	}
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(line); err != nil {
+		// Roll back a partial write so the next record doesn't land after garbage.
+		s.rollback()
+		return fmt.Errorf("store: write log: %w", err)
+	}
+	if s.opts.Sync == SyncAlways {
+		if err := s.log.Sync(); err != nil {
+			// The caller is told the write failed, so the record must not
+			// come back on the next replay either.
+			s.rollback()
+			return fmt.Errorf("store: sync log: %w", err)
+		}
+	} else {
+		s.dirty = true
+	}
+	s.size += int64(len(line))
+	s.records++
+
+	if s.opts.CompactThreshold > 0 && s.records >= s.opts.CompactThreshold {
+		// Ask the background goroutine to compact. The channel has a buffer of
+		// one, so if a request is already pending we simply skip this one.
+		select {
+		case s.compactCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// rollback cuts the log back to its size before the current append. If even
+// that fails, the store refuses every later write.
+func (s *FileStore) rollback() {
+	err := s.log.Truncate(s.size)
+	if err == nil {
+		_, err = s.log.Seek(s.size, io.SeekStart)
+	}
+	if err != nil {
+		s.failed = fmt.Errorf("store: log holds a failed write that could not be rolled back: %w", err)
+	}
+}
+
+// Sync flushes any buffered log writes to stable storage.
+func (s *FileStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncLocked()
+}
+
+func (s *FileStore) syncLocked() error {
+	if s.log == nil || !s.dirty {
+		return nil
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("store: sync log: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// Compact writes a snapshot of the current contents and empties the log.
+// Writers wait while it runs; readers are not affected.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return ErrClosed
+	}
+	if s.records == 0 {
+		return nil
+	}
+
+	if err := writeSnapshot(s.dir, s.mem.entries()); err != nil {
+		return err
+	}
+
+	// If we crash between the rename above and the truncate below, recovery
+	// replays the old log over the new snapshot. That is harmless because
+	// replaying a "set" or "delete" twice gives the same result as replaying it once.
+	if err := s.log.Truncate(0); err != nil {
+		return fmt.Errorf("store: truncate log: %w", err)
+	}
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("store: seek log: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("store: sync log: %w", err)
+	}
+	s.size = 0
+	s.records = 0
+	s.dirty = false
+	// The snapshot was written from memory, which never saw a failed write,
+	// and the log that held one is empty now.
+	s.failed = nil
+	return nil
+}
+
+// Close stops background work, flushes the log and closes it.
+func (s *FileStore) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	syncErr := s.syncLocked()
+	closeErr := s.log.Close()
+	s.log = nil
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+// run is the background goroutine that handles periodic syncs, periodic
+// snapshots and threshold-triggered compactions.
+func (s *FileStore) run() {
+	defer s.wg.Done()
+
+	// A nil channel blocks forever in a select, which neatly disables a case.
+	var syncC, snapC <-chan time.Time
+	if s.opts.Sync == SyncPeriodic {
+		t := time.NewTicker(s.opts.SyncInterval)
+		defer t.Stop()
+		syncC = t.C
+	}
+	if s.opts.SnapshotInterval > 0 {
+		t := time.NewTicker(s.opts.SnapshotInterval)
+		defer t.Stop()
+		snapC = t.C
+	}
+
+	for {
+		select {
+		case <-syncC:
+			s.report(s.Sync())
+		case <-snapC:
+			s.report(s.Compact())
+		case <-s.compactCh:
+			s.report(s.Compact())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *FileStore) report(err error) {
+	if err != nil && s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+// encodeRecord formats a record as "<crc32 hex> <json>\n".
+func encodeRecord(rec record) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("store: encode record: %w", err)
+	}
+	line := make([]byte, 0, len(payload)+10)
+	line = fmt.Appendf(line, "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+// decodeRecord parses a line produced by encodeRecord, without the trailing newline.
+func decodeRecord(line []byte) (record, error) {
+	var rec record
+	sum, payload, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return rec, errors.New("missing checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return rec, fmt.Errorf("bad checksum: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != uint32(want) {
+		return rec, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// replay reads records from r and passes each one to apply. It stops at the
+// first incomplete or corrupt line. It returns the byte offset just after the
+// last good record, the number of good records, and whether the whole input
+// was clean. Only the last line may be bad; a bad line followed by more input
+// is an error.
+func replay(r io.Reader, apply func(record)) (offset int64, n int, clean bool, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// A final line without a newline is a write that never finished.
+			return offset, n, len(line) == 0, nil
+		}
+		if err != nil {
+			return offset, n, false, err
+		}
+		rec, decodeErr := decodeRecord(line[:len(line)-1])
+		if decodeErr != nil {
+			if _, err := br.Peek(1); err != io.EOF {
+				if err == nil {
+					err = fmt.Errorf("corrupt record %d at offset %d, followed by more records: %w", n+1, offset, decodeErr)
+				}
+				return offset, n, false, err
+			}
+			return offset, n, false, nil
+		}
+		apply(rec)
+		offset += int64(len(line))
+		n++
+	}
+}
+
+// writeSnapshot atomically replaces the snapshot with the given links.
+// It writes to a temporary file first, fsyncs it and then renames it into
+// place, so readers only ever see a complete old or a complete new snapshot.
+func writeSnapshot(dir string, links []Link) error {
+	tmp, err := os.CreateTemp(dir, snapshotFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("store: create snapshot: %w", err)
+	}
+	// If anything below fails, clean up the temporary file.
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	for _, link := range links {
+		line, err := encodeRecord(record{Op: opSet, Link: link})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return fmt.Errorf("store: write snapshot: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("store: write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("store: sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("store: close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("store: install snapshot: %w", err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir fsyncs a directory so that a rename inside it survives a crash.
+// Not every platform supports syncing a directory, so this is best effort.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileStoreUnrecoverableAppend makes an append fail in a way that can't
+// be rolled back either, and checks that the store then refuses writes until
+// a compaction has replaced the log.
+func TestFileStoreUnrecoverableAppend(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, DefaultFileOptions())
+	if err != nil {
+		t.Fatalf("OpenFileStore() error: %v", err)
+	}
+	defer s.Close()
+	if err := s.Set(Link{Code: "abc123", URL: "https://go.dev"}); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+
+	// A read-only handle fails the write, and the truncate that would undo it.
+	readOnly, err := os.Open(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	s.mu.Lock()
+	log := s.log
+	s.log = readOnly
+	s.mu.Unlock()
+	if err := s.Set(Link{Code: "lost", URL: "https://pkg.go.dev"}); err == nil {
+		t.Fatal("Set() on a read-only log succeeded; want an error")
+	}
+	s.mu.Lock()
+	s.log = log
+	s.mu.Unlock()
+
+	if err := s.Set(Link{Code: "xyz789", URL: "https://go.dev/blog"}); err == nil {
+		t.Error("Set() after a failed rollback succeeded; want the store to refuse writes")
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact() error: %v", err)
+	}
+	if err := s.Set(Link{Code: "xyz789", URL: "https://go.dev/blog"}); err != nil {
+		t.Errorf("Set() after Compact() error: %v", err)
+	}
+	for code, want := range map[string]bool{"abc123": true, "lost": false, "xyz789": true} {
+		if _, err := s.Get(code); (err == nil) != want {
+			t.Errorf("Get(%q) error = %v; want found: %t", code, err, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Expired links stop redirecting as soon as their time is up (the handler checks
+Link.Expired on every lookup), but they still take up space in the store. The
+REAPER is a background goroutine that wakes up on a schedule and purges them.
+
+It is a good example of the standard shape of a long-running goroutine:
+a `for` loop around a `select` that waits for either the next tick of a
+`time.Ticker` or the cancellation of a `context.Context`. Cancelling the context
+is how `main` tells the goroutine to stop during shutdown.
+*/
+
+// RunReaper deletes expired links from s every interval until ctx is cancelled.
+// It blocks, so start it with `go`. After each pass, report (if not nil) is
+// called with the number of links removed and any error.
+func RunReaper(ctx context.Context, s Store, interval time.Duration, report func(purged int, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			n, err := s.DeleteExpired(now)
+			if report != nil {
+				report(n, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
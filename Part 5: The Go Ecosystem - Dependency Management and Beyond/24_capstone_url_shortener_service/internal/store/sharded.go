@@ -0,0 +1,324 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ShardedStore is an in-memory store built for many concurrent requests.
+
+URLStore guards both of its maps with ONE RWMutex. Readers can share it, but
+every write takes it exclusively: while a link is being created, every
+redirect waits, and while redirects hold it, the write waits for all of them.
+Under heavy mixed traffic everything can queue on that one lock, and on many
+cores the lock's own bookkeeping (all cores updating the same reader count)
+can cost more than the map lookups it protects.
+
+ShardedStore splits the data into SHARDS, each a small map with a lock of its
+own. A code's shard is picked by hashing it, so requests for different codes
+almost never meet on the same lock:
+
+	urls:  hash(code)       -> shard -> code -> Link
+	codes: hash(owner, url) -> shard -> (owner, url) -> code
+
+The two indexes are sharded separately, because a link's code and its URL
+hash to unrelated shards. That raises the classic danger of fine-grained
+locking: DEADLOCK, when one goroutine holds lock A and waits for B while
+another holds B and waits for A. Two rules rule it out:
+
+ 1. A write locks the `urls` shard of its code first, and only then a
+    `codes` shard, never the other way round.
+ 2. It never holds two `codes` shards at once, and never more than one
+    `urls` shard.
+
+The `codes` index must sometimes know the rank of the link it points at (see
+Store.Set). Looking that up would mean locking a second `urls` shard, so each
+index entry remembers it instead, and writes to that link keep it up to date.
+For the same reason each `codes` shard keeps a copy of every indexed link for
+its keys: when the link holding an entry goes away, the best of them takes
+over without a look at any `urls` shard.
+
+Whether that wins depends on the machine. On one core nothing runs at the
+same time, so there is no waiting to save, and the extra hashing makes
+ShardedStore a little slower than URLStore. That is why it is opt-in (the
+-memory-shards setting): measure with BenchmarkStores first.
+
+What we give up: List and Count visit the shards one at a time, so while
+writes are going on they see each shard at a slightly different moment
+rather than the whole store at one instant. Every single-link operation is
+exactly as atomic as in URLStore.
+*/
+
+// ShardedStore must satisfy the Store interface.
+var _ Store = (*ShardedStore)(nil)
+
+// ShardedStore is an in-memory Store that spreads its links over many
+// independently locked shards.
+type ShardedStore struct {
+	seed  maphash.Seed
+	mask  uint64
+	urls  []urlShard
+	codes []codeShard
+}
+
+// urlShard is one shard of the code -> link map.
+type urlShard struct {
+	mu   sync.RWMutex
+	urls map[string]Link
+	// The padding keeps neighbouring shards' locks out of the same 64-byte
+	// cache line, so that cores locking different shards don't slow each
+	// other down by fighting over the line ("false sharing").
+	_ [64]byte
+}
+
+// codeShard is one shard of the URL index.
+type codeShard struct {
+	mu    sync.RWMutex
+	codes map[indexKey]indexEntry
+	// links holds every indexed link for each key, by code: the candidates
+	// for the entry in codes when its holder goes away.
+	links map[indexKey]map[string]Link
+	_     [64]byte
+}
+
+// indexEntry is the code the URL index holds for an owner and URL, and the
+// index rank of that code's link.
+type indexEntry struct {
+	code string
+	rank int
+}
+
+// NewShardedStore creates an empty ShardedStore with the given number of
+// shards, rounded up to a power of two. With shards <= 0 it picks four per CPU.
+func NewShardedStore(shards int) *ShardedStore {
+	if shards <= 0 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+	// A power of two lets us pick a shard with a bit mask instead of a division.
+	n := 1 << bits.Len(uint(shards-1))
+	s := &ShardedStore{
+		seed:  maphash.MakeSeed(),
+		mask:  uint64(n - 1),
+		urls:  make([]urlShard, n),
+		codes: make([]codeShard, n),
+	}
+	for i := range s.urls {
+		s.urls[i].urls = make(map[string]Link)
+		s.codes[i].codes = make(map[indexKey]indexEntry)
+		s.codes[i].links = make(map[indexKey]map[string]Link)
+	}
+	return s
+}
+
+// urlShard returns the shard that holds a code.
+func (s *ShardedStore) urlShard(code string) *urlShard {
+	return &s.urls[maphash.String(s.seed, code)&s.mask]
+}
+
+// codeShard returns the shard of the URL index that holds a key.
+func (s *ShardedStore) codeShard(key indexKey) *codeShard {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	h.WriteString(key.owner)
+	h.WriteByte(0)
+	h.WriteString(key.url)
+	return &s.codes[h.Sum64()&s.mask]
+}
+
+// Get retrieves the link for a given short code.
+func (s *ShardedStore) Get(code string) (Link, error) {
+	sh := s.urlShard(code)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	link, found := sh.urls[code]
+	if !found {
+		return Link{}, ErrNotFound
+	}
+	return link, nil
+}
+
+// Set saves a link, replacing any previous link with the same code.
+func (s *ShardedStore) Set(link Link) error {
+	sh := s.urlShard(link.Code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	s.set(sh, link)
+	return nil
+}
+
+// Create saves a new link, failing with ErrExists if its code is already taken.
+func (s *ShardedStore) Create(link Link) error {
+	sh := s.urlShard(link.Code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, taken := sh.urls[link.Code]; taken {
+		return ErrExists
+	}
+	s.set(sh, link)
+	return nil
+}
+
+// Update replaces an existing link, failing with ErrNotFound if it doesn't exist.
+func (s *ShardedStore) Update(link Link) error {
+	sh := s.urlShard(link.Code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, found := sh.urls[link.Code]; !found {
+		return ErrNotFound
+	}
+	s.set(sh, link)
+	return nil
+}
+
+// Delete removes a link and its index entry.
+func (s *ShardedStore) Delete(code string) error {
+	sh := s.urlShard(code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, found := sh.urls[code]; !found {
+		return ErrNotFound
+	}
+	s.remove(sh, code)
+	return nil
+}
+
+// set writes a link and updates the URL index the same way URLStore.set
+// does. The caller must hold the write lock of sh, the link's shard.
+func (s *ShardedStore) set(sh *urlShard, link Link) {
+	key := link.indexKey()
+	if old, found := sh.urls[link.Code]; found && (old.indexKey() != key || !link.Indexed()) {
+		s.unindex(old)
+	}
+	sh.urls[link.Code] = link
+	if !link.Indexed() {
+		return
+	}
+
+	cs := s.codeShard(key)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.links[key] == nil {
+		cs.links[key] = make(map[string]Link)
+	}
+	cs.links[key][link.Code] = link
+	current, indexed := cs.codes[key]
+	switch {
+	case !indexed, link.indexRank() > current.rank:
+		cs.codes[key] = indexEntry{code: link.Code, rank: link.indexRank()}
+	case current.code == link.Code:
+		// The indexed link itself changed; its rank may have too.
+		cs.codes[key] = indexEntry{code: link.Code, rank: link.indexRank()}
+	}
+}
+
+// remove deletes a code from its shard and from the URL index. The caller
+// must hold the write lock of sh, the code's shard.
+func (s *ShardedStore) remove(sh *urlShard, code string) {
+	link, found := sh.urls[code]
+	if !found {
+		return
+	}
+	delete(sh.urls, code)
+	s.unindex(link)
+}
+
+// unindex takes a link out of the URL index. If it held the entry for its
+// owner and URL, the best remaining link takes it over, as in URLStore. The
+// caller must hold the write lock of link's shard.
+func (s *ShardedStore) unindex(link Link) {
+	key := link.indexKey()
+	cs := s.codeShard(key)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.links[key], link.Code)
+	if len(cs.links[key]) == 0 {
+		delete(cs.links, key)
+	}
+	if cs.codes[key].code != link.Code {
+		return
+	}
+	delete(cs.codes, key)
+	var best Link
+	for _, candidate := range cs.links[key] {
+		if best.Code == "" || outranks(candidate, best) {
+			best = candidate
+		}
+	}
+	if best.Code != "" {
+		cs.codes[key] = indexEntry{code: best.Code, rank: best.indexRank()}
+	}
+}
+
+// GetCodeForURL returns the code the owner already has for a URL.
+func (s *ShardedStore) GetCodeForURL(owner, url string) (string, error) {
+	key := indexKey{owner: owner, url: url}
+	cs := s.codeShard(key)
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	entry, found := cs.codes[key]
+	if !found {
+		return "", ErrNotFound
+	}
+	return entry.code, nil
+}
+
+// List returns up to opts.Limit links whose codes sort after opts.After.
+// The codes are spread over all shards, so it has to visit every one of them.
+func (s *ShardedStore) List(opts ListOptions) ([]Link, error) {
+	var links []Link
+	for i := range s.urls {
+		sh := &s.urls[i]
+		sh.mu.RLock()
+		for code, link := range sh.urls {
+			if code > opts.After && (opts.Owner == "" || link.Owner == opts.Owner) {
+				links = append(links, link)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Code < links[j].Code })
+	if len(links) > opts.Limit {
+		links = links[:opts.Limit]
+	}
+	return links, nil
+}
+
+// DeleteExpired removes every link that has expired at the given time,
+// one shard at a time.
+func (s *ShardedStore) DeleteExpired(now time.Time) (int, error) {
+	n := 0
+	for i := range s.urls {
+		sh := &s.urls[i]
+		sh.mu.Lock()
+		for code, link := range sh.urls {
+			if link.Expired(now) {
+				s.remove(sh, code)
+				n++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return n, nil
+}
+
+// Count returns the number of stored links.
+func (s *ShardedStore) Count() (int, error) {
+	n := 0
+	for i := range s.urls {
+		sh := &s.urls[i]
+		sh.mu.RLock()
+		n += len(sh.urls)
+		sh.mu.RUnlock()
+	}
+	return n, nil
+}
+
+// Close is a no-op; there is nothing to release.
+func (s *ShardedStore) Close() error {
+	return nil
+}
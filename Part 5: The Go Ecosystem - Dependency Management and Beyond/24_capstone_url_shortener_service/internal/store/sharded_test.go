@@ -0,0 +1,127 @@
+package store
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// randomOp applies one random write to s, drawn from a small set of codes,
+// owners and URLs so that operations often touch the same links and index
+// entries.
+func randomOp(s Store, rng *rand.Rand, now time.Time) {
+	link := Link{
+		Code:  fmt.Sprintf("c%d", rng.IntN(40)),
+		URL:   fmt.Sprintf("https://example.com/%d", rng.IntN(6)),
+		Owner: []string{"", "k1"}[rng.IntN(2)],
+	}
+	if rng.IntN(3) == 0 {
+		link.ExpiresAt = now.Add(time.Duration(rng.IntN(3)-1) * time.Hour)
+	}
+	switch rng.IntN(6) {
+	case 0, 1:
+		s.Set(link)
+	case 2:
+		s.Create(link)
+	case 3:
+		s.Update(link)
+	case 4:
+		s.Delete(link.Code)
+	case 5:
+		s.DeleteExpired(now)
+	}
+}
+
+// TestShardedStoreMatchesURLStore applies the same random writes to a
+// ShardedStore and a URLStore and checks that they end up agreeing on every
+// link and every index entry.
+func TestShardedStoreMatchesURLStore(t *testing.T) {
+	now := time.Now()
+	for seed := range uint64(20) {
+		sharded, plain := NewShardedStore(4), NewURLStore()
+		a, b := rand.New(rand.NewPCG(seed, 1)), rand.New(rand.NewPCG(seed, 1))
+		for range 500 {
+			randomOp(sharded, a, now)
+			randomOp(plain, b, now)
+		}
+
+		for i := range 40 {
+			code := fmt.Sprintf("c%d", i)
+			got, gotErr := sharded.Get(code)
+			want, wantErr := plain.Get(code)
+			if !reflect.DeepEqual(got, want) || gotErr != wantErr {
+				t.Fatalf("seed %d: Get(%q) = %+v, %v; URLStore has %+v, %v", seed, code, got, gotErr, want, wantErr)
+			}
+		}
+		for _, owner := range []string{"", "k1"} {
+			for i := range 6 {
+				url := fmt.Sprintf("https://example.com/%d", i)
+				got, gotErr := sharded.GetCodeForURL(owner, url)
+				want, wantErr := plain.GetCodeForURL(owner, url)
+				if got != want || gotErr != wantErr {
+					t.Fatalf("seed %d: GetCodeForURL(%q, %q) = %q, %v; URLStore has %q, %v", seed, owner, url, got, gotErr, want, wantErr)
+				}
+			}
+		}
+	}
+}
+
+// TestShardedStoreConcurrent hammers the store from many goroutines at once
+// and then checks that the two indexes agree. Run it with -race: the race
+// detector reports any access that the shard locks fail to guard.
+func TestShardedStoreConcurrent(t *testing.T) {
+	s := NewShardedStore(4)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(w), 2))
+			for range 2000 {
+				if rng.IntN(2) == 0 {
+					randomOp(s, rng, now)
+					continue
+				}
+				s.Get(fmt.Sprintf("c%d", rng.IntN(40)))
+				s.GetCodeForURL("", fmt.Sprintf("https://example.com/%d", rng.IntN(6)))
+				if rng.IntN(50) == 0 {
+					s.List(ListOptions{Limit: 10})
+					s.Count()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every index entry must lead to a link with that owner and URL, and
+	// know the link's rank correctly.
+	for i := range s.codes {
+		for key, entry := range s.codes[i].codes {
+			link, err := s.Get(entry.code)
+			if err != nil || link.indexKey() != key || link.indexRank() != entry.rank {
+				t.Errorf("index entry %+v -> %+v, but the link is %+v, %v", key, entry, link, err)
+			}
+		}
+	}
+	// Every indexed link must be a candidate for its entry, with a copy as
+	// current as promotion needs, and its key must have an entry.
+	links, _ := s.List(ListOptions{Limit: 100})
+	for _, link := range links {
+		if !link.Indexed() {
+			continue
+		}
+		cs := s.codeShard(link.indexKey())
+		candidate, found := cs.links[link.indexKey()][link.Code]
+		if !found || candidate.indexRank() != link.indexRank() || !candidate.CreatedAt.Equal(link.CreatedAt) {
+			t.Errorf("candidate for %q is %+v; want %+v", link.Code, candidate, link)
+		}
+		if _, found := cs.codes[link.indexKey()]; !found {
+			t.Errorf("no index entry for %+v, although %q is indexed", link.indexKey(), link.Code)
+		}
+	}
+}
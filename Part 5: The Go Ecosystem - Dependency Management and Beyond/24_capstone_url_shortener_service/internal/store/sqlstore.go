@@ -0,0 +1,544 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+SQLStore keeps the links in a relational database through Go's `database/sql`
+package. `database/sql` is only an interface: the actual driver is registered by
+importing it, usually with a blank import in `main`. That keeps this file free
+of any third-party dependency.
+
+The schema mirrors the two maps of URLStore:
+  - urls:  code -> url  (primary key on code)
+  - codes: (owner, url) -> code (UNIQUE index on owner and url, which keeps
+    GetCodeForURL idempotent for each owner)
+
+The variants of a split link are kept as a JSON array in a column of urls,
+rather than in a table of their own: they are always read and written
+together with their link, and never searched.
+
+The schema is created and upgraded by MIGRATIONS: a numbered list of SQL steps.
+The `schema_migrations` table remembers which steps have already run, so on
+every startup we only apply the ones that are new. Never edit a migration that
+has shipped; add a new one instead.
+*/
+
+// Dialect identifies the SQL flavour spoken by the database. The queries are
+// written for SQLite, the only dialect so far; another database would need a
+// Dialect of its own, and tests against a real server.
+type Dialect string
+
+// DialectSQLite is used for SQLite databases.
+const DialectSQLite Dialect = "sqlite"
+
+// migration is one versioned step of the schema.
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+// migrations lists every schema change in order. Versions must keep increasing.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create urls and codes",
+		stmts: []string{
+			`CREATE TABLE urls (
+				code TEXT PRIMARY KEY,
+				url  TEXT NOT NULL
+			)`,
+			`CREATE TABLE codes (
+				url  TEXT NOT NULL,
+				code TEXT NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX codes_url_idx ON codes (url)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "add link timestamps",
+		stmts: []string{
+			`ALTER TABLE urls ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE urls ADD COLUMN expires_at TIMESTAMP`,
+			`CREATE INDEX urls_expires_at_idx ON urls (expires_at)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "add link owners",
+		stmts: []string{
+			`ALTER TABLE urls ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE codes ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+			// The URL index becomes an index per owner.
+			`DROP INDEX codes_url_idx`,
+			`CREATE UNIQUE INDEX codes_owner_url_idx ON codes (owner, url)`,
+			`CREATE INDEX urls_owner_code_idx ON urls (owner, code)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "add interstitial pages",
+		stmts: []string{
+			`ALTER TABLE urls ADD COLUMN interstitial_seconds INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 5,
+		name:    "add redirect settings",
+		stmts: []string{
+			`ALTER TABLE urls ADD COLUMN redirect_status INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE urls ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE urls ADD COLUMN utm_template TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 6,
+		name:    "add link passwords",
+		stmts: []string{
+			`ALTER TABLE urls ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 7,
+		name:    "add split destinations",
+		stmts: []string{
+			`ALTER TABLE urls ADD COLUMN variants TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE urls ADD COLUMN sticky TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version: 8,
+		name:    "index links by owner and URL",
+		stmts: []string{
+			// Lets promoteSQL find the other links for a URL without a full scan.
+			`CREATE INDEX urls_owner_url_idx ON urls (owner, url)`,
+		},
+	},
+}
+
+// SQLStore is a Store backed by a SQL database.
+type SQLStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// SQLStore must satisfy the Store interface.
+var _ Store = (*SQLStore)(nil)
+
+// OpenSQLStore wraps an open database handle and brings its schema up to date.
+// The store takes ownership of db and closes it in Close.
+func OpenSQLStore(db *sql.DB, dialect Dialect) (*SQLStore, error) {
+	switch dialect {
+	case DialectSQLite:
+		// SQLite allows only one writer at a time. A single connection makes
+		// database/sql queue writers for us instead of failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	default:
+		return nil, fmt.Errorf("store: unsupported SQL dialect %q", dialect)
+	}
+
+	s := &SQLStore{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies every migration newer than the database's current version.
+// Each migration runs in its own transaction, so a failure leaves the schema at
+// the last fully applied version.
+func (s *SQLStore) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("store: create schema_migrations: %w", err)
+	}
+
+	var current int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("store: read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("store: migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+		m.version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get retrieves the link for a given short code.
+func (s *SQLStore) Get(code string) (Link, error) {
+	row := s.db.QueryRow(`SELECT `+linkColumns+` FROM urls WHERE code = ?`, code)
+	link, err := scanLink(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, ErrNotFound
+	}
+	if err != nil {
+		return Link{}, fmt.Errorf("store: get %q: %w", code, err)
+	}
+	return link, nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// linkColumns are the columns of urls that scanLink reads, in its order.
+const linkColumns = `code, url, created_at, expires_at, owner, interstitial_seconds,
+	redirect_status, forward_query, utm_template, password_hash, variants, sticky`
+
+// scanLink reads the linkColumns into a Link.
+func scanLink(row scanner) (Link, error) {
+	var link Link
+	var createdAt, expiresAt sql.NullTime
+	var variants string
+	if err := row.Scan(&link.Code, &link.URL, &createdAt, &expiresAt, &link.Owner, &link.InterstitialSeconds,
+		&link.RedirectStatus, &link.ForwardQuery, &link.UTMTemplate, &link.PasswordHash, &variants, &link.Sticky); err != nil {
+		return Link{}, err
+	}
+	link.CreatedAt = createdAt.Time
+	link.ExpiresAt = expiresAt.Time
+	if variants != "" {
+		if err := json.Unmarshal([]byte(variants), &link.Variants); err != nil {
+			return Link{}, fmt.Errorf("variants of %q: %w", link.Code, err)
+		}
+	}
+	return link, nil
+}
+
+// encodeVariants is the value of the variants column: a JSON array, or ""
+// for a link that isn't split.
+func encodeVariants(variants []Variant) (string, error) {
+	if len(variants) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(variants)
+	return string(b), err
+}
+
+// Set saves a link, replacing any previous link with the same code.
+func (s *SQLStore) Set(link Link) error {
+	return s.write(link, `INSERT INTO urls (url, created_at, expires_at, owner, interstitial_seconds,
+		redirect_status, forward_query, utm_template, password_hash, variants, sticky, code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (code) DO UPDATE SET
+			url = excluded.url, created_at = excluded.created_at,
+			expires_at = excluded.expires_at, owner = excluded.owner,
+			interstitial_seconds = excluded.interstitial_seconds,
+			redirect_status = excluded.redirect_status, forward_query = excluded.forward_query,
+			utm_template = excluded.utm_template, password_hash = excluded.password_hash,
+			variants = excluded.variants, sticky = excluded.sticky`, nil)
+}
+
+// Create saves a new link, failing with ErrExists if its code is already taken.
+func (s *SQLStore) Create(link Link) error {
+	return s.write(link, `INSERT INTO urls (url, created_at, expires_at, owner, interstitial_seconds,
+		redirect_status, forward_query, utm_template, password_hash, variants, sticky, code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (code) DO NOTHING`, ErrExists)
+}
+
+// Update replaces an existing link, failing with ErrNotFound if it doesn't exist.
+func (s *SQLStore) Update(link Link) error {
+	return s.write(link, `UPDATE urls SET url = ?, created_at = ?, expires_at = ?, owner = ?,
+		interstitial_seconds = ?, redirect_status = ?, forward_query = ?, utm_template = ?,
+		password_hash = ?, variants = ?, sticky = ? WHERE code = ?`, ErrNotFound)
+}
+
+// write runs the given statement against urls and then updates the codes index.
+// The statement receives url, created_at, expires_at, owner, interstitial_seconds,
+// redirect_status, forward_query, utm_template, password_hash, variants, sticky
+// and code, in that order.
+// If it affects no rows, write fails with noRows (when not nil).
+// Both tables are written in one transaction so they can never disagree.
+func (s *SQLStore) write(link Link, stmt string, noRows error) error {
+	variants, err := encodeVariants(link.Variants)
+	if err != nil {
+		return fmt.Errorf("store: write %q: %w", link.Code, err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("store: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Remember where the link used to be indexed, in case it leaves the entry.
+	var old indexKey
+	err = tx.QueryRow(`SELECT owner, url FROM urls WHERE code = ?`, link.Code).Scan(&old.owner, &old.url)
+	existed := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("store: write %q: %w", link.Code, err)
+	}
+
+	res, err := tx.Exec(stmt, link.URL, nullTime(link.CreatedAt), nullTime(link.ExpiresAt), link.Owner, link.InterstitialSeconds,
+		link.RedirectStatus, link.ForwardQuery, link.UTMTemplate, link.PasswordHash, variants, link.Sticky, link.Code)
+	if err != nil {
+		return fmt.Errorf("store: write %q: %w", link.Code, err)
+	}
+	if noRows != nil {
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return noRows
+		}
+	}
+
+	// If this code used to point at a different URL (or belong to a different
+	// owner), or may no longer be indexed, drop the old index entry.
+	if link.Indexed() {
+		_, err = tx.Exec(`DELETE FROM codes WHERE code = ? AND (url <> ? OR owner <> ?)`,
+			link.Code, link.URL, link.Owner)
+	} else {
+		_, err = tx.Exec(`DELETE FROM codes WHERE code = ?`, link.Code)
+	}
+	if err != nil {
+		return fmt.Errorf("store: unindex %q: %w", link.Code, err)
+	}
+	if existed {
+		if err := promote(tx, old); err != nil {
+			return err
+		}
+	}
+
+	// The first code an owner stores for a URL keeps the index entry, unless
+	// the new link ranks higher (see Link.indexRank).
+	if link.Indexed() {
+		index := `INSERT INTO codes (owner, url, code) VALUES (?, ?, ?)
+			ON CONFLICT (owner, url) DO UPDATE SET code = excluded.code
+			WHERE ? > (SELECT ` + indexRankSQL + ` FROM urls WHERE urls.code = codes.code)`
+		if _, err := tx.Exec(index, link.Owner, link.URL, link.Code, link.indexRank()); err != nil {
+			return fmt.Errorf("store: index %q: %w", link.Code, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: commit: %w", err)
+	}
+	return nil
+}
+
+// indexRankSQL computes Link.indexRank from the columns of a urls row.
+const indexRankSQL = `(CASE WHEN expires_at IS NULL THEN 2 ELSE 0 END) +
+	(CASE WHEN interstitial_seconds = 0 AND redirect_status IN (0, 302)
+		AND NOT forward_query AND utm_template = '' THEN 1 ELSE 0 END)`
+
+// promoteSQL hands an owner's URL that has lost its index entry to the best
+// of its remaining indexed links, ranked the same way as outranks. It leaves
+// an existing entry alone.
+const promoteSQL = `INSERT INTO codes (owner, url, code)
+	SELECT owner, url, code FROM urls
+	WHERE owner = ? AND url = ? AND password_hash = '' AND variants = ''
+	ORDER BY ` + indexRankSQL + ` DESC, created_at, code
+	LIMIT 1
+	ON CONFLICT (owner, url) DO NOTHING`
+
+// promote runs promoteSQL for one owner and URL.
+func promote(tx *sql.Tx, key indexKey) error {
+	if _, err := tx.Exec(promoteSQL, key.owner, key.url); err != nil {
+		return fmt.Errorf("store: reindex %q: %w", key.url, err)
+	}
+	return nil
+}
+
+// Delete removes a link and its index entry, which passes to the owner's
+// best remaining link for the same URL.
+func (s *SQLStore) Delete(code string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("store: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	var key indexKey
+	err = tx.QueryRow(`SELECT owner, url FROM urls WHERE code = ?`, code).Scan(&key.owner, &key.url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("store: delete %q: %w", code, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM codes WHERE code = ?`, code); err != nil {
+		return fmt.Errorf("store: unindex %q: %w", code, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM urls WHERE code = ?`, code); err != nil {
+		return fmt.Errorf("store: delete %q: %w", code, err)
+	}
+	if err := promote(tx, key); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("store: commit: %w", err)
+	}
+	return nil
+}
+
+// List returns up to opts.Limit links whose codes sort after opts.After. The
+// primary key on code (or the index on owner and code) lets the database jump
+// straight to the right place.
+func (s *SQLStore) List(opts ListOptions) ([]Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE code > ?`
+	args := []any{opts.After}
+	if opts.Owner != "" {
+		query += ` AND owner = ?`
+		args = append(args, opts.Owner)
+	}
+	query += ` ORDER BY code LIMIT ?`
+	args = append(args, opts.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("store: list: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]Link, 0)
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("store: list: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: list: %w", err)
+	}
+	return links, nil
+}
+
+// GetCodeForURL checks if the owner already has a short code for a given original URL.
+func (s *SQLStore) GetCodeForURL(owner, url string) (string, error) {
+	var code string
+	err := s.db.QueryRow(`SELECT code FROM codes WHERE owner = ? AND url = ?`, owner, url).Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("store: get code for URL: %w", err)
+	}
+	return code, nil
+}
+
+// DeleteExpired removes every link that has expired at the given time,
+// together with any index entries pointing at them. Each of those entries
+// passes to the best link left for its owner and URL.
+func (s *SQLStore) DeleteExpired(now time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("store: begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	lost, err := expiredEntries(tx, now)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`DELETE FROM codes WHERE code IN (
+		SELECT code FROM urls WHERE expires_at IS NOT NULL AND expires_at <= ?
+	)`, now)
+	if err != nil {
+		return 0, fmt.Errorf("store: delete expired index entries: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM urls WHERE expires_at IS NOT NULL AND expires_at <= ?`, now)
+	if err != nil {
+		return 0, fmt.Errorf("store: delete expired links: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("store: delete expired links: %w", err)
+	}
+	for _, key := range lost {
+		if err := promote(tx, key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store: commit: %w", err)
+	}
+	return int(n), nil
+}
+
+// expiredEntries returns the owner and URL of every index entry held by a
+// link that has expired at the given time.
+func expiredEntries(tx *sql.Tx, now time.Time) ([]indexKey, error) {
+	rows, err := tx.Query(`SELECT codes.owner, codes.url FROM codes
+		JOIN urls ON urls.code = codes.code
+		WHERE urls.expires_at IS NOT NULL AND urls.expires_at <= ?`, now)
+	if err != nil {
+		return nil, fmt.Errorf("store: find expired index entries: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []indexKey
+	for rows.Next() {
+		var key indexKey
+		if err := rows.Scan(&key.owner, &key.url); err != nil {
+			return nil, fmt.Errorf("store: find expired index entries: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("store: find expired index entries: %w", err)
+	}
+	return keys, nil
+}
+
+// Count returns the number of stored links.
+func (s *SQLStore) Count() (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM urls`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("store: count: %w", err)
+	}
+	return n, nil
+}
+
+// Close closes the underlying database handle.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// nullTime converts a time to a value for a nullable TIMESTAMP column. The
+// zero time becomes NULL, and everything else is stored in UTC so that
+// timestamps compare correctly even in databases that store them as text.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
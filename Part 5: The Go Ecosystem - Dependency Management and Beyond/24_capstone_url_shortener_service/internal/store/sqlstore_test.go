@@ -0,0 +1,73 @@
+//go:build sqlite
+
+package store_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // Registers the "sqlite3" driver with database/sql.
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store/storetest"
+)
+
+/*
+These tests need the SQLite driver, a cgo module, so like cmd/urlshortener/sqlite.go
+they are only compiled with `-tags sqlite`:
+
+	go test -tags sqlite ./internal/store/...
+*/
+
+// TestSQLStore runs the conformance suite against SQLite in a temporary file.
+func TestSQLStore(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open:    openSQLStore,
+		Durable: true,
+	})
+}
+
+// TestSQLStoreMigrationsAreIdempotent opens the same database twice and checks
+// that the second open doesn't try to re-apply the schema.
+func TestSQLStoreMigrationsAreIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	openSQLStore(t, dir).Close()
+	first := countMigrations(t, dir)
+	if first == 0 {
+		t.Fatal("no migrations recorded after first open")
+	}
+
+	openSQLStore(t, dir).Close()
+	if second := countMigrations(t, dir); second != first {
+		t.Errorf("schema_migrations has %d rows after reopening; want %d", second, first)
+	}
+}
+
+func countMigrations(t *testing.T, dir string) int {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&n); err != nil {
+		t.Fatalf("reading schema_migrations: %v", err)
+	}
+	return n
+}
+
+func openSQLStore(t *testing.T, dir string) store.Store {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error: %v", err)
+	}
+	s, err := store.OpenSQLStore(db, store.DialectSQLite)
+	if err != nil {
+		db.Close()
+		t.Fatalf("OpenSQLStore() error: %v", err)
+	}
+	return s
+}
@@ -1,14 +1,226 @@
 package store
 
-import "sync"
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
 /*
 This is the store package. It is responsible for all data persistence logic.
 By keeping data access logic separate, we can easily swap out the storage
 backend in the future (e.g., from in-memory to a database like Redis or
 PostgreSQL) without changing our HTTP handlers.
+
+The `Store` interface below is the contract every backend fulfils. The handlers
+only ever talk to that interface, so `main` decides which implementation to use:
+  - URLStore:     the original in-memory maps. Fast, but forgets everything on restart.
+  - ShardedStore: in-memory too, split into shards with a lock each (see sharded.go).
+  - FileStore:    the same maps, made durable with an append-only log and snapshots.
+  - CachedStore:  not a backend itself, but a cache in front of one (see cache.go).
 */
 
+// ErrNotFound is returned when a lookup does not match any stored entry.
+var ErrNotFound = errors.New("store: not found")
+
+// ErrExists is returned by Create when the short code is already taken.
+var ErrExists = errors.New("store: code already exists")
+
+// Link is a single short link and the metadata stored alongside it.
+type Link struct {
+	Code      string    `json:"code"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	// ExpiresAt is the moment the link stops working. The zero value means never.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// Owner is the ID of the API key that created the link. Links created
+	// without a key have no owner.
+	Owner string `json:"owner,omitempty"`
+	// InterstitialSeconds, if above zero, makes the link show a preview page
+	// that counts down this many seconds before redirecting.
+	InterstitialSeconds int `json:"interstitial_seconds,omitempty"`
+	// RedirectStatus is the HTTP status visitors are redirected with: 301,
+	// 302, 307 or 308. Zero means the default, 302 Found.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+	// ForwardQuery passes the query string of the short URL on to the
+	// destination, merged with the destination's own query.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// UTMTemplate is a query string such as "utm_source=mail&utm_campaign={code}"
+	// whose parameters are added to the destination on every redirect.
+	UTMTemplate string `json:"utm_template,omitempty"`
+	// PasswordHash, if set, makes visitors enter a password before they are
+	// redirected. It is a salted PBKDF2 hash, never the password itself
+	// (see the password package).
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Variants, if set, split the link's traffic between several weighted
+	// destinations, for A/B tests. URL is then the first variant's URL.
+	// Sticky says how a visitor keeps getting the same variant on every
+	// click: "cookie", "ip", or "" to choose afresh on every click.
+	Variants []Variant `json:"variants,omitempty"`
+	Sticky   string    `json:"sticky,omitempty"`
+}
+
+// Variant is one destination of a split link.
+type Variant struct {
+	// Name identifies the variant in the click statistics, e.g. "A".
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Weight is the variant's share of the traffic, relative to the others:
+	// weights 1 and 1 split it evenly, 3 and 1 send 75% to the first.
+	Weight int `json:"weight"`
+}
+
+// Expired reports whether the link has an expiry time that is not after now.
+func (l Link) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !l.ExpiresAt.After(now)
+}
+
+// Permanent reports whether the link never expires.
+func (l Link) Permanent() bool {
+	return l.ExpiresAt.IsZero()
+}
+
+// ValidateRedirectStatus checks the RedirectStatus setting of a link. The
+// checks live here, next to Link, because every way into the store needs
+// them: the handlers, and imports of exported links.
+func ValidateRedirectStatus(status int) error {
+	switch status {
+	case 0, 301, 302, 307, 308:
+		return nil
+	}
+	return errors.New("redirect_status must be 301, 302, 307 or 308")
+}
+
+// ValidateUTMTemplate checks the UTMTemplate setting of a link.
+func ValidateUTMTemplate(template string) error {
+	params, err := url.ParseQuery(template)
+	if err != nil {
+		return errors.New("utm_template must be a query string, like utm_source=newsletter")
+	}
+	for name := range params {
+		if !strings.HasPrefix(name, "utm_") {
+			return errors.New("utm_template may only set utm_* parameters, not " + name)
+		}
+	}
+	return nil
+}
+
+// ListOptions selects a page of links for Store.List.
+type ListOptions struct {
+	// After is the code the page starts after. Use "" for the first page.
+	After string
+	// Limit is the maximum number of links returned.
+	Limit int
+	// Owner, if not empty, restricts the page to the links owned by that API key.
+	Owner string
+}
+
+// indexKey is the key of the URL -> code index. Each owner has their own
+// index, so two customers shortening the same URL never share a code.
+type indexKey struct {
+	owner string
+	url   string
+}
+
+func (l Link) indexKey() indexKey {
+	return indexKey{owner: l.Owner, url: l.URL}
+}
+
+// Indexed reports whether the link may be found through the URL index.
+// Password-protected links never are: their visitors need the password, so
+// a request that finds one by URL could not be answered with it anyway. Nor
+// are split links, whose URL is only their first variant's: a request for
+// that URL alone doesn't want a split link.
+func (l Link) Indexed() bool {
+	return l.PasswordHash == "" && len(l.Variants) == 0
+}
+
+// indexRank decides which of an owner's links for a URL the index holds: a
+// link takes the entry over from one with a lower rank. Permanent links beat
+// expiring ones, because an idempotent request should not hand out a link
+// that will die. After that, links that redirect with the default settings
+// beat customised ones, because a plain request for the URL must find a
+// plain link, or it would create a new one every time.
+func (l Link) indexRank() int {
+	rank := 0
+	if l.Permanent() {
+		rank += 2
+	}
+	if l.InterstitialSeconds == 0 && (l.RedirectStatus == 0 || l.RedirectStatus == 302) &&
+		!l.ForwardQuery && l.UTMTemplate == "" {
+		rank++
+	}
+	return rank
+}
+
+// outranks reports whether link a should take over the index entry from b
+// when the link that held it is removed: the higher rank wins, then the older
+// link, then the smaller code, so every backend promotes the same one.
+func outranks(a, b Link) bool {
+	if ra, rb := a.indexRank(), b.indexRank(); ra != rb {
+		return ra > rb
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.Code < b.Code
+}
+
+// Store is the interface implemented by every storage backend.
+// Lookups return errors (rather than a plain `found` boolean) so that backends
+// which talk to a disk or a database can report failures to the caller.
+type Store interface {
+	// Get retrieves the link for a given short code. Expired links that have
+	// not been purged yet are still returned; check Link.Expired.
+	// It returns ErrNotFound if the code does not exist.
+	Get(code string) (Link, error)
+
+	// Set saves a link, replacing any previous link with the same code.
+	// If the owner has no code for the URL yet, this code becomes the one
+	// returned by GetCodeForURL; otherwise the existing one is kept, unless
+	// this link ranks higher: it is permanent where the existing one expires,
+	// or it has the default redirect settings where the existing one doesn't.
+	// Links that are not Indexed are never returned by GetCodeForURL.
+	Set(link Link) error
+
+	// Create is like Set, but fails with ErrExists if the code is already taken.
+	// The check and the write happen atomically, so two concurrent requests can
+	// never both claim the same code.
+	Create(link Link) error
+
+	// Update replaces an existing link, keeping the URL index consistent when
+	// the target URL changes. It returns ErrNotFound if the code does not exist.
+	Update(link Link) error
+
+	// Delete removes a link. It returns ErrNotFound if the code does not exist.
+	// If the link held the URL index entry, the owner's best remaining link
+	// for the same URL takes it over.
+	Delete(code string) error
+
+	// List returns up to opts.Limit links whose codes sort after opts.After,
+	// in ascending code order. Because the position is a code rather than a
+	// page number, paging stays stable even while links are added or removed.
+	List(opts ListOptions) ([]Link, error)
+
+	// GetCodeForURL returns the short code the given owner already has for a
+	// URL. It returns ErrNotFound if the owner has not shortened the URL yet.
+	GetCodeForURL(owner, url string) (string, error)
+
+	// DeleteExpired removes every link that has expired at the given time
+	// and reports how many were removed.
+	DeleteExpired(now time.Time) (int, error)
+
+	// Count returns the number of stored links, including expired links
+	// that have not been purged yet.
+	Count() (int, error)
+
+	// Close releases any resources (files, connections) held by the backend.
+	Close() error
+}
+
 // URLStore holds the data for our URL shortener and provides safe concurrent access.
 type URLStore struct {
 	// We use an RWMutex (Read-Write Mutex). It allows multiple "readers" (redirects)
@@ -16,44 +228,239 @@ type URLStore struct {
 	// This is a performance optimization since our service will have many more reads than writes.
 	mu sync.RWMutex
 
-	// urls maps a short code (e.g., "aB3dC") to its original, long URL.
-	urls map[string]string
+	// urls maps a short code (e.g., "aB3dC") to its link: the original, long URL
+	// plus metadata such as its expiry time.
+	urls map[string]Link
 
-	// codes maps an original, long URL to its already-generated short code.
+	// codes maps an owner and an original, long URL to its already-generated short code.
 	// This makes our creation endpoint IDEMPOTENT: creating a short link for the
 	// same long URL twice will return the same short code.
-	codes map[string]string
+	// A URL can have several codes (e.g. a random one and a custom alias); this
+	// index remembers the FIRST one, so the answer doesn't change. The only
+	// exception is a link of a higher rank (see Link.indexRank), which replaces
+	// it: a permanent link replaces one that expires, and a plain link one
+	// with custom redirect settings. When the link holding the entry goes
+	// away, the best of the others (see outranks) takes its place.
+	codes map[indexKey]string
+
+	// links holds the codes of every indexed link for each owner and URL: the
+	// candidates for the entry in codes when its holder goes away.
+	links map[indexKey]map[string]struct{}
 }
 
+// URLStore must satisfy the Store interface. This line fails to compile if it doesn't.
+var _ Store = (*URLStore)(nil)
+
 // NewURLStore is a constructor function that creates and returns a new, initialized URLStore.
 func NewURLStore() *URLStore {
 	return &URLStore{
-		urls:  make(map[string]string),
-		codes: make(map[string]string),
+		urls:  make(map[string]Link),
+		codes: make(map[indexKey]string),
+		links: make(map[indexKey]map[string]struct{}),
 	}
 }
 
-// Get retrieves the original URL for a given short code.
-// It returns the URL and a boolean indicating if the code was found.
-func (s *URLStore) Get(code string) (string, bool) {
+// Get retrieves the link for a given short code.
+// It returns ErrNotFound if the code does not exist.
+func (s *URLStore) Get(code string) (Link, error) {
 	s.mu.RLock() // Acquire a read lock. Multiple goroutines can hold a read lock.
 	defer s.mu.RUnlock()
-	url, found := s.urls[code]
-	return url, found
+	link, found := s.urls[code]
+	if !found {
+		return Link{}, ErrNotFound
+	}
+	return link, nil
 }
 
-// Set saves a new short code and its corresponding original URL to the store.
-func (s *URLStore) Set(code, url string) {
+// Set saves a link to the store, replacing any previous link with the same code.
+// The in-memory store cannot fail, so the returned error is always nil.
+func (s *URLStore) Set(link Link) error {
 	s.mu.Lock() // Acquire a write lock. Only one goroutine can hold a write lock.
 	defer s.mu.Unlock()
-	s.urls[code] = url
-	s.codes[url] = code
+	s.set(link)
+	return nil
+}
+
+// Create saves a new link, failing with ErrExists if its code is already taken.
+func (s *URLStore) Create(link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.urls[link.Code]; taken {
+		return ErrExists
+	}
+	s.set(link)
+	return nil
 }
 
-// GetCodeForURL checks if a short code already exists for a given original URL.
-func (s *URLStore) GetCodeForURL(url string) (string, bool) {
+// Update replaces an existing link, failing with ErrNotFound if it doesn't exist.
+func (s *URLStore) Update(link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.urls[link.Code]; !found {
+		return ErrNotFound
+	}
+	s.set(link)
+	return nil
+}
+
+// Delete removes a link from both maps.
+func (s *URLStore) Delete(code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.urls[code]; !found {
+		return ErrNotFound
+	}
+	s.remove(code)
+	return nil
+}
+
+// List returns up to opts.Limit links whose codes sort after opts.After.
+// Maps have no order, so we collect the matching codes and sort them. That is
+// fine for an in-memory store of modest size; a database does this with an index.
+func (s *URLStore) List(opts ListOptions) ([]Link, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	code, found := s.codes[url]
-	return code, found
+	codes := make([]string, 0)
+	for code, link := range s.urls {
+		if code > opts.After && (opts.Owner == "" || link.Owner == opts.Owner) {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	if len(codes) > opts.Limit {
+		codes = codes[:opts.Limit]
+	}
+	links := make([]Link, len(codes))
+	for i, code := range codes {
+		links[i] = s.urls[code]
+	}
+	return links, nil
+}
+
+// set writes both maps. The caller must hold the write lock.
+func (s *URLStore) set(link Link) {
+	// If this code used to point at a different URL (or belong to a different
+	// owner), or may no longer be indexed, the old index entry must no longer
+	// lead here.
+	key := link.indexKey()
+	if old, found := s.urls[link.Code]; found && (old.indexKey() != key || !link.Indexed()) {
+		s.unindex(old)
+	}
+	s.urls[link.Code] = link
+	if !link.Indexed() {
+		return
+	}
+	if s.links[key] == nil {
+		s.links[key] = make(map[string]struct{})
+	}
+	s.links[key][link.Code] = struct{}{}
+	current, indexed := s.codes[key]
+	if !indexed || link.indexRank() > s.urls[current].indexRank() {
+		s.codes[key] = link.Code
+	}
+}
+
+// remove deletes a code from both maps. The caller must hold the write lock.
+func (s *URLStore) remove(code string) {
+	link, found := s.urls[code]
+	if !found {
+		return
+	}
+	delete(s.urls, code)
+	s.unindex(link)
+}
+
+// unindex takes a link out of the URL index. If it held the entry for its
+// owner and URL, the best remaining link takes it over. The caller must hold
+// the write lock.
+func (s *URLStore) unindex(link Link) {
+	key := link.indexKey()
+	delete(s.links[key], link.Code)
+	if len(s.links[key]) == 0 {
+		delete(s.links, key)
+	}
+	if s.codes[key] != link.Code {
+		return
+	}
+	delete(s.codes, key)
+	var best Link
+	for code := range s.links[key] {
+		if candidate := s.urls[code]; best.Code == "" || outranks(candidate, best) {
+			best = candidate
+		}
+	}
+	if best.Code != "" {
+		s.codes[key] = best.Code
+	}
+}
+
+// GetCodeForURL checks if the owner already has a short code for a given original URL.
+// It returns ErrNotFound if the owner has not shortened the URL yet.
+func (s *URLStore) GetCodeForURL(owner, url string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	code, found := s.codes[indexKey{owner: owner, url: url}]
+	if !found {
+		return "", ErrNotFound
+	}
+	return code, nil
+}
+
+// DeleteExpired removes every link that has expired at the given time.
+func (s *URLStore) DeleteExpired(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	// Deleting from a map while ranging over it is safe in Go.
+	for code, link := range s.urls {
+		if link.Expired(now) {
+			s.remove(code)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Count returns the number of stored links.
+func (s *URLStore) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.urls), nil
+}
+
+// expired returns the codes of every link that has expired at the given time.
+func (s *URLStore) expired(now time.Time) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var codes []string
+	for code, link := range s.urls {
+		if link.Expired(now) {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// Close is a no-op for the in-memory store; there is nothing to release.
+func (s *URLStore) Close() error {
+	return nil
+}
+
+// entries returns a copy of every link. The FileStore uses it to write
+// snapshots without holding the lock while it talks to the disk.
+// The codes held by the URL index come first, so that replaying the links in
+// order through Set rebuilds exactly the same index.
+func (s *URLStore) entries() []Link {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Link, 0, len(s.urls))
+	var rest []Link
+	for code, link := range s.urls {
+		if s.codes[link.indexKey()] == code {
+			out = append(out, link)
+		} else {
+			rest = append(rest, link)
+		}
+	}
+	return append(out, rest...)
 }
@@ -0,0 +1,89 @@
+package store_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+These benchmarks compare the in-memory stores under concurrent load, the way
+a busy server uses them: mostly redirects (Get), some lookups before creating
+a link (GetCodeForURL), and a share of writes (Set).
+
+	go test -run '^$' -bench Stores -cpu 1,4,8 ./internal/store/
+
+b.RunParallel runs the benchmark body on GOMAXPROCS goroutines at once (set
+with -cpu), each taking operations until b.N have been done in total. With
+enough cores, the single-lock URLStore may get SLOWER per operation as
+goroutines are added, because they queue on its lock; the ShardedStore is
+meant to keep up. On few cores, though, the extra hashing can make it the
+slower one (on one core it is), which is why main keeps URLStore as the
+in-memory default. Run this on the machine that will run the service before
+setting -memory-shards.
+*/
+
+const (
+	benchLinks = 100_000
+	benchURLs  = 10_000
+)
+
+func BenchmarkStores(b *testing.B) {
+	backends := []struct {
+		name string
+		open func() store.Store
+	}{
+		{"URLStore", func() store.Store { return store.NewURLStore() }},
+		{"ShardedStore", func() store.Store { return store.NewShardedStore(0) }},
+	}
+	mixes := []struct {
+		name   string
+		writes int // percent of operations that are writes
+	}{
+		{"reads100", 0},
+		{"reads90", 10},
+		{"reads50", 50},
+	}
+
+	// Build every string up front, so that the benchmarks time the stores
+	// rather than fmt.Sprintf.
+	codes := make([]string, benchLinks)
+	for i := range codes {
+		codes[i] = fmt.Sprintf("c%07d", i)
+	}
+	urls := make([]string, benchURLs)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	for _, mix := range mixes {
+		for _, backend := range backends {
+			b.Run(mix.name+"/"+backend.name, func(b *testing.B) {
+				s := backend.open()
+				for i, code := range codes {
+					s.Set(store.Link{Code: code, URL: urls[i%benchURLs]})
+				}
+				var seed atomic.Uint64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					// Each goroutine needs a generator of its own: a shared
+					// one would be a point of contention in itself.
+					rng := rand.New(rand.NewPCG(seed.Add(1), 0))
+					for pb.Next() {
+						i := rng.IntN(benchLinks)
+						switch op := rng.IntN(100); {
+						case op < mix.writes:
+							s.Set(store.Link{Code: codes[i], URL: urls[rng.IntN(benchURLs)]})
+						case op < mix.writes+(100-mix.writes)/10:
+							s.GetCodeForURL("", urls[i%benchURLs])
+						default:
+							s.Get(codes[i])
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store/storetest"
+)
+
+// TestURLStore runs the conformance suite against the in-memory store.
+func TestURLStore(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Store {
+			return store.NewURLStore()
+		},
+	})
+}
+
+// TestShardedStore runs the conformance suite against the sharded in-memory
+// store, with few shards so that the tests also cover links sharing a shard.
+func TestShardedStore(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Store {
+			return store.NewShardedStore(4)
+		},
+	})
+}
+
+// TestCachedStore runs the conformance suite against an in-memory store
+// behind a cache, so every write is also a test of the cache's invalidation.
+func TestCachedStore(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Store {
+			return store.NewCachedStore(store.NewURLStore(), store.DefaultCacheOptions())
+		},
+	})
+}
+
+// TestFileStore runs the conformance suite against the write-ahead-log store.
+func TestFileStore(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Open: func(t *testing.T, dir string) store.Store {
+			return openFileStore(t, dir)
+		},
+		Durable: true,
+	})
+}
+
+// TestFileStoreTornWrite simulates a crash in the middle of writing a log
+// record and checks that recovery keeps everything before it.
+func TestFileStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	if err := s.Set(store.Link{Code: "abc123", URL: "https://go.dev"}); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	s.Close()
+
+	// Append half a record, as if the process died mid-write.
+	f, err := os.OpenFile(filepath.Join(dir, "wal.log"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`0badc0de {"op":"set","code":"torn`)
+	f.Close()
+
+	s = openFileStore(t, dir)
+	defer s.Close()
+	if got, err := s.Get("abc123"); err != nil || got.URL != "https://go.dev" {
+		t.Errorf("Get() after torn write = %q, %v; want %q", got.URL, err, "https://go.dev")
+	}
+	// New writes must land after the last good record, not after the garbage.
+	if err := s.Set(store.Link{Code: "xyz789", URL: "https://pkg.go.dev"}); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	s.Close()
+
+	s = openFileStore(t, dir)
+	defer s.Close()
+	if _, err := s.Get("xyz789"); err != nil {
+		t.Errorf("Get() of record written after recovery: %v", err)
+	}
+}
+
+// TestFileStoreCorruptRecord damages a record in the middle of the log and
+// checks that opening the store fails, rather than cutting off the good
+// records after it.
+func TestFileStoreCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	s.Set(store.Link{Code: "abc123", URL: "https://go.dev"})
+	s.Set(store.Link{Code: "xyz789", URL: "https://pkg.go.dev"})
+	s.Close()
+
+	path := filepath.Join(dir, "wal.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 1 // flip a bit of the first record's checksum
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.OpenFileStore(dir, store.DefaultFileOptions()); err == nil {
+		t.Fatal("OpenFileStore() with a corrupt record mid-log succeeded; want an error")
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(data) {
+		t.Errorf("log is %d bytes after the failed open; want it untouched at %d", len(after), len(data))
+	}
+}
+
+// TestFileStoreCompact checks that data survives a compaction followed by
+// more writes and a restart.
+func TestFileStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	s.Set(store.Link{Code: "abc123", URL: "https://go.dev"})
+	s.Set(store.Link{Code: "golang", URL: "https://go.dev"})
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact() error: %v", err)
+	}
+	s.Set(store.Link{Code: "xyz789", URL: "https://pkg.go.dev"})
+	s.Close()
+
+	info, err := os.Stat(filepath.Join(dir, "snapshot.dat"))
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("snapshot missing or empty after Compact(): %v", err)
+	}
+
+	s = openFileStore(t, dir)
+	defer s.Close()
+	for _, code := range []string{"abc123", "golang", "xyz789"} {
+		if _, err := s.Get(code); err != nil {
+			t.Errorf("Get(%q) after compaction and restart: %v", code, err)
+		}
+	}
+	// The snapshot must preserve which code owns the URL index.
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "abc123" {
+		t.Errorf("GetCodeForURL() after compaction and restart = %q, %v; want %q", got, err, "abc123")
+	}
+}
+
+func openFileStore(t *testing.T, dir string) *store.FileStore {
+	t.Helper()
+	s, err := store.OpenFileStore(dir, store.DefaultFileOptions())
+	if err != nil {
+		t.Fatalf("OpenFileStore() error: %v", err)
+	}
+	return s
+}
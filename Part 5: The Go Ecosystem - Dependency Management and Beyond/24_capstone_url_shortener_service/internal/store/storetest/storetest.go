@@ -0,0 +1,689 @@
+// Package storetest is a conformance test suite for store.Store implementations.
+//
+// Every storage backend must behave the same way from the handler's point of
+// view. Instead of copying the same tests for each backend, each backend's test
+// file calls Run with a small Backend description, and this package exercises
+// the full contract. When the Store interface grows, the suite grows with it,
+// and every backend is checked automatically.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+// Backend describes the storage implementation under test.
+type Backend struct {
+	// Open returns a store whose data lives in dir. The suite passes a fresh
+	// temporary directory to each test. Backends without on-disk state ignore it.
+	Open func(t *testing.T, dir string) store.Store
+
+	// Durable backends promise that closing a store and calling Open again
+	// with the same dir returns the same data.
+	Durable bool
+}
+
+// Run executes the whole conformance suite against the backend.
+func Run(t *testing.T, b Backend) {
+	t.Run("GetMissing", func(t *testing.T) { testGetMissing(t, b) })
+	t.Run("SetAndGet", func(t *testing.T) { testSetAndGet(t, b) })
+	t.Run("GetCodeForURL", func(t *testing.T) { testGetCodeForURL(t, b) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, b) })
+	t.Run("Create", func(t *testing.T) { testCreate(t, b) })
+	t.Run("FirstCodeKeepsIndex", func(t *testing.T) { testFirstCodeKeepsIndex(t, b) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, b) })
+	t.Run("PermanentReplacesExpiringInIndex", func(t *testing.T) { testPermanentReplacesExpiringInIndex(t, b) })
+	t.Run("PlainReplacesCustomInIndex", func(t *testing.T) { testPlainReplacesCustomInIndex(t, b) })
+	t.Run("ProtectedLinksAreNotIndexed", func(t *testing.T) { testProtectedLinksAreNotIndexed(t, b) })
+	t.Run("SplitLinksAreNotIndexed", func(t *testing.T) { testSplitLinksAreNotIndexed(t, b) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, b) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, b) })
+	t.Run("PromoteOnRemove", func(t *testing.T) { testPromoteOnRemove(t, b) })
+	t.Run("List", func(t *testing.T) { testList(t, b) })
+	t.Run("Owners", func(t *testing.T) { testOwners(t, b) })
+	t.Run("LinkSettings", func(t *testing.T) { testLinkSettings(t, b) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, b) })
+	if b.Durable {
+		t.Run("Reopen", func(t *testing.T) { testReopen(t, b) })
+	}
+}
+
+// open opens a store in a fresh directory and closes it when the test ends.
+func open(t *testing.T, b Backend) store.Store {
+	t.Helper()
+	s := b.Open(t, t.TempDir())
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close() error: %v", err)
+		}
+	})
+	return s
+}
+
+func testGetMissing(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	if _, err := s.Get("nope"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v; want ErrNotFound", err)
+	}
+	if _, err := s.GetCodeForURL("", "https://missing.example"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL(missing) error = %v; want ErrNotFound", err)
+	}
+}
+
+func testSetAndGet(t *testing.T, b Backend) {
+	s := open(t, b)
+	created := link("", "").CreatedAt
+
+	mustSet(t, s, "abc123", "https://go.dev")
+	got, err := s.Get("abc123")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.Code != "abc123" || got.URL != "https://go.dev" {
+		t.Errorf("Get() = %+v; want code %q and URL %q", got, "abc123", "https://go.dev")
+	}
+	if !got.CreatedAt.Equal(created) {
+		t.Errorf("Get().CreatedAt = %v; want %v", got.CreatedAt, created)
+	}
+}
+
+func testGetCodeForURL(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	mustSet(t, s, "abc123", "https://go.dev")
+	mustSet(t, s, "xyz789", "https://pkg.go.dev")
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://go.dev", want: "abc123"},
+		{url: "https://pkg.go.dev", want: "xyz789"},
+	}
+	for _, tc := range testCases {
+		got, err := s.GetCodeForURL("", tc.url)
+		if err != nil {
+			t.Fatalf("GetCodeForURL(%q) error: %v", tc.url, err)
+		}
+		if got != tc.want {
+			t.Errorf("GetCodeForURL(%q) = %q; want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func testOverwrite(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	mustSet(t, s, "abc123", "https://go.dev")
+	mustSet(t, s, "abc123", "https://go.dev/blog")
+
+	got, err := s.Get("abc123")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if got.URL != "https://go.dev/blog" {
+		t.Errorf("Get() after overwrite = %q; want %q", got.URL, "https://go.dev/blog")
+	}
+}
+
+func testCreate(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	if err := s.Create(link("launch", "https://go.dev")); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if err := s.Create(link("launch", "https://pkg.go.dev")); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Create() of a taken code error = %v; want ErrExists", err)
+	}
+	// The failed Create must not have changed anything.
+	if got, err := s.Get("launch"); err != nil || got.URL != "https://go.dev" {
+		t.Errorf("Get() after failed Create = %q, %v; want %q", got.URL, err, "https://go.dev")
+	}
+	if _, err := s.GetCodeForURL("", "https://pkg.go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL() after failed Create error = %v; want ErrNotFound", err)
+	}
+}
+
+func testFirstCodeKeepsIndex(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	// A URL first shortened with a random code and later given an alias
+	// keeps returning the random code from GetCodeForURL.
+	mustSet(t, s, "abc123", "https://go.dev")
+	if err := s.Create(link("golang", "https://go.dev")); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "abc123" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "abc123")
+	}
+	if got, err := s.Get("golang"); err != nil || got.URL != "https://go.dev" {
+		t.Errorf("Get(alias) = %q, %v; want %q", got.URL, err, "https://go.dev")
+	}
+}
+
+func testExpiry(t *testing.T, b Backend) {
+	s := open(t, b)
+	now := time.Now()
+
+	expired := link("old", "https://old.example")
+	expired.ExpiresAt = now.Add(-time.Hour)
+	live := link("new", "https://new.example")
+	live.ExpiresAt = now.Add(time.Hour)
+	mustSetLink(t, s, expired)
+	mustSetLink(t, s, live)
+	mustSet(t, s, "forever", "https://forever.example")
+
+	// Expired links are still returned until they are purged.
+	got, err := s.Get("old")
+	if err != nil {
+		t.Fatalf("Get(expired) error: %v", err)
+	}
+	if !got.ExpiresAt.Equal(expired.ExpiresAt) || !got.Expired(now) {
+		t.Errorf("Get(expired).ExpiresAt = %v; want %v", got.ExpiresAt, expired.ExpiresAt)
+	}
+
+	if n, err := s.Count(); err != nil || n != 3 {
+		t.Errorf("Count() = %d, %v; want 3", n, err)
+	}
+
+	n, err := s.DeleteExpired(now)
+	if err != nil {
+		t.Fatalf("DeleteExpired() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpired() = %d; want 1", n)
+	}
+	if _, err := s.Get("old"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get(purged) error = %v; want ErrNotFound", err)
+	}
+	if _, err := s.GetCodeForURL("", "https://old.example"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL(purged) error = %v; want ErrNotFound", err)
+	}
+	for _, code := range []string{"new", "forever"} {
+		if _, err := s.Get(code); err != nil {
+			t.Errorf("Get(%q) after DeleteExpired error: %v", code, err)
+		}
+	}
+	if n, err := s.Count(); err != nil || n != 2 {
+		t.Errorf("Count() after DeleteExpired = %d, %v; want 2", n, err)
+	}
+}
+
+func testPermanentReplacesExpiringInIndex(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	expiring := link("temp", "https://go.dev")
+	expiring.ExpiresAt = time.Now().Add(time.Hour)
+	mustSetLink(t, s, expiring)
+	if got, _ := s.GetCodeForURL("", "https://go.dev"); got != "temp" {
+		t.Fatalf("GetCodeForURL() = %q; want %q", got, "temp")
+	}
+
+	// A permanent link takes over the index from an expiring one...
+	mustSet(t, s, "perm", "https://go.dev")
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "perm" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "perm")
+	}
+	// ...but neither a second permanent link nor another expiring one takes it back.
+	mustSet(t, s, "perm2", "https://go.dev")
+	mustSetLink(t, s, store.Link{Code: "temp2", URL: "https://go.dev", ExpiresAt: expiring.ExpiresAt})
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "perm" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "perm")
+	}
+}
+
+func testPlainReplacesCustomInIndex(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	custom := link("preview", "https://go.dev")
+	custom.InterstitialSeconds = 5
+	mustSetLink(t, s, custom)
+	if got, _ := s.GetCodeForURL("", "https://go.dev"); got != "preview" {
+		t.Fatalf("GetCodeForURL() = %q; want %q", got, "preview")
+	}
+
+	// A link with the default settings takes over the index from a custom one...
+	plain := link("plain", "https://go.dev")
+	plain.RedirectStatus = 302 // the default, spelled out
+	mustSetLink(t, s, plain)
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "plain" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "plain")
+	}
+	// ...but another custom one doesn't take it back, and an expiring plain
+	// one ranks below a permanent custom one.
+	moved := link("moved", "https://go.dev")
+	moved.RedirectStatus = 301
+	mustSetLink(t, s, moved)
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "plain" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "plain")
+	}
+
+	utm := link("campaign", "https://pkg.go.dev")
+	utm.UTMTemplate = "utm_source=mail"
+	mustSetLink(t, s, utm)
+	expiring := link("temp", "https://pkg.go.dev")
+	expiring.ExpiresAt = time.Now().Add(time.Hour)
+	mustSetLink(t, s, expiring)
+	if got, err := s.GetCodeForURL("", "https://pkg.go.dev"); err != nil || got != "campaign" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "campaign")
+	}
+}
+
+func testProtectedLinksAreNotIndexed(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	protected := link("secret", "https://go.dev")
+	protected.PasswordHash = "pbkdf2-sha256$600000$c2FsdA$aGFzaA"
+	mustSetLink(t, s, protected)
+	if got, err := s.GetCodeForURL("", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL() = %q, %v; want ErrNotFound for a protected link", got, err)
+	}
+
+	mustSet(t, s, "open", "https://go.dev")
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "open" {
+		t.Errorf("GetCodeForURL() = %q, %v; want %q", got, err, "open")
+	}
+
+	// Protecting the indexed link takes it out of the index.
+	protected.Code = "open"
+	if err := s.Update(protected); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if got, err := s.GetCodeForURL("", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL() after protecting = %q, %v; want ErrNotFound", got, err)
+	}
+}
+
+func testSplitLinksAreNotIndexed(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	split := link("ab", "https://go.dev")
+	split.Variants = []store.Variant{
+		{Name: "A", URL: "https://go.dev", Weight: 1},
+		{Name: "B", URL: "https://go.dev/learn", Weight: 1},
+	}
+	mustSetLink(t, s, split)
+	if got, err := s.GetCodeForURL("", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL() = %q, %v; want ErrNotFound for a split link", got, err)
+	}
+
+	// Ending the split puts the link back in the index.
+	split.Variants = nil
+	if err := s.Update(split); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "ab" {
+		t.Errorf("GetCodeForURL() after ending the split = %q, %v; want %q", got, err, "ab")
+	}
+}
+
+func testUpdate(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	if err := s.Update(link("nope", "https://go.dev")); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Update(missing) error = %v; want ErrNotFound", err)
+	}
+
+	mustSet(t, s, "abc123", "https://go.dev")
+	if err := s.Update(link("abc123", "https://pkg.go.dev")); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if got, err := s.Get("abc123"); err != nil || got.URL != "https://pkg.go.dev" {
+		t.Errorf("Get() after Update = %q, %v; want %q", got.URL, err, "https://pkg.go.dev")
+	}
+	// The old URL must no longer lead to the code, and the new one must.
+	if _, err := s.GetCodeForURL("", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL(old URL) error = %v; want ErrNotFound", err)
+	}
+	if got, err := s.GetCodeForURL("", "https://pkg.go.dev"); err != nil || got != "abc123" {
+		t.Errorf("GetCodeForURL(new URL) = %q, %v; want %q", got, err, "abc123")
+	}
+	if _, err := s.Get("nope"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get() after failed Update error = %v; want ErrNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	if err := s.Delete("nope"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Delete(missing) error = %v; want ErrNotFound", err)
+	}
+
+	mustSet(t, s, "abc123", "https://go.dev")
+	mustSet(t, s, "golang", "https://go.dev")
+	if err := s.Delete("abc123"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := s.Get("abc123"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get(deleted) error = %v; want ErrNotFound", err)
+	}
+	// Deleting the indexed code hands the index to the other code.
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "golang" {
+		t.Errorf("GetCodeForURL() after Delete = %q, %v; want %q", got, err, "golang")
+	}
+	if got, err := s.Get("golang"); err != nil || got.URL != "https://go.dev" {
+		t.Errorf("Get(other code) after Delete = %q, %v; want %q", got.URL, err, "https://go.dev")
+	}
+	if n, err := s.Count(); err != nil || n != 1 {
+		t.Errorf("Count() after Delete = %d, %v; want 1", n, err)
+	}
+}
+
+func testPromoteOnRemove(t *testing.T, b Backend) {
+	s := open(t, b)
+	now := time.Now()
+
+	// When the index holder goes away, the best remaining link takes over:
+	// the highest rank, and among equals the oldest.
+	mustSet(t, s, "first", "https://go.dev")
+	custom := link("custom", "https://go.dev")
+	custom.RedirectStatus = 301
+	mustSetLink(t, s, custom)
+	newer := link("newer", "https://go.dev")
+	newer.CreatedAt = newer.CreatedAt.Add(time.Hour)
+	mustSetLink(t, s, newer)
+	older := link("older", "https://go.dev")
+	older.CreatedAt = older.CreatedAt.Add(-time.Hour)
+	mustSetLink(t, s, older)
+	protected := link("secret", "https://go.dev")
+	protected.PasswordHash = "hash"
+	mustSetLink(t, s, protected)
+
+	for _, step := range []struct{ remove, want string }{
+		{"first", "older"},
+		{"older", "newer"},
+		{"newer", "custom"},
+	} {
+		if err := s.Delete(step.remove); err != nil {
+			t.Fatalf("Delete(%q) error: %v", step.remove, err)
+		}
+		if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != step.want {
+			t.Errorf("GetCodeForURL() after Delete(%q) = %q, %v; want %q", step.remove, got, err, step.want)
+		}
+	}
+	// A protected link is never promoted.
+	if err := s.Delete("custom"); err != nil {
+		t.Fatalf("Delete(%q) error: %v", "custom", err)
+	}
+	if got, err := s.GetCodeForURL("", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL() with only a protected link left = %q, %v; want ErrNotFound", got, err)
+	}
+
+	// Moving the holder to another URL promotes too.
+	mustSet(t, s, "abc123", "https://pkg.go.dev")
+	mustSet(t, s, "golang", "https://pkg.go.dev")
+	if err := s.Update(link("abc123", "https://example.com")); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if got, err := s.GetCodeForURL("", "https://pkg.go.dev"); err != nil || got != "golang" {
+		t.Errorf("GetCodeForURL() after Update = %q, %v; want %q", got, err, "golang")
+	}
+
+	// So does purging an expired holder, and only for the owner it belonged to.
+	expired := link("expired", "https://go.dev/blog")
+	expired.Owner = "acme"
+	expired.ExpiresAt = now.Add(-time.Hour)
+	mustSetLink(t, s, expired)
+	later := link("later", "https://go.dev/blog")
+	later.Owner = "acme"
+	later.ExpiresAt = now.Add(time.Hour)
+	mustSetLink(t, s, later)
+	mustSet(t, s, "shared", "https://go.dev/blog")
+	if _, err := s.DeleteExpired(now); err != nil {
+		t.Fatalf("DeleteExpired() error: %v", err)
+	}
+	if got, err := s.GetCodeForURL("acme", "https://go.dev/blog"); err != nil || got != "later" {
+		t.Errorf("GetCodeForURL(owner) after DeleteExpired = %q, %v; want %q", got, err, "later")
+	}
+	if got, err := s.GetCodeForURL("", "https://go.dev/blog"); err != nil || got != "shared" {
+		t.Errorf("GetCodeForURL() after DeleteExpired = %q, %v; want %q", got, err, "shared")
+	}
+}
+
+func testList(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	if links, err := s.List(store.ListOptions{Limit: 10}); err != nil || len(links) != 0 {
+		t.Errorf("List() on empty store = %v, %v; want no links", links, err)
+	}
+
+	codes := []string{"delta", "alpha", "echo", "charlie", "bravo"}
+	for _, code := range codes {
+		mustSet(t, s, code, "https://example.com/"+code)
+	}
+
+	// Walk the store two links at a time, as a paginating client would.
+	var got []string
+	after := ""
+	for page := 0; page < 10; page++ {
+		links, err := s.List(store.ListOptions{After: after, Limit: 2})
+		if err != nil {
+			t.Fatalf("List(%q, 2) error: %v", after, err)
+		}
+		if len(links) > 2 {
+			t.Fatalf("List(%q, 2) returned %d links", after, len(links))
+		}
+		if len(links) == 0 {
+			break
+		}
+		for _, l := range links {
+			if l.URL != "https://example.com/"+l.Code {
+				t.Errorf("List() link %q has URL %q", l.Code, l.URL)
+			}
+			got = append(got, l.Code)
+		}
+		after = links[len(links)-1].Code
+	}
+
+	want := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("paged List() = %v; want %v", got, want)
+	}
+}
+
+func testOwners(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	acme := link("acme1", "https://go.dev")
+	acme.Owner = "acme"
+	globex := link("globex1", "https://go.dev")
+	globex.Owner = "globex"
+	mustSetLink(t, s, acme)
+	mustSetLink(t, s, globex)
+	mustSet(t, s, "anon1", "https://go.dev")
+
+	// Every owner has their own URL index, so nobody is handed someone
+	// else's code for the same URL.
+	for owner, want := range map[string]string{"acme": "acme1", "globex": "globex1", "": "anon1"} {
+		if got, err := s.GetCodeForURL(owner, "https://go.dev"); err != nil || got != want {
+			t.Errorf("GetCodeForURL(%q) = %q, %v; want %q", owner, got, err, want)
+		}
+	}
+	if _, err := s.GetCodeForURL("initech", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL(other owner) error = %v; want ErrNotFound", err)
+	}
+
+	if got, err := s.Get("acme1"); err != nil || got.Owner != "acme" {
+		t.Errorf("Get().Owner = %q, %v; want %q", got.Owner, err, "acme")
+	}
+
+	links, err := s.List(store.ListOptions{Limit: 10, Owner: "acme"})
+	if err != nil {
+		t.Fatalf("List(owner) error: %v", err)
+	}
+	if len(links) != 1 || links[0].Code != "acme1" {
+		t.Errorf("List(owner) = %v; want only %q", links, "acme1")
+	}
+	if links, err := s.List(store.ListOptions{Limit: 10}); err != nil || len(links) != 3 {
+		t.Errorf("List() without owner = %d links, %v; want 3", len(links), err)
+	}
+
+	// Handing a link to another owner moves its index entry too.
+	acme.Owner = "globex"
+	if err := s.Update(acme); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if _, err := s.GetCodeForURL("acme", "https://go.dev"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetCodeForURL(previous owner) error = %v; want ErrNotFound", err)
+	}
+}
+
+func testConcurrent(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	const workers, perWorker = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				code := fmt.Sprintf("w%di%d", w, i)
+				if err := s.Set(link(code, "https://example.com/"+code)); err != nil {
+					t.Errorf("Set(%q) error: %v", code, err)
+					return
+				}
+				if _, err := s.Get(code); err != nil {
+					t.Errorf("Get(%q) error: %v", code, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < perWorker; i++ {
+			code := fmt.Sprintf("w%di%d", w, i)
+			if got, err := s.GetCodeForURL("", "https://example.com/"+code); err != nil || got != code {
+				t.Errorf("GetCodeForURL(%q) = %q, %v; want %q", code, got, err, code)
+			}
+		}
+	}
+}
+
+// testLinkSettings checks that the optional per-link settings survive Set,
+// Get, Update and List unchanged.
+func testLinkSettings(t *testing.T, b Backend) {
+	s := open(t, b)
+
+	want := link("slow", "https://go.dev")
+	want.InterstitialSeconds = 5
+	want.RedirectStatus = 301
+	want.ForwardQuery = true
+	want.UTMTemplate = "utm_source=mail&utm_campaign={code}"
+	want.PasswordHash = "pbkdf2-sha256$600000$c2FsdA$aGFzaA"
+	want.Variants = []store.Variant{
+		{Name: "A", URL: "https://go.dev", Weight: 3},
+		{Name: "B", URL: "https://go.dev/learn", Weight: 1},
+	}
+	want.Sticky = "cookie"
+	mustSetLink(t, s, want)
+	check := func(when string, got store.Link) {
+		t.Helper()
+		if got.InterstitialSeconds != want.InterstitialSeconds {
+			t.Errorf("%s: InterstitialSeconds = %d; want %d", when, got.InterstitialSeconds, want.InterstitialSeconds)
+		}
+		if got.RedirectStatus != want.RedirectStatus || got.ForwardQuery != want.ForwardQuery || got.UTMTemplate != want.UTMTemplate {
+			t.Errorf("%s: redirect settings = %d, %t, %q; want %d, %t, %q", when,
+				got.RedirectStatus, got.ForwardQuery, got.UTMTemplate,
+				want.RedirectStatus, want.ForwardQuery, want.UTMTemplate)
+		}
+		if got.PasswordHash != want.PasswordHash {
+			t.Errorf("%s: PasswordHash = %q; want %q", when, got.PasswordHash, want.PasswordHash)
+		}
+		if !slices.Equal(got.Variants, want.Variants) || got.Sticky != want.Sticky {
+			t.Errorf("%s: split = %+v, %q; want %+v, %q", when, got.Variants, got.Sticky, want.Variants, want.Sticky)
+		}
+	}
+
+	got, err := s.Get("slow")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	check("Get", got)
+
+	want.InterstitialSeconds = 10
+	want.RedirectStatus = 308
+	want.ForwardQuery = false
+	want.UTMTemplate = ""
+	want.PasswordHash = ""
+	want.Variants = nil
+	want.Sticky = ""
+	if err := s.Update(want); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	got, err = s.Get("slow")
+	if err != nil {
+		t.Fatalf("Get() after Update error: %v", err)
+	}
+	check("Get after Update", got)
+
+	links, err := s.List(store.ListOptions{Limit: 10})
+	if err != nil || len(links) != 1 {
+		t.Fatalf("List() = %d links, %v; want 1", len(links), err)
+	}
+	check("List", links[0])
+}
+
+func testReopen(t *testing.T, b Backend) {
+	dir := t.TempDir()
+
+	s := b.Open(t, dir)
+	mustSet(t, s, "abc123", "https://go.dev")
+	mustSet(t, s, "xyz789", "https://pkg.go.dev")
+	mustSet(t, s, "golang", "https://go.dev")
+	owned := link("owned", "https://go.dev")
+	owned.Owner = "acme"
+	owned.InterstitialSeconds = 3
+	mustSetLink(t, s, owned)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	s = b.Open(t, dir)
+	defer s.Close()
+	if got, err := s.Get("owned"); err != nil || got.Owner != "acme" {
+		t.Errorf("Get().Owner after reopen = %q, %v; want %q", got.Owner, err, "acme")
+	} else if got.InterstitialSeconds != 3 {
+		t.Errorf("Get().InterstitialSeconds after reopen = %d; want 3", got.InterstitialSeconds)
+	}
+	if got, err := s.GetCodeForURL("acme", "https://go.dev"); err != nil || got != "owned" {
+		t.Errorf("GetCodeForURL(owner) after reopen = %q, %v; want %q", got, err, "owned")
+	}
+	if got, err := s.Get("xyz789"); err != nil || got.URL != "https://pkg.go.dev" {
+		t.Errorf("Get() after reopen = %q, %v; want %q", got.URL, err, "https://pkg.go.dev")
+	}
+	if got, err := s.GetCodeForURL("", "https://go.dev"); err != nil || got != "abc123" {
+		t.Errorf("GetCodeForURL() after reopen = %q, %v; want %q", got, err, "abc123")
+	}
+}
+
+// link returns a permanent link with a fixed creation time. The time is
+// rounded to the second, because not every database keeps nanoseconds.
+func link(code, url string) store.Link {
+	return store.Link{
+		Code:      code,
+		URL:       url,
+		CreatedAt: time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func mustSet(t *testing.T, s store.Store, code, url string) {
+	t.Helper()
+	mustSetLink(t, s, link(code, url))
+}
+
+func mustSetLink(t *testing.T, s store.Store, l store.Link) {
+	t.Helper()
+	if err := s.Set(l); err != nil {
+		t.Fatalf("Set(%+v) error: %v", l, err)
+	}
+}
@@ -0,0 +1,571 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+/*
+This is the transfer package. It moves links in and out of a store as plain
+files, to migrate them between environments or keep a backup that any tool
+can read. Two formats are supported:
+
+  - JSON LINES (JSONL): one JSON object per line, with exactly the fields of
+    store.Link. Fields without a value are left out.
+
+    {"code":"aB3dC9","url":"https://go.dev/","created_at":"2026-05-01T10:00:00Z"}
+
+  - CSV with a header row naming the columns (see Columns). Empty cells mean
+    "no value", and times are RFC 3339. The variants of a split link are a
+    JSON array in their cell, as in JSONL.
+
+Both are written and read one link at a time, so an export never holds the
+whole store in memory. An import does read its whole input before it writes
+anything, so that a bad line or (with ConflictFail) a clash with an existing
+code stops it before the store has changed at all.
+
+The store keeps two indexes: `urls` (code -> link) and `codes` (owner and URL
+-> the code that requests for that URL get, see store.Store). Every link is
+written with Store.Set, which keeps both in step, and links are written
+OLDEST FIRST: "the first code for a URL" then means the same in the new store
+as it did in the old one, so the `codes` index comes out as if the links had
+been created there one by one.
+
+Imported links are trusted as they are: they aren't canonicalized or checked
+against the destination policy again, and password hashes and owners are kept.
+*/
+
+// Format is a file format for links.
+type Format string
+
+const (
+	JSONL Format = "jsonl"
+	CSV   Format = "csv"
+)
+
+// ParseFormat parses the name of a format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case JSONL, CSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (want jsonl or csv)", s)
+}
+
+// ContentType returns the MIME type of files in the format.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/jsonl"
+}
+
+// Columns are the columns of a CSV export, in order. They are named like
+// the JSON fields of store.Link.
+var Columns = []string{
+	"code", "url", "created_at", "expires_at", "owner", "interstitial_seconds",
+	"redirect_status", "forward_query", "utm_template", "password_hash",
+	"variants", "sticky",
+}
+
+// exportPageSize is how many links Export asks the store for at a time.
+const exportPageSize = 500
+
+// Export writes every link in s to w, in code order, and returns how many
+// it wrote. It stops early if ctx is cancelled.
+func Export(ctx context.Context, w io.Writer, s store.Store, f Format) (int, error) {
+	enc := newEncoder(w, f)
+	n := 0
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		links, err := s.List(store.ListOptions{After: after, Limit: exportPageSize})
+		if err != nil {
+			return n, fmt.Errorf("list links: %w", err)
+		}
+		for _, link := range links {
+			if err := enc.encode(link); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(links) < exportPageSize {
+			return n, enc.flush()
+		}
+		after = links[len(links)-1].Code
+	}
+}
+
+// encoder writes links in one format.
+type encoder interface {
+	encode(link store.Link) error
+	flush() error
+}
+
+func newEncoder(w io.Writer, f Format) encoder {
+	if f == CSV {
+		return &csvEncoder{w: csv.NewWriter(w)}
+	}
+	return jsonEncoder{json.NewEncoder(w)}
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e jsonEncoder) encode(link store.Link) error {
+	return e.enc.Encode(link)
+}
+
+func (e jsonEncoder) flush() error {
+	return nil
+}
+
+type csvEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (e *csvEncoder) encode(link store.Link) error {
+	variants := ""
+	if len(link.Variants) > 0 {
+		b, err := json.Marshal(link.Variants)
+		if err != nil {
+			return err
+		}
+		variants = string(b)
+	}
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		if err := e.w.Write(Columns); err != nil {
+			return err
+		}
+	}
+	return e.w.Write([]string{
+		link.Code,
+		link.URL,
+		formatTime(link.CreatedAt),
+		formatTime(link.ExpiresAt),
+		link.Owner,
+		formatInt(link.InterstitialSeconds),
+		formatInt(link.RedirectStatus),
+		formatBool(link.ForwardQuery),
+		link.UTMTemplate,
+		link.PasswordHash,
+		variants,
+		link.Sticky,
+	})
+}
+
+// flush writes the header even if there were no links, so that an empty
+// export is still a valid file to import.
+func (e *csvEncoder) flush() error {
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		e.w.Write(Columns)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func formatBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+// InputError is a problem with the links being read, as opposed to a
+// failure to read them at all or to store them.
+type InputError struct {
+	Line int
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads links written by Export.
+type Decoder struct {
+	next func() (store.Link, error)
+	line int
+}
+
+// maxLineBytes bounds one line of a JSONL file.
+const maxLineBytes = 1 << 20
+
+// NewDecoder returns a Decoder that reads links in format f from r.
+func NewDecoder(r io.Reader, f Format) *Decoder {
+	d := &Decoder{}
+	if f == CSV {
+		d.next = d.csvReader(r)
+	} else {
+		d.next = d.jsonReader(r)
+	}
+	return d
+}
+
+// Next returns the next link, or io.EOF after the last one. Problems with
+// the input are returned as *InputError.
+func (d *Decoder) Next() (store.Link, error) {
+	link, err := d.next()
+	if err != nil {
+		return store.Link{}, err
+	}
+	if err := validate(link); err != nil {
+		return store.Link{}, d.inputError(err)
+	}
+	return link, nil
+}
+
+func (d *Decoder) inputError(err error) error {
+	return &InputError{Line: d.line, Err: err}
+}
+
+func (d *Decoder) jsonReader(r io.Reader) func() (store.Link, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxLineBytes)
+	return func() (store.Link, error) {
+		for sc.Scan() {
+			d.line++
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			// Refuse fields we don't know rather than silently dropping
+			// them: they may come from a newer version of the service.
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.DisallowUnknownFields()
+			var link store.Link
+			if err := dec.Decode(&link); err != nil {
+				return store.Link{}, d.inputError(err)
+			}
+			return link, nil
+		}
+		if err := sc.Err(); errors.Is(err, bufio.ErrTooLong) {
+			d.line++
+			return store.Link{}, d.inputError(err)
+		} else if err != nil {
+			return store.Link{}, err
+		}
+		return store.Link{}, io.EOF
+	}
+}
+
+func (d *Decoder) csvReader(r io.Reader) func() (store.Link, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var columns []string
+	return func() (store.Link, error) {
+		record, err := cr.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return store.Link{}, &InputError{Line: parseErr.Line, Err: parseErr.Err}
+		}
+		if err != nil {
+			return store.Link{}, err
+		}
+		d.line, _ = cr.FieldPos(0)
+		if columns == nil {
+			columns = record
+			if err := checkColumns(columns); err != nil {
+				return store.Link{}, d.inputError(err)
+			}
+			return d.next()
+		}
+		if len(record) != len(columns) {
+			return store.Link{}, d.inputError(fmt.Errorf("%d fields, but the header has %d", len(record), len(columns)))
+		}
+		link, err := parseRecord(columns, record)
+		if err != nil {
+			return store.Link{}, d.inputError(err)
+		}
+		return link, nil
+	}
+}
+
+// checkColumns checks the header row of a CSV file. The columns may come in
+// any order, and all but code and url may be left out.
+func checkColumns(columns []string) error {
+	for i, c := range columns {
+		if !slices.Contains(Columns, c) {
+			return fmt.Errorf("unknown column %q", c)
+		}
+		if slices.Contains(columns[:i], c) {
+			return fmt.Errorf("column %q appears twice", c)
+		}
+	}
+	if !slices.Contains(columns, "code") || !slices.Contains(columns, "url") {
+		return errors.New("the header must name the code and url columns")
+	}
+	return nil
+}
+
+func parseRecord(columns, record []string) (store.Link, error) {
+	var link store.Link
+	for i, value := range record {
+		if value == "" {
+			continue
+		}
+		var err error
+		switch columns[i] {
+		case "code":
+			link.Code = value
+		case "url":
+			link.URL = value
+		case "created_at":
+			link.CreatedAt, err = time.Parse(time.RFC3339Nano, value)
+		case "expires_at":
+			link.ExpiresAt, err = time.Parse(time.RFC3339Nano, value)
+		case "owner":
+			link.Owner = value
+		case "interstitial_seconds":
+			link.InterstitialSeconds, err = strconv.Atoi(value)
+		case "redirect_status":
+			link.RedirectStatus, err = strconv.Atoi(value)
+		case "forward_query":
+			link.ForwardQuery, err = strconv.ParseBool(value)
+		case "utm_template":
+			link.UTMTemplate = value
+		case "password_hash":
+			link.PasswordHash = value
+		case "variants":
+			err = json.Unmarshal([]byte(value), &link.Variants)
+		case "sticky":
+			link.Sticky = value
+		}
+		if err != nil {
+			return store.Link{}, fmt.Errorf("invalid %s %q", columns[i], value)
+		}
+	}
+	return link, nil
+}
+
+// validate checks the parts of a link that the rest of the service relies on.
+func validate(link store.Link) error {
+	if link.Code == "" {
+		return errors.New("code is missing")
+	}
+	for _, r := range link.Code {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("code %q may only contain letters, digits, - and _", link.Code)
+		}
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("url %q of code %q is not an absolute URL", link.URL, link.Code)
+	}
+	if link.InterstitialSeconds < 0 {
+		return fmt.Errorf("interstitial_seconds of code %q is negative", link.Code)
+	}
+	if err := store.ValidateRedirectStatus(link.RedirectStatus); err != nil {
+		return fmt.Errorf("code %q: %w", link.Code, err)
+	}
+	if err := store.ValidateUTMTemplate(link.UTMTemplate); err != nil {
+		return fmt.Errorf("code %q: %w", link.Code, err)
+	}
+	for _, v := range link.Variants {
+		if u, err := url.Parse(v.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("variant %q of code %q does not have an absolute URL", v.Name, link.Code)
+		}
+		if v.Name == "" || v.Weight <= 0 {
+			return fmt.Errorf("variants of code %q need a name and a positive weight", link.Code)
+		}
+	}
+	if len(link.Variants) > 0 && link.URL != link.Variants[0].URL {
+		return fmt.Errorf("url of code %q must be the URL of its first variant", link.Code)
+	}
+	return nil
+}
+
+// Conflict says what Import does with a link whose code already exists in
+// the store with different contents.
+type Conflict string
+
+const (
+	// ConflictSkip keeps the stored link.
+	ConflictSkip Conflict = "skip"
+	// ConflictOverwrite replaces the stored link with the imported one.
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictFail stops the import before anything is written.
+	ConflictFail Conflict = "fail"
+)
+
+// ParseConflict parses the name of a conflict mode.
+func ParseConflict(s string) (Conflict, error) {
+	switch c := Conflict(strings.ToLower(s)); c {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown conflict mode %q (want skip, overwrite or fail)", s)
+}
+
+// ErrConflict is returned by Import with ConflictFail when codes clash.
+var ErrConflict = errors.New("codes are taken by different links")
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// Conflict is what happens to codes that already exist. The default is
+	// ConflictSkip.
+	Conflict Conflict
+	// DryRun reads and checks everything and reports what would happen,
+	// but writes nothing.
+	DryRun bool
+}
+
+// maxReportedConflicts bounds Result.Conflicts.
+const maxReportedConflicts = 100
+
+// Result reports what an import did, or would do in a dry run.
+type Result struct {
+	// Read is the number of links in the input.
+	Read int `json:"read"`
+	// Created links had a code that was free.
+	Created int `json:"created"`
+	// Unchanged links were already stored exactly like this.
+	Unchanged int `json:"unchanged"`
+	// Overwritten and Skipped links had a code that was taken by a different link.
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+	// Conflicts lists the codes that were taken by a different link (at most
+	// the first 100).
+	Conflicts []string `json:"conflicts,omitempty"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+}
+
+// Import reads links in format f from r and stores them in s. Problems with
+// the input are reported, as *InputError, before anything is written. It stops early if ctx
+// is cancelled, and may then have imported some of the links.
+func Import(ctx context.Context, s store.Store, r io.Reader, f Format, opts ImportOptions) (Result, error) {
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictSkip
+	}
+	res := Result{DryRun: opts.DryRun}
+
+	links, err := readAll(NewDecoder(r, f))
+	if err != nil {
+		return res, err
+	}
+	res.Read = len(links)
+
+	// Sort out which links are new before writing any of them, so that a
+	// dry run and ConflictFail know the outcome without touching the store.
+	conflicts := 0
+	plan := make([]bool, len(links)) // true: write this link
+	for i, link := range links {
+		stored, err := s.Get(link.Code)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			plan[i] = true
+			res.Created++
+		case err != nil:
+			return res, fmt.Errorf("look up %q: %w", link.Code, err)
+		case reflect.DeepEqual(normalize(stored), normalize(link)):
+			res.Unchanged++
+		default:
+			conflicts++
+			if len(res.Conflicts) < maxReportedConflicts {
+				res.Conflicts = append(res.Conflicts, link.Code)
+			}
+			switch opts.Conflict {
+			case ConflictOverwrite:
+				plan[i] = true
+				res.Overwritten++
+			case ConflictSkip:
+				res.Skipped++
+			}
+		}
+	}
+	if opts.Conflict == ConflictFail && conflicts > 0 {
+		return res, fmt.Errorf("%w (%d)", ErrConflict, conflicts)
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+
+	for i, link := range links {
+		if !plan[i] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if err := s.Set(link); err != nil {
+			return res, fmt.Errorf("store %q: %w", link.Code, err)
+		}
+	}
+	return res, nil
+}
+
+// readAll reads every link from d, oldest first (see the package comment).
+// A code may appear only once.
+func readAll(d *Decoder) ([]store.Link, error) {
+	var links []store.Link
+	seen := make(map[string]bool)
+	for {
+		link, err := d.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seen[link.Code] {
+			return nil, d.inputError(fmt.Errorf("code %q appears twice", link.Code))
+		}
+		seen[link.Code] = true
+		links = append(links, link)
+	}
+	slices.SortStableFunc(links, func(a, b store.Link) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return links, nil
+}
+
+// normalize makes equal links compare equal with reflect.DeepEqual, wherever
+// their times came from: comparing time.Time values also compares time zones
+// and monotonic clock readings, which say nothing about the moment itself.
+// Likewise, no variants may be a nil or an empty slice.
+func normalize(link store.Link) store.Link {
+	link.CreatedAt = link.CreatedAt.UTC()
+	link.ExpiresAt = link.ExpiresAt.UTC()
+	if len(link.Variants) == 0 {
+		link.Variants = nil
+	}
+	return link
+}
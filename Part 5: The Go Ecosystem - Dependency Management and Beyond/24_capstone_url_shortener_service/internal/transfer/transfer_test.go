@@ -0,0 +1,147 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dunamismax/Go-from-the-Ground-Up/Part5_The_Go_Ecosystem/24_capstone_url_shortener_service/internal/store"
+)
+
+var day = time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// sample returns a store with links that use every field.
+func sample(t *testing.T) *store.URLStore {
+	t.Helper()
+	s := store.NewURLStore()
+	for _, link := range []store.Link{
+		{Code: "first", URL: "https://go.dev/", CreatedAt: day},
+		{Code: "alias", URL: "https://go.dev/", CreatedAt: day.Add(time.Hour), Owner: "k1"},
+		{Code: "again", URL: "https://go.dev/", CreatedAt: day.Add(2 * time.Hour)},
+		{Code: "full", URL: "https://example.com/a,b?q=\"x\"", CreatedAt: day, ExpiresAt: day.Add(24 * time.Hour),
+			InterstitialSeconds: 5, RedirectStatus: 301, ForwardQuery: true,
+			UTMTemplate: "utm_source=mail&utm_campaign={code}", PasswordHash: "pbkdf2-sha256$1$c2FsdA$a2V5"},
+		{Code: "split", URL: "https://go.dev/a", CreatedAt: day, Sticky: "cookie", Variants: []store.Variant{
+			{Name: "A", URL: "https://go.dev/a", Weight: 1}, {Name: "B", URL: "https://go.dev/b?x=1,2", Weight: 3},
+		}},
+	} {
+		if err := s.Set(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []Format{JSONL, CSV} {
+		t.Run(string(f), func(t *testing.T) {
+			src := sample(t)
+			var buf bytes.Buffer
+			n, err := Export(context.Background(), &buf, src, f)
+			if err != nil || n != 5 {
+				t.Fatalf("Export() = %d, %v; want 5 links", n, err)
+			}
+
+			dst := store.NewURLStore()
+			res, err := Import(context.Background(), dst, &buf, f, ImportOptions{})
+			if err != nil {
+				t.Fatalf("Import() error: %v", err)
+			}
+			if res.Read != 5 || res.Created != 5 {
+				t.Errorf("Import() = %+v, want 5 read and created", res)
+			}
+			for _, code := range []string{"first", "alias", "again", "full", "split"} {
+				want, _ := src.Get(code)
+				got, err := dst.Get(code)
+				if err != nil || !reflect.DeepEqual(normalize(got), normalize(want)) {
+					t.Errorf("Get(%q) = %+v, %v; want %+v", code, got, err, want)
+				}
+			}
+			// "again" is exported before "first", but "first" is older, so
+			// requests for the URL must still get "first".
+			if code, _ := dst.GetCodeForURL("", "https://go.dev/"); code != "first" {
+				t.Errorf("GetCodeForURL() = %q, want first", code)
+			}
+		})
+	}
+}
+
+func TestEmptyCSVExportCanBeImported(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := Export(context.Background(), &buf, store.NewURLStore(), CSV); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Import(context.Background(), store.NewURLStore(), &buf, CSV, ImportOptions{})
+	if err != nil || res.Read != 0 {
+		t.Errorf("Import() = %+v, %v; want nothing read", res, err)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	input := `{"code":"first","url":"https://go.dev/","created_at":"2026-05-01T10:00:00Z"}
+{"code":"again","url":"https://changed.example/"}
+{"code":"new","url":"https://new.example/"}
+`
+	for _, tt := range []struct {
+		opts    ImportOptions
+		want    Result
+		wantErr error
+		again   string // URL of "again" afterwards
+	}{
+		{ImportOptions{Conflict: ConflictSkip}, Result{Read: 3, Created: 1, Unchanged: 1, Skipped: 1, Conflicts: []string{"again"}}, nil, "https://go.dev/"},
+		{ImportOptions{Conflict: ConflictOverwrite}, Result{Read: 3, Created: 1, Unchanged: 1, Overwritten: 1, Conflicts: []string{"again"}}, nil, "https://changed.example/"},
+		{ImportOptions{Conflict: ConflictFail}, Result{Read: 3, Created: 1, Unchanged: 1, Conflicts: []string{"again"}}, ErrConflict, "https://go.dev/"},
+		{ImportOptions{Conflict: ConflictOverwrite, DryRun: true}, Result{Read: 3, Created: 1, Unchanged: 1, Overwritten: 1, Conflicts: []string{"again"}, DryRun: true}, nil, "https://go.dev/"},
+	} {
+		s := sample(t)
+		res, err := Import(context.Background(), s, strings.NewReader(input), JSONL, tt.opts)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%+v: Import() error = %v, want %v", tt.opts, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(res, tt.want) {
+			t.Errorf("%+v: Import() = %+v, want %+v", tt.opts, res, tt.want)
+		}
+		if link, _ := s.Get("again"); link.URL != tt.again {
+			t.Errorf("%+v: again points at %q, want %q", tt.opts, link.URL, tt.again)
+		}
+		_, err = s.Get("new")
+		if written := err == nil; written != (tt.wantErr == nil && !tt.opts.DryRun) {
+			t.Errorf("%+v: new was written: %t", tt.opts, written)
+		}
+	}
+}
+
+func TestBadInput(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		input  string
+		want   string
+	}{
+		{JSONL, `{"code":"a","url":"https://a.example/"}` + "\n\n" + `{"code":"b","url":"not a url"}`, "line 3: "},
+		{JSONL, `{"code":"a","url":"https://a.example/","colour":"red"}`, "line 1: "},
+		{JSONL, `{"code":"a/b","url":"https://a.example/"}`, "line 1: "},
+		{JSONL, `{"code":"a","url":"https://a.example/"}` + "\n" + `{"code":"a","url":"https://b.example/"}`, "appears twice"},
+		{CSV, "url\nhttps://a.example/\n", "code and url"},
+		{CSV, "code,url,colour\na,https://a.example/,red\n", "unknown column"},
+		{CSV, "code,url,redirect_status\na,https://a.example/,302\nb,https://b.example/,often\n", "line 3: invalid redirect_status"},
+		{CSV, "code,url,redirect_status\na,https://a.example/,200\n", "line 2: code \"a\": redirect_status must be"},
+		{JSONL, `{"code":"a","url":"https://a.example/","redirect_status":1000}`, "line 1: code \"a\": redirect_status must be"},
+		{JSONL, `{"code":"a","url":"https://a.example/","redirect_status":-1}`, "redirect_status must be"},
+		{JSONL, `{"code":"a","url":"https://a.example/","utm_template":"ref=mail"}`, "utm_template may only set"},
+		{CSV, "code,url\na,https://a.example/,extra\n", "line 2: "},
+	} {
+		s := store.NewURLStore()
+		_, err := Import(context.Background(), s, strings.NewReader(tt.input), tt.format, ImportOptions{})
+		var inputErr *InputError
+		if !errors.As(err, &inputErr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Import(%q) error = %v, want an *InputError mentioning %q", tt.input, err, tt.want)
+		}
+		if n, _ := s.Count(); n != 0 {
+			t.Errorf("Import(%q) stored %d links despite the error", tt.input, n)
+		}
+	}
+}